@@ -0,0 +1,276 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+const (
+	filePrefix = "events-"
+	fileSuffix = ".jsonl.gz"
+	dayLayout  = "2006-01-02"
+
+	// maxOpenDays bounds the day files written at once, events of past days keep
+	// arriving from imports and relays that held them back
+	maxOpenDays = 3
+	maxLine     = 1 << 20
+)
+
+// FileStore is the ContentStore that keeps every event as a json line, in gzip files of
+// the day the event was ingested. Files are only appended to by the FileStore that
+// created them, every FileStore starts its own file for a day, so a crash can only
+// leave the end of a file unreadable.
+type FileStore struct {
+	dir string
+
+	mu    sync.Mutex
+	open  map[string]*dayFile
+	clock uint64 // orders the use of the open files
+	now   func() time.Time
+}
+
+type dayFile struct {
+	file     *os.File
+	gz       *gzip.Writer
+	lastUsed uint64
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive dir: %w", err)
+	}
+	return &FileStore{
+		dir:  dir,
+		open: map[string]*dayFile{},
+		now:  time.Now,
+	}, nil
+}
+
+func (s *FileStore) Name() string {
+	return "archive_file_store"
+}
+
+// Store appends the event to the file of its day, it's flushed before Store returns
+func (s *FileStore) Store(_ context.Context, event types.ParsedEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode archived event: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := s.dayFile(event.Ingestion.UTC().Format(dayLayout))
+	if err != nil {
+		return err
+	}
+	if _, err := f.gz.Write(line); err != nil {
+		return fmt.Errorf("failed to write archived event: %w", err)
+	}
+	if err := f.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to flush archived event: %w", err)
+	}
+	return nil
+}
+
+// Close ends the files being written
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for day := range s.open {
+		errs = append(errs, s.closeDay(day))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *FileStore) dayFile(day string) (*dayFile, error) {
+	s.clock++
+	if f, ok := s.open[day]; ok {
+		f.lastUsed = s.clock
+		return f, nil
+	}
+
+	if len(s.open) >= maxOpenDays {
+		var oldest string
+		for d, f := range s.open {
+			if oldest == "" || f.lastUsed < s.open[oldest].lastUsed {
+				oldest = d
+			}
+		}
+		if err := s.closeDay(oldest); err != nil {
+			return nil, err
+		}
+	}
+
+	name := filePrefix + day + "-" + strconv.FormatInt(s.now().UnixNano(), 36) + fileSuffix
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	f := &dayFile{file: file, gz: gzip.NewWriter(file), lastUsed: s.clock}
+	s.open[day] = f
+	return f, nil
+}
+
+func (s *FileStore) closeDay(day string) error {
+	f := s.open[day]
+	delete(s.open, day)
+	if err := f.gz.Close(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("failed to close archive file: %w", err)
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close archive file: %w", err)
+	}
+	return nil
+}
+
+// Query selects archived events, the zero value selects every event
+type Query struct {
+	From      time.Time // ingested at or after, unbounded when zero
+	To        time.Time // ingested before, unbounded when zero
+	IPAddress string
+	Username  string
+	Service   types.ServiceName
+}
+
+func (q Query) Match(event types.ParsedEvent) bool {
+	switch {
+	case !q.From.IsZero() && event.Ingestion.Before(q.From):
+		return false
+	case !q.To.IsZero() && !event.Ingestion.Before(q.To):
+		return false
+	case q.IPAddress != "" && event.IPAddress.Address != q.IPAddress:
+		return false
+	case q.Username != "" && event.Username.Name != q.Username:
+		return false
+	case q.Service != "" && event.ServiceName != q.Service:
+		return false
+	}
+	return true
+}
+
+// includesDay reports if events of the day can match the time range of the query
+func (q Query) includesDay(day time.Time) bool {
+	if !q.From.IsZero() && !day.AddDate(0, 0, 1).After(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !day.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// Query iterates over the archived events that match q, by day and in the order they were
+// stored within a day. Files of the days in range are read whether or not they're still written.
+func (s *FileStore) Query(ctx context.Context, q Query) iter.Seq2[types.ParsedEvent, error] {
+	return func(yield func(types.ParsedEvent, error) bool) {
+		files, err := s.files(q)
+		if err != nil {
+			yield(types.ParsedEvent{}, err)
+			return
+		}
+		for _, file := range files {
+			for event, err := range readFile(file) {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					yield(types.ParsedEvent{}, ctxErr)
+					return
+				}
+				if err != nil {
+					if !yield(types.ParsedEvent{}, err) {
+						return
+					}
+					continue
+				}
+				if q.Match(event) && !yield(event, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// files returns the files of the days the query covers, sorted by day and creation
+func (s *FileStore) files(q Query) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive dir: %w", err)
+	}
+	var rtn []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, filePrefix)
+		if len(rest) < len(dayLayout) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, rest[:len(dayLayout)])
+		if err != nil || !q.includesDay(day) {
+			continue
+		}
+		rtn = append(rtn, filepath.Join(s.dir, name))
+	}
+	slices.Sort(rtn)
+	return rtn, nil
+}
+
+// readFile reads the events of an archive file, a file that wasn't closed ends at its
+// last flushed event
+func readFile(path string) iter.Seq2[types.ParsedEvent, error] {
+	return func(yield func(types.ParsedEvent, error) bool) {
+		f, err := os.Open(path)
+		if err != nil {
+			yield(types.ParsedEvent{}, fmt.Errorf("failed to open archive file: %w", err))
+			return
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(bufio.NewReader(f))
+		if errors.Is(err, io.EOF) {
+			// created but nothing was flushed yet
+			return
+		}
+		if err != nil {
+			yield(types.ParsedEvent{}, fmt.Errorf("failed to read archive file %s: %w", path, err))
+			return
+		}
+		defer gz.Close()
+
+		scanner := bufio.NewScanner(gz)
+		scanner.Buffer(make([]byte, 0, 64<<10), maxLine)
+		for scanner.Scan() {
+			var event types.ParsedEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				if !yield(types.ParsedEvent{}, fmt.Errorf("failed to decode archived event in %s: %w", path, err)) {
+					return
+				}
+				continue
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			yield(types.ParsedEvent{}, fmt.Errorf("failed to read archive file %s: %w", path, err))
+		}
+	}
+}
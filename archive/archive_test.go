@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func event(at time.Time, ip, username string, service types.ServiceName) types.ParsedEvent {
+	return types.ParsedEvent{
+		ServiceName: service,
+		Hostname:    "host",
+		Content:     "Failed password for " + username + " from " + ip,
+		Ingestion:   at,
+		IPAddress:   types.IPAddress{Address: ip},
+		Username:    types.Username{Name: username},
+	}
+}
+
+func query(t *testing.T, s *FileStore, q Query) []types.ParsedEvent {
+	t.Helper()
+	var events []types.ParsedEvent
+	for event, err := range s.Query(context.Background(), q) {
+		assert.NoError(t, err)
+		events = append(events, event)
+	}
+	return events
+}
+
+func contents(events []types.ParsedEvent) []string {
+	var rtn []string
+	for _, e := range events {
+		rtn = append(rtn, e.IPAddress.Address+" "+e.Username.Name)
+	}
+	return rtn
+}
+
+func TestFileStore(t *testing.T) {
+	day := time_help.Now().Truncate(24 * time.Hour)
+
+	t.Run("stores by day and queries", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := NewFileStore(dir)
+		assert.NoError(t, err)
+
+		ctx := context.Background()
+		assert.NoError(t, s.Store(ctx, event(day.Add(time.Hour), "1.1.1.1", "root", types.SSHDService)))
+		assert.NoError(t, s.Store(ctx, event(day.Add(2*time.Hour), "2.2.2.2", "admin", types.SSHDService)))
+		assert.NoError(t, s.Store(ctx, event(day.Add(25*time.Hour), "1.1.1.1", "admin", types.CowrieService)))
+		assert.NoError(t, s.Close())
+
+		// a second store of the same dir writes its own files
+		s, err = NewFileStore(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Store(ctx, event(day.Add(3*time.Hour), "3.3.3.3", "root", types.SSHDService)))
+		assert.NoError(t, s.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "events-*.jsonl.gz"))
+		assert.NoError(t, err)
+		assert.Len(t, files, 3)
+
+		assert.Equal(t, []string{"1.1.1.1 root", "2.2.2.2 admin", "3.3.3.3 root", "1.1.1.1 admin"}, contents(query(t, s, Query{})))
+		assert.Equal(t, []string{"1.1.1.1 root", "1.1.1.1 admin"}, contents(query(t, s, Query{IPAddress: "1.1.1.1"})))
+		assert.Equal(t, []string{"2.2.2.2 admin", "1.1.1.1 admin"}, contents(query(t, s, Query{Username: "admin"})))
+		assert.Equal(t, []string{"1.1.1.1 admin"}, contents(query(t, s, Query{Service: types.CowrieService})))
+		assert.Equal(t, []string{"2.2.2.2 admin", "3.3.3.3 root"}, contents(query(t, s, Query{
+			From: day.Add(2 * time.Hour),
+			To:   day.Add(24 * time.Hour),
+		})))
+		assert.Equal(t, []string{"1.1.1.1 admin"}, contents(query(t, s, Query{From: day.Add(24 * time.Hour)})))
+
+		events := query(t, s, Query{Username: "root", To: day.Add(2 * time.Hour)})
+		assert.Len(t, events, 1)
+		assert.Equal(t, "Failed password for root from 1.1.1.1", events[0].Content)
+		assert.True(t, day.Add(time.Hour).Equal(events[0].Ingestion))
+	})
+
+	t.Run("reads files still written", func(t *testing.T) {
+		s, err := NewFileStore(t.TempDir())
+		assert.NoError(t, err)
+		defer s.Close()
+
+		assert.NoError(t, s.Store(context.Background(), event(day, "1.1.1.1", "root", types.SSHDService)))
+		assert.NoError(t, s.Store(context.Background(), event(day, "2.2.2.2", "root", types.SSHDService)))
+		assert.Equal(t, []string{"1.1.1.1 root", "2.2.2.2 root"}, contents(query(t, s, Query{})))
+	})
+
+	t.Run("skips empty files", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := NewFileStore(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "events-2038-01-19-0.jsonl.gz"), nil, 0o640))
+		assert.Empty(t, query(t, s, Query{}))
+	})
+
+	t.Run("closes the least recently used day", func(t *testing.T) {
+		s, err := NewFileStore(t.TempDir())
+		assert.NoError(t, err)
+		defer s.Close()
+
+		for i := range maxOpenDays + 1 {
+			assert.NoError(t, s.Store(context.Background(), event(day.AddDate(0, 0, i), "1.1.1.1", "root", types.SSHDService)))
+		}
+		assert.Len(t, s.open, maxOpenDays)
+		assert.NotContains(t, s.open, day.Format(dayLayout))
+		assert.Len(t, query(t, s, Query{}), maxOpenDays+1)
+	})
+}
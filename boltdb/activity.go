@@ -0,0 +1,125 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"go.etcd.io/bbolt"
+)
+
+// SetActivityBuckets makes the stores count the events of every ip address, username and
+// service in ActivityBucket nodes of the resolutions, none are kept by default
+func (c *BoltClient) SetActivityBuckets(resolutions ...types.ActivityResolution) {
+	c.activity = resolutions
+}
+
+// activityGraph counts the event on the ACTIVE_IN relationships of the nodes to the
+// buckets it falls in, like the neo4j activity cypher
+func activityGraph(g *graph, nodes authNodes, event types.ParsedEvent, attempt opt.Optional[types.AuthAttempt]) {
+	subjects := []*entity{nodes.ip, nodes.service}
+	if nodes.username != nil {
+		subjects = append(subjects, nodes.username)
+	}
+	for _, r := range g.activity {
+		bucket := g.node("ActivityBucket", "resolution", string(r), "start", r.Start(event.Ingestion).Format(time.RFC3339))
+		for _, subject := range subjects {
+			active := g.rel(subject, "ACTIVE_IN", bucket).
+				onCreate(props{"events": 0, "attempts": 0, "successes": 0, "failures": 0}).
+				add("events", 1)
+			// attempts only count when they are linked to a username like AUTHENTICATED_ON
+			if nodes.username == nil || !attempt.IsPresent() {
+				continue
+			}
+			active.add("attempts", 1)
+			if attempt.Value.Success {
+				active.add("successes", 1)
+			} else {
+				active.add("failures", 1)
+			}
+		}
+	}
+}
+
+// timelineLabels are the labels of the subjects of a timeline query
+var timelineLabels = map[types.ActivitySubject]string{
+	types.IPAddressActivity: "IPAddress",
+	types.UsernameActivity:  "Username",
+	types.ServiceActivity:   "Service",
+}
+
+// Timeline returns the activity buckets of the subject in order, buckets without events are left out
+func (c *BoltClient) Timeline(ctx context.Context, q types.TimelineQuery) ([]types.ActivityBucket, error) {
+	label, ok := timelineLabels[q.Subject]
+	if !ok {
+		return nil, fmt.Errorf("unknown activity subject %q", q.Subject)
+	}
+	// services are keyed by name, port and host, the timeline of a name sums them
+	prefix := []byte(label + keySeparator + q.Value + endSeparator)
+	if q.Subject == types.ServiceActivity {
+		prefix = []byte(label + keySeparator + q.Value + keySeparator)
+	}
+	bucketPrefix := "ActivityBucket" + keySeparator + string(q.Resolution) + keySeparator
+
+	byStart := map[string]*types.ActivityBucket{}
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(relBucketPrefix + "ACTIVE_IN"))
+		if b == nil {
+			return nil
+		}
+		cursor := b.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			_, to, _ := strings.Cut(string(k), endSeparator)
+			start, ok := strings.CutPrefix(to, bucketPrefix)
+			if !ok || !timelineIncludes(q, start) {
+				continue
+			}
+			var p props
+			if err := decodeProps(v, &p); err != nil {
+				return fmt.Errorf("failed to decode activity %q: %w", k, err)
+			}
+			bucket, ok := byStart[start]
+			if !ok {
+				ts, err := time.Parse(time.RFC3339, start)
+				if err != nil {
+					return fmt.Errorf("failed to read activity bucket %q: %w", start, err)
+				}
+				bucket = &types.ActivityBucket{Start: ts, Resolution: q.Resolution}
+				byStart[start] = bucket
+			}
+			bucket.Events += p.int("events")
+			bucket.Attempts += p.int("attempts")
+			bucket.Successes += p.int("successes")
+			bucket.Failures += p.int("failures")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s %s timeline: %w", q.Subject, q.Value, err)
+	}
+
+	buckets := make([]types.ActivityBucket, 0, len(byStart))
+	for _, bucket := range byStart {
+		buckets = append(buckets, *bucket)
+	}
+	slices.SortFunc(buckets, func(a, b types.ActivityBucket) int {
+		return a.Start.Compare(b.Start)
+	})
+	return buckets, nil
+}
+
+// timelineIncludes reports if the bucket starting at start, in RFC 3339 UTC, is in the time range of q
+func timelineIncludes(q types.TimelineQuery, start string) bool {
+	if !q.From.IsZero() && start < q.From.UTC().Format(time.RFC3339) {
+		return false
+	}
+	if !q.To.IsZero() && start >= q.To.UTC().Format(time.RFC3339) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivity(t *testing.T) {
+	c := newTestClient(t)
+	c.SetActivityBuckets(types.HourActivity, types.DayActivity)
+	store := NewBoltAuth(c)
+	event := func(d time.Duration, ip, username string, port int, success bool) types.ParsedEvent {
+		return types.ParsedEvent{
+			Ingestion:   time_help.Now().Add(d),
+			ServiceName: types.DovecotService,
+			IPAddress:   types.IPAddress{Address: ip},
+			Username:    types.Username{Name: username},
+			Service:     types.Service{Name: "dovecot", Port: port, Host: "mx"},
+			Auth:        opt.Some(types.AuthAttempt{Success: success}),
+		}
+	}
+	for _, e := range []types.ParsedEvent{
+		event(0, "203.0.113.7", "info", 993, false),
+		event(time.Minute, "203.0.113.7", "info", 993, true),
+		event(time.Hour, "203.0.113.7", "admin", 143, false),
+		event(time.Hour, "198.51.100.4", "", 993, false),
+		event(24*time.Hour, "203.0.113.7", "info", 993, false),
+	} {
+		assert.NoError(t, store.Store(t.Context(), e))
+	}
+
+	hour := func(d time.Duration) time.Time {
+		return time.Date(2038, 1, 19, 3, 0, 0, 0, time.UTC).Add(d)
+	}
+
+	buckets, err := c.Timeline(t.Context(), types.TimelineQuery{
+		Subject:    types.IPAddressActivity,
+		Value:      "203.0.113.7",
+		Resolution: types.HourActivity,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.ActivityBucket{
+		{Start: hour(0), Resolution: types.HourActivity, Events: 2, Attempts: 2, Successes: 1, Failures: 1},
+		{Start: hour(time.Hour), Resolution: types.HourActivity, Events: 1, Attempts: 1, Failures: 1},
+		{Start: hour(24 * time.Hour), Resolution: types.HourActivity, Events: 1, Attempts: 1, Failures: 1},
+	}, buckets)
+
+	// the service sums its ports, the event without a username has no attempt to count
+	buckets, err = c.Timeline(t.Context(), types.TimelineQuery{
+		Subject:    types.ServiceActivity,
+		Value:      "dovecot",
+		Resolution: types.DayActivity,
+		To:         hour(21 * time.Hour),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []types.ActivityBucket{
+		{Start: hour(-3 * time.Hour), Resolution: types.DayActivity, Events: 4, Attempts: 3, Successes: 1, Failures: 2},
+	}, buckets)
+
+	buckets, err = c.Timeline(t.Context(), types.TimelineQuery{
+		Subject:    types.UsernameActivity,
+		Value:      "info",
+		Resolution: types.HourActivity,
+		From:       hour(30 * time.Minute),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 1)
+	assert.Equal(t, hour(24*time.Hour), buckets[0].Start)
+
+	buckets, err = c.Timeline(t.Context(), types.TimelineQuery{Subject: types.UsernameActivity, Value: "nobody", Resolution: types.HourActivity})
+	assert.NoError(t, err)
+	assert.Empty(t, buckets)
+}
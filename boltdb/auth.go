@@ -0,0 +1,89 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// boltAuth stores the events of any service into the IPAddress, Username and Service graph
+type boltAuth struct {
+	client *BoltClient
+}
+
+func (b *boltAuth) Name() string {
+	return "auth_bolt_store"
+}
+
+func NewBoltAuth(client *BoltClient) boltAuth {
+	return boltAuth{
+		client: client,
+	}
+}
+
+func (b *boltAuth) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" {
+		slog.Debug("Skipping event without an IP address", "service", event.ServiceName, "event", event)
+		return nil
+	}
+	err := b.client.update(func(g *graph) {
+		authGraph(g, event, event.Auth)
+	})
+	if err != nil {
+		slog.Error("Failed to store event in bolt", "service", event.ServiceName, "error", err)
+		return fmt.Errorf("failed to store %s event in bolt: %w", event.ServiceName, err)
+	}
+	return nil
+}
+
+// authNodes are the nodes authGraph merged, username is nil for events without one
+type authNodes struct {
+	service  *entity
+	ip       *entity
+	username *entity
+}
+
+// authGraph merges the service, ip address and username of the event like authCypher
+// does and, for authentication attempts, counts the outcome on AUTHENTICATED_ON and in
+// the activity buckets of the graph
+func authGraph(g *graph, event types.ParsedEvent, attempt opt.Optional[types.AuthAttempt]) authNodes {
+	at := event.Ingestion.Format(time.RFC3339)
+
+	s := g.node("Service", "name", event.Service.Name, "port", event.Service.Port, "host", event.Service.Host).
+		earliest("first_seen", at).
+		add("seen", 1)
+	if event.Syslog.FacilityName != "" {
+		s.set(props{"facility": event.Syslog.FacilityName})
+	}
+	if event.Syslog.Relay != "" {
+		s.set(props{"last_relay": event.Syslog.Relay}).appendUnique("relays", event.Syslog.Relay)
+	}
+
+	ip := g.node("IPAddress", "address", event.IPAddress.Address).seen(at)
+	g.rel(ip, "CONNECTED_TO", s).times(at)
+
+	nodes := authNodes{service: s, ip: ip}
+	if event.Username.Name == "" {
+		activityGraph(g, nodes, event, attempt)
+		return nodes
+	}
+	nodes.username = g.node("Username", "name", event.Username.Name).seen(at)
+	activityGraph(g, nodes, event, attempt)
+	g.rel(ip, "WITH_USERNAME", nodes.username).times(at)
+
+	if attempt.IsPresent() {
+		a := g.rel(nodes.username, "AUTHENTICATED_ON", s).
+			onCreate(props{"successes": 0, "failures": 0}).
+			times(at)
+		if attempt.Value.Success {
+			a.add("successes", 1)
+		} else {
+			a.add("failures", 1)
+		}
+	}
+	return nodes
+}
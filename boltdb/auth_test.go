@@ -0,0 +1,65 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltAuth(c)
+	service := types.Service{Name: "dovecot", Port: 993, Host: "mx"}
+	event := func(d time.Duration, success bool, relay string) types.ParsedEvent {
+		return types.ParsedEvent{
+			Ingestion:   time_help.Now().Add(d),
+			ServiceName: types.DovecotService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Username:    types.Username{Name: "info"},
+			Service:     service,
+			Syslog:      types.SyslogMetadata{FacilityName: "mail", Relay: relay},
+			Auth:        opt.Some(types.AuthAttempt{Success: success, Method: "plain"}),
+		}
+	}
+	assert.NoError(t, store.Store(t.Context(), event(0, false, "192.0.2.10")))
+	assert.NoError(t, store.Store(t.Context(), event(time.Second, false, "192.0.2.11")))
+	assert.NoError(t, store.Store(t.Context(), event(2*time.Second, true, "192.0.2.10")))
+	// without an ip address there is nothing to link
+	assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{Service: service}))
+
+	s := getNode(t, c, "Service", "name", "dovecot", "port", 993, "host", "mx")
+	assert.Equal(t, int64(3), s.int("seen"))
+	assert.Equal(t, "mail", s.string("facility"))
+	assert.Equal(t, "192.0.2.10", s.string("last_relay"))
+	assert.Equal(t, []any{"192.0.2.10", "192.0.2.11"}, s["relays"])
+
+	ip := getNode(t, c, "IPAddress", "address", "203.0.113.7")
+	assert.Equal(t, int64(3), ip.int("seen"))
+	assert.Equal(t, at(2*time.Second), ip.string("last_seen"))
+
+	sRef := ref("Service", "name", "dovecot", "port", 993, "host", "mx")
+	ipRef := ref("IPAddress", "address", "203.0.113.7")
+	usernameRef := ref("Username", "name", "info")
+	assert.Equal(t, int64(3), getRel(t, c, ipRef, "CONNECTED_TO", sRef).int("times"))
+	assert.Equal(t, int64(3), getRel(t, c, ipRef, "WITH_USERNAME", usernameRef).int("times"))
+
+	authenticated := getRel(t, c, usernameRef, "AUTHENTICATED_ON", sRef)
+	assert.Equal(t, int64(3), authenticated.int("times"))
+	assert.Equal(t, int64(1), authenticated.int("successes"))
+	assert.Equal(t, int64(2), authenticated.int("failures"))
+	assert.Equal(t, at(0), authenticated.string("first_time"))
+
+	// an older event, e.g. imported from an archive, only moves the first times back
+	assert.NoError(t, store.Store(t.Context(), event(-time.Hour, false, "")))
+	ip = getNode(t, c, "IPAddress", "address", "203.0.113.7")
+	assert.Equal(t, at(-time.Hour), ip.string("first_seen"))
+	assert.Equal(t, at(2*time.Second), ip.string("last_seen"))
+	authenticated = getRel(t, c, usernameRef, "AUTHENTICATED_ON", sRef)
+	assert.Equal(t, at(-time.Hour), authenticated.string("first_time"))
+	assert.Equal(t, at(2*time.Second), authenticated.string("last_time"))
+	assert.Equal(t, at(-time.Hour), getNode(t, c, "Service", "name", "dovecot", "port", 993, "host", "mx").string("first_seen"))
+}
@@ -0,0 +1,72 @@
+package boltdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/types"
+	"go.etcd.io/bbolt"
+)
+
+// BoltClient keeps the graph in an embedded bolt database, for deployments without Neo4j.
+// The file is locked while it's open, the server and the actions can't share it.
+type BoltClient struct {
+	db  *bbolt.DB
+	now func() time.Time
+
+	// activity are the resolutions of the ActivityBucket nodes the stores count events in
+	activity []types.ActivityResolution
+}
+
+func MustSetupBoltClient() *BoltClient {
+	client, err := NewBoltClient(cfg.GetOr("BOLT_PATH", "ckc.db"))
+	if err != nil {
+		panic("Failed to create bolt client: " + err.Error())
+	}
+	return client
+}
+
+// NewBoltClient opens the database at path, creating it when it doesn't exist
+func NewBoltClient(path string) (*BoltClient, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database %s: %w", path, err)
+	}
+	return &BoltClient{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
+
+// Close closes the bolt database
+func (c *BoltClient) Close() error {
+	return c.db.Close()
+}
+
+// update merges into the graph in a single transaction
+func (c *BoltClient) update(merge func(g *graph)) error {
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		g := newGraph(tx)
+		g.activity = c.activity
+		merge(g)
+		return g.flush()
+	})
+}
+
+// get reads an entity, nil when it doesn't exist
+func (c *BoltClient) get(bucket, key string) (props, error) {
+	var p props
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return decodeProps(v, &p)
+	})
+	return p, err
+}
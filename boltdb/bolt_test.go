@@ -0,0 +1,80 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T) *BoltClient {
+	t.Helper()
+	client, err := NewBoltClient(filepath.Join(t.TempDir(), "ckc.db"))
+	assert.NoError(t, err)
+	client.now = time_help.Now
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// ref names a node for getRel
+func ref(label string, keyProps ...any) *entity {
+	return &entity{bucket: nodeBucketPrefix + label, key: nodeKey(keyProps...)}
+}
+
+func getNode(t *testing.T, c *BoltClient, label string, keyProps ...any) props {
+	t.Helper()
+	p, err := c.get(nodeBucketPrefix+label, nodeKey(keyProps...))
+	assert.NoError(t, err)
+	assert.NotNil(t, p, "missing %s %v", label, keyProps)
+	return p
+}
+
+func getRel(t *testing.T, c *BoltClient, from *entity, relType string, to *entity, keyProps ...any) props {
+	t.Helper()
+	p, err := c.get(relBucketPrefix+relType, relKey(from, to, keyProps...))
+	assert.NoError(t, err)
+	assert.NotNil(t, p, "missing %s from %s to %s", relType, from.key, to.key)
+	return p
+}
+
+func at(d time.Duration) string {
+	return time_help.Now().Add(d).Format(time.RFC3339)
+}
+
+func TestGraph(t *testing.T) {
+	c := newTestClient(t)
+	t.Run("merges on the key properties", func(t *testing.T) {
+		for i := range 2 {
+			err := c.update(func(g *graph) {
+				ip := g.node("IPAddress", "address", "203.0.113.7").seen(at(time.Duration(i) * time.Second))
+				port := g.node("Port", "host", "bastion", "number", 22, "protocol", "TCP").seen(at(0))
+				g.rel(ip, "PROBED", port).times(at(0)).appendUnique("actions", "block")
+			})
+			assert.NoError(t, err)
+		}
+
+		ip := getNode(t, c, "IPAddress", "address", "203.0.113.7")
+		assert.Equal(t, "203.0.113.7", ip.string("address"))
+		assert.Equal(t, int64(2), ip.int("seen"))
+		assert.Equal(t, at(0), ip.string("first_seen"))
+		assert.Equal(t, at(time.Second), ip.string("last_seen"))
+
+		port := getNode(t, c, "Port", "host", "bastion", "number", 22, "protocol", "TCP")
+		assert.Equal(t, int64(22), port.int("number"))
+
+		probed := getRel(t, c, ref("IPAddress", "address", "203.0.113.7"), "PROBED", ref("Port", "host", "bastion", "number", 22, "protocol", "TCP"))
+		assert.Equal(t, int64(2), probed.int("times"))
+		assert.Equal(t, []any{"block"}, probed["actions"])
+	})
+
+	t.Run("an entity merged twice is the same entity", func(t *testing.T) {
+		err := c.update(func(g *graph) {
+			g.node("Username", "name", "root").seen(at(0))
+			g.node("Username", "name", "root").seen(at(0))
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), getNode(t, c, "Username", "name", "root").int("seen"))
+	})
+}
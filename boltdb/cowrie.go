@@ -0,0 +1,86 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+type boltCowrie struct {
+	client *BoltClient
+}
+
+func (b *boltCowrie) Name() string {
+	return "cowrie_bolt_store"
+}
+
+func NewBoltCowrie(client *BoltClient) boltCowrie {
+	return boltCowrie{
+		client: client,
+	}
+}
+
+// Store keeps connections and logins in the same graph as sshd, the commands and
+// downloads of a session only carry the ip address
+func (b *boltCowrie) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" || !event.CowrieEvent.IsPresent() {
+		slog.Debug("Skipping cowrie event without an IP address", "event", event)
+		return nil
+	}
+	cowrieEvent := event.CowrieEvent.OrElse(types.CowrieParsedEvent{})
+	err := b.client.update(func(g *graph) {
+		at := event.Ingestion.Format(time.RFC3339)
+		sensor := event.Service.Host
+
+		var nodes authNodes
+		if cowrieEvent.Command == "" && !cowrieEvent.Download.IsPresent() {
+			nodes = authGraph(g, event, event.Auth)
+		} else {
+			nodes.ip = g.node("IPAddress", "address", event.IPAddress.Address).
+				onCreate(props{"seen": 0}).
+				earliest("first_seen", at).
+				latest("last_seen", at)
+		}
+
+		if cowrieEvent.Password != "" {
+			password := g.node("Password", "value", cowrieEvent.Password).seen(at)
+			g.rel(nodes.ip, "USED_PASSWORD", password).times(at)
+			if nodes.username != nil {
+				up := g.rel(nodes.username, "TRIED_PASSWORD", password).
+					onCreate(props{"successes": 0}).
+					times(at)
+				if event.Auth.OrElse(types.AuthAttempt{}).Success {
+					up.add("successes", 1)
+				}
+			}
+		}
+
+		if cowrieEvent.Command != "" {
+			command := g.node("Command", "command", cowrieEvent.Command).seen(at)
+			g.rel(nodes.ip, "RAN_COMMAND", command, "host", sensor).
+				onCreate(props{"honeypot": true}).
+				times(at).
+				set(props{"last_session": cowrieEvent.Session, "emulated": !cowrieEvent.Failed})
+		}
+
+		if download, ok := cowrieEvent.Download.Value, cowrieEvent.Download.IsPresent(); ok {
+			d := g.node("Download", "sha256", download.SHA256).
+				onCreate(props{"urls": []any{}}).
+				seen(at)
+			if download.URL != "" {
+				d.appendUnique("urls", download.URL)
+			}
+			g.rel(nodes.ip, "DOWNLOADED", d, "host", sensor).
+				times(at).
+				set(props{"last_session": cowrieEvent.Session, "upload": download.Upload})
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to store cowrie event in bolt", "error", err)
+		return fmt.Errorf("failed to store cowrie event in bolt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCowrieStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltCowrie(c)
+	service := types.Service{Name: "cowrie", Port: 2222, Host: "honeypot-1"}
+	event := func(username string, cowrieEvent types.CowrieParsedEvent) types.ParsedEvent {
+		cowrieEvent.Session = "a1b2c3d4e5f6"
+		return types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.CowrieService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Username:    types.Username{Name: username},
+			Service:     service,
+			Auth:        opt.Some(types.AuthAttempt{Success: true, Method: "password"}),
+			CowrieEvent: opt.Some(cowrieEvent),
+		}
+	}
+	download := types.Download{SHA256: "94f2e4d8d4436874785cd14e6e6d403507b8750852f7f2040352069a75da4c00", URL: "http://198.51.100.4/x.sh"}
+	assert.NoError(t, store.Store(t.Context(), event("root", types.CowrieParsedEvent{Password: "admin"})))
+	assert.NoError(t, store.Store(t.Context(), event("", types.CowrieParsedEvent{Command: "uname -a"})))
+	assert.NoError(t, store.Store(t.Context(), event("", types.CowrieParsedEvent{Download: opt.Some(download)})))
+	assert.NoError(t, store.Store(t.Context(), event("", types.CowrieParsedEvent{Download: opt.Some(download)})))
+
+	ipRef := ref("IPAddress", "address", "203.0.113.7")
+	passwordRef := ref("Password", "value", "admin")
+	assert.Equal(t, int64(1), getRel(t, c, ipRef, "USED_PASSWORD", passwordRef).int("times"))
+	assert.Equal(t, int64(1), getRel(t, c, ref("Username", "name", "root"), "TRIED_PASSWORD", passwordRef).int("successes"))
+
+	ran := getRel(t, c, ipRef, "RAN_COMMAND", ref("Command", "command", "uname -a"), "host", "honeypot-1")
+	assert.Equal(t, true, ran["honeypot"])
+	assert.Equal(t, true, ran["emulated"])
+	assert.Equal(t, "a1b2c3d4e5f6", ran.string("last_session"))
+
+	d := getNode(t, c, "Download", "sha256", download.SHA256)
+	assert.Equal(t, int64(2), d.int("seen"))
+	assert.Equal(t, []any{download.URL}, d["urls"])
+	assert.Equal(t, int64(2), getRel(t, c, ipRef, "DOWNLOADED", ref("Download", "sha256", download.SHA256), "host", "honeypot-1").int("times"))
+
+	// only the login counts as a sighting, commands and downloads belong to its session
+	assert.Equal(t, int64(1), getNode(t, c, "IPAddress", "address", "203.0.113.7").int("seen"))
+}
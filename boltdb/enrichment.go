@@ -0,0 +1,204 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	"go.etcd.io/bbolt"
+)
+
+// SaveAIPDBData links the ip address to what AbuseIPDB knows of it, like the neo4j client
+func (c *BoltClient) SaveAIPDBData(ctx context.Context, target types.IPAddress, enrichment types.AIPDBData) error {
+	err := c.update(func(g *graph) {
+		ip := g.node("IPAddress", "address", target.Address)
+		aipdb := g.node("AIPDBData", "address", target.Address).set(props{
+			"isp":            enrichment.Isp,
+			"is_tor":         enrichment.IsTor,
+			"is_public":      enrichment.IsPublic,
+			"is_whitelisted": enrichment.IsWhitelisted,
+		})
+		g.rel(ip, "ENRICHED_BY", aipdb).set(props{"last_enrichment": c.now().Format(time.RFC3339)})
+
+		country := g.node("Country", "country_code", strings.ToLower(enrichment.CountryCode)).
+			set(props{"country_name": enrichment.CountryName})
+		g.rel(ip, "LOCATED_IN", country)
+		g.detach(ip, "LOCATED_IN", "Country", country)
+
+		reportsCount := make(map[types.Country]int64)
+		for _, report := range enrichment.Reports {
+			reportsCount[types.Country{
+				Code: strings.ToLower(report.ReporterCountryCode),
+				Name: report.ReporterCountryName,
+			}]++
+		}
+		for reporter, count := range reportsCount {
+			country := g.node("Country", "country_code", reporter.Code).
+				set(props{"country_name": reporter.Name})
+			g.rel(ip, "REPORTED_IN", country).set(props{"times": count})
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save AIPDB data for %s: %w", target.Address, err)
+	}
+	return nil
+}
+
+// GetLastEnrichedAt returns when the ip address was last enriched with enrichmentType,
+// the zero time when it never was
+func (c *BoltClient) GetLastEnrichedAt(ctx context.Context, target types.IPAddress, enrichmentType string) (time.Time, error) {
+	var lastEnrichment string
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(relBucketPrefix + "ENRICHED_BY"))
+		if b == nil {
+			return nil
+		}
+		// the relationships of the ip to any node of enrichmentType
+		prefix := []byte("IPAddress" + keySeparator + target.Address + endSeparator + enrichmentType + keySeparator)
+		cursor := b.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var p props
+			if err := decodeProps(v, &p); err != nil {
+				return fmt.Errorf("failed to decode enrichment of %s: %w", target.Address, err)
+			}
+			lastEnrichment = max(lastEnrichment, p.string("last_enrichment"))
+		}
+		return nil
+	})
+	if err != nil || lastEnrichment == "" {
+		return time.Time{}, err
+	}
+	ts, err := time.Parse(time.RFC3339, lastEnrichment)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last enrichment time: %w", err)
+	}
+	return ts, nil
+}
+
+// IterOverIPAddresses reads every ip address, they are read up front so the database
+// isn't held by a read transaction while they're enriched
+func (c *BoltClient) IterOverIPAddresses(ctx context.Context) (iter.Seq2[types.IPAddress, error], error) {
+	var ips []types.IPAddress
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(nodeBucketPrefix + "IPAddress"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var p props
+			if err := decodeProps(v, &p); err != nil {
+				return fmt.Errorf("failed to decode IP address %s: %w", k, err)
+			}
+			ip := types.IPAddress{Address: string(k), Seen: p.int("seen")}
+			ip.FirstSeen, _ = time.Parse(time.RFC3339, p.string("first_seen"))
+			ip.LastSeen, _ = time.Parse(time.RFC3339, p.string("last_seen"))
+			ips = append(ips, ip)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IP addresses: %w", err)
+	}
+	return func(yield func(types.IPAddress, error) bool) {
+		for _, ip := range ips {
+			if !yield(ip, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// SaveAIPDBReport records the report of the ip to AbuseIPDB, like the neo4j client
+func (c *BoltClient) SaveAIPDBReport(ctx context.Context, target types.IPAddress, report types.AIPDBSubmission) error {
+	err := c.update(func(g *graph) {
+		ip := g.node("IPAddress", "address", target.Address)
+		feed := g.node("ThreatFeed", "name", "AbuseIPDB")
+		g.rel(ip, "REPORTED_TO_ABUSEIPDB", feed).
+			times(report.ReportedAt.Format(time.RFC3339)).
+			set(props{
+				"categories":             report.Categories,
+				"last_comment":           report.Comment,
+				"abuse_confidence_score": report.AbuseConfidenceScore,
+			})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save AIPDB report for %s: %w", target.Address, err)
+	}
+	return nil
+}
+
+// GetLastReportedAt returns when the ip was last reported to AbuseIPDB, the zero time
+// when it never was
+func (c *BoltClient) GetLastReportedAt(ctx context.Context, target types.IPAddress) (time.Time, error) {
+	var lastTime string
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(relBucketPrefix + "REPORTED_TO_ABUSEIPDB"))
+		if b == nil {
+			return nil
+		}
+		key := "IPAddress" + keySeparator + target.Address + endSeparator + "ThreatFeed" + keySeparator + "AbuseIPDB"
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		var p props
+		if err := decodeProps(v, &p); err != nil {
+			return fmt.Errorf("failed to decode report of %s: %w", target.Address, err)
+		}
+		lastTime = p.string("last_time")
+		return nil
+	})
+	if err != nil || lastTime == "" {
+		return time.Time{}, err
+	}
+	ts, err := time.Parse(time.RFC3339, lastTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last report time: %w", err)
+	}
+	return ts, nil
+}
+
+// SaveGeoIPData locates the ip in its country and city and links it to its ASN, like the
+// neo4j client
+func (c *BoltClient) SaveGeoIPData(ctx context.Context, target types.IPAddress, data types.GeoIPData) error {
+	err := c.update(func(g *graph) {
+		ip := g.node("IPAddress", "address", target.Address).
+			set(props{"geoip_enriched_at": c.now().Format(time.RFC3339)})
+		if data.Latitude != 0 || data.Longitude != 0 {
+			ip.set(props{"latitude": data.Latitude, "longitude": data.Longitude})
+		}
+
+		if code := strings.ToLower(data.CountryCode); code != "" {
+			country := g.node("Country", "country_code", code).
+				set(props{"country_name": data.CountryName})
+			g.rel(ip, "LOCATED_IN", country)
+			g.detach(ip, "LOCATED_IN", "Country", country)
+
+			if data.City != "" {
+				// cities are told apart by their country as names are only unique within one
+				city := g.node("City", "country_code", code, "name", data.City)
+				if data.CityID != 0 {
+					city.set(props{"geoname_id": data.CityID})
+				}
+				g.rel(city, "LOCATED_IN", country)
+				g.rel(ip, "LOCATED_IN", city)
+				g.detach(ip, "LOCATED_IN", "City", city)
+			}
+		}
+
+		if data.ASN != 0 {
+			asn := g.node("ASN", "number", data.ASN).
+				set(props{"organization": data.ASOrganization})
+			g.rel(ip, "BELONGS_TO", asn)
+			g.detach(ip, "BELONGS_TO", "ASN", asn)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save GeoIP data for %s: %w", target.Address, err)
+	}
+	return nil
+}
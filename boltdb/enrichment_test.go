@@ -0,0 +1,145 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnrichment(t *testing.T) {
+	c := newTestClient(t)
+	ip := types.IPAddress{Address: "203.0.113.7"}
+
+	lastEnriched, err := c.GetLastEnrichedAt(t.Context(), ip, "AIPDBData")
+	assert.NoError(t, err)
+	assert.True(t, lastEnriched.IsZero())
+
+	assert.NoError(t, c.SaveAIPDBData(t.Context(), ip, types.AIPDBData{
+		Isp:         "Example Transit",
+		CountryCode: "PT",
+		CountryName: "Portugal",
+		Reports: []types.AIDBReport{
+			{ReporterCountryCode: "DE", ReporterCountryName: "Germany"},
+			{ReporterCountryCode: "DE", ReporterCountryName: "Germany"},
+			{ReporterCountryCode: "PT", ReporterCountryName: "Portugal"},
+		},
+	}))
+
+	lastEnriched, err = c.GetLastEnrichedAt(t.Context(), ip, "AIPDBData")
+	assert.NoError(t, err)
+	assert.Equal(t, time_help.Now(), lastEnriched)
+
+	ipRef := ref("IPAddress", "address", ip.Address)
+	assert.Equal(t, "Example Transit", getNode(t, c, "AIPDBData", "address", ip.Address).string("isp"))
+	assert.Equal(t, "Portugal", getNode(t, c, "Country", "country_code", "pt").string("country_name"))
+	getRel(t, c, ipRef, "LOCATED_IN", ref("Country", "country_code", "pt"))
+	assert.Equal(t, int64(2), getRel(t, c, ipRef, "REPORTED_IN", ref("Country", "country_code", "de")).int("times"))
+	assert.Equal(t, int64(1), getRel(t, c, ipRef, "REPORTED_IN", ref("Country", "country_code", "pt")).int("times"))
+}
+
+func TestIterOverIPAddresses(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltAuth(c)
+	for _, address := range []string{"203.0.113.7", "198.51.100.4", "203.0.113.7"} {
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion: time_help.Now(),
+			IPAddress: types.IPAddress{Address: address},
+			Service:   types.Service{Name: "postfix", Port: 25, Host: "mx"},
+		}))
+	}
+
+	ips, err := c.IterOverIPAddresses(t.Context())
+	assert.NoError(t, err)
+	var got []types.IPAddress
+	for ip, err := range ips {
+		assert.NoError(t, err)
+		got = append(got, ip)
+	}
+	now := time_help.Now().Truncate(time.Second)
+	assert.Equal(t, []types.IPAddress{
+		{Address: "198.51.100.4", Seen: 1, FirstSeen: now, LastSeen: now},
+		{Address: "203.0.113.7", Seen: 2, FirstSeen: now, LastSeen: now},
+	}, got)
+}
+
+func TestAIPDBReport(t *testing.T) {
+	c := newTestClient(t)
+	ip := types.IPAddress{Address: "203.0.113.7"}
+
+	lastReported, err := c.GetLastReportedAt(t.Context(), ip)
+	assert.NoError(t, err)
+	assert.True(t, lastReported.IsZero())
+
+	for _, at := range []time.Time{time_help.Now().Add(-time.Hour), time_help.Now()} {
+		assert.NoError(t, c.SaveAIPDBReport(t.Context(), ip, types.AIPDBSubmission{
+			Categories: []int{18, 22},
+			Comment:    "12 failed authentication attempts on sshd in 4m0s.",
+			ReportedAt: at,
+		}))
+	}
+
+	lastReported, err = c.GetLastReportedAt(t.Context(), ip)
+	assert.NoError(t, err)
+	assert.Equal(t, time_help.Now(), lastReported)
+
+	report := getRel(t, c, ref("IPAddress", "address", ip.Address), "REPORTED_TO_ABUSEIPDB", ref("ThreatFeed", "name", "AbuseIPDB"))
+	assert.Equal(t, int64(2), report.int("times"))
+	assert.Equal(t, "12 failed authentication attempts on sshd in 4m0s.", report.string("last_comment"))
+}
+
+func TestGeoIP(t *testing.T) {
+	c := newTestClient(t)
+	ip := types.IPAddress{Address: "203.0.113.7"}
+	lisbon := types.GeoIPData{
+		CountryCode:    "PT",
+		CountryName:    "Portugal",
+		City:           "Lisbon",
+		CityID:         2267057,
+		Latitude:       38.7167,
+		Longitude:      -9.1333,
+		ASN:            64500,
+		ASOrganization: "Example Transit",
+	}
+	assert.NoError(t, c.SaveGeoIPData(t.Context(), ip, lisbon))
+
+	ipRef := ref("IPAddress", "address", ip.Address)
+	pt := ref("Country", "country_code", "pt")
+	city := ref("City", "country_code", "pt", "name", "Lisbon")
+	assert.Equal(t, "Portugal", getNode(t, c, "Country", "country_code", "pt").string("country_name"))
+	assert.Equal(t, int64(2267057), getNode(t, c, "City", "country_code", "pt", "name", "Lisbon").int("geoname_id"))
+	assert.Equal(t, "Example Transit", getNode(t, c, "ASN", "number", 64500).string("organization"))
+	getRel(t, c, ipRef, "LOCATED_IN", pt)
+	getRel(t, c, ipRef, "LOCATED_IN", city)
+	getRel(t, c, city, "LOCATED_IN", pt)
+	getRel(t, c, ipRef, "BELONGS_TO", ref("ASN", "number", 64500))
+
+	t.Run("the previous city and asn are replaced", func(t *testing.T) {
+		porto := lisbon
+		porto.City, porto.CityID, porto.ASN = "Porto", 2735943, 64501
+		assert.NoError(t, c.SaveGeoIPData(t.Context(), ip, porto))
+
+		getRel(t, c, ipRef, "LOCATED_IN", ref("City", "country_code", "pt", "name", "Porto"))
+		getRel(t, c, ipRef, "BELONGS_TO", ref("ASN", "number", 64501))
+		getRel(t, c, ipRef, "LOCATED_IN", pt)
+		getRel(t, c, city, "LOCATED_IN", pt)
+		for rel, to := range map[string]*entity{"LOCATED_IN": city, "BELONGS_TO": ref("ASN", "number", 64500)} {
+			p, err := c.get(relBucketPrefix+rel, relKey(ipRef, to))
+			assert.NoError(t, err)
+			assert.Nil(t, p, rel)
+		}
+	})
+
+	t.Run("the previous country is replaced", func(t *testing.T) {
+		vigo := lisbon
+		vigo.CountryCode, vigo.CountryName, vigo.City, vigo.CityID = "ES", "Spain", "Vigo", 3105976
+		assert.NoError(t, c.SaveGeoIPData(t.Context(), ip, vigo))
+
+		getRel(t, c, ipRef, "LOCATED_IN", ref("Country", "country_code", "es"))
+		p, err := c.get(relBucketPrefix+"LOCATED_IN", relKey(ipRef, pt))
+		assert.NoError(t, err)
+		assert.Nil(t, p)
+	})
+}
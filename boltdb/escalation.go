@@ -0,0 +1,64 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+type boltEscalation struct {
+	client *BoltClient
+}
+
+func (b *boltEscalation) Name() string {
+	return "escalation_bolt_store"
+}
+
+func NewBoltEscalation(client *BoltClient) boltEscalation {
+	return boltEscalation{
+		client: client,
+	}
+}
+
+// Store hangs the escalation off the same Username nodes the sshd store creates
+func (b *boltEscalation) Store(ctx context.Context, event types.ParsedEvent) error {
+	escalation := event.EscalationEvent.OrElse(types.EscalationParsedEvent{})
+	if event.Username.Name == "" || escalation.ToUser == "" {
+		slog.Debug("Skipping escalation event without users", "event", event)
+		return nil
+	}
+	err := b.client.update(func(g *graph) {
+		at := event.Ingestion.Format(time.RFC3339)
+		username := g.node("Username", "name", event.Username.Name).seen(at)
+		target := g.node("Username", "name", escalation.ToUser).
+			onCreate(props{"seen": 0}).
+			earliest("first_seen", at)
+
+		e := g.rel(username, "ESCALATED_TO", target, "host", event.Hostname, "tool", escalation.Tool).
+			onCreate(props{"successes": 0, "failures": 0, "auth_failures": 0}).
+			times(at)
+		switch escalation.Kind {
+		case types.EscalationCommand, types.EscalationSession:
+			e.add("successes", 1).latest("last_success", at)
+		case types.EscalationAuthFailure:
+			e.add("auth_failures", 1)
+		default:
+			e.add("failures", 1).set(props{"last_reason": escalation.Reason})
+		}
+
+		if escalation.Kind == types.EscalationCommand && escalation.Command != "" {
+			command := g.node("Command", "command", escalation.Command).seen(at)
+			g.rel(username, "RAN_COMMAND", command, "host", event.Hostname, "as_user", escalation.ToUser).
+				times(at).
+				set(props{"pwd": escalation.PWD, "tty": escalation.TTY})
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to store escalation event in bolt", "error", err)
+		return fmt.Errorf("failed to store escalation event in bolt: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscalationStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltEscalation(c)
+	for _, escalation := range []types.EscalationParsedEvent{
+		{Kind: types.EscalationAuthFailure},
+		{Kind: types.EscalationDenied, Reason: "user NOT in sudoers"},
+		{Kind: types.EscalationCommand, Command: "/usr/bin/id", PWD: "/home/deploy", TTY: "pts/0"},
+	} {
+		escalation.Tool = "sudo"
+		escalation.ToUser = "root"
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion:       time_help.Now(),
+			ServiceName:     types.SudoService,
+			Hostname:        "bastion",
+			Username:        types.Username{Name: "deploy"},
+			EscalationEvent: opt.Some(escalation),
+		}))
+	}
+
+	deployRef := ref("Username", "name", "deploy")
+	escalated := getRel(t, c, deployRef, "ESCALATED_TO", ref("Username", "name", "root"), "host", "bastion", "tool", "sudo")
+	assert.Equal(t, int64(3), escalated.int("times"))
+	assert.Equal(t, int64(1), escalated.int("successes"))
+	assert.Equal(t, int64(1), escalated.int("failures"))
+	assert.Equal(t, int64(1), escalated.int("auth_failures"))
+	assert.Equal(t, "user NOT in sudoers", escalated.string("last_reason"))
+
+	// the target of an escalation isn't seen doing anything itself
+	assert.Equal(t, int64(0), getNode(t, c, "Username", "name", "root").int("seen"))
+	ran := getRel(t, c, deployRef, "RAN_COMMAND", ref("Command", "command", "/usr/bin/id"), "host", "bastion", "as_user", "root")
+	assert.Equal(t, "/home/deploy", ran.string("pwd"))
+}
@@ -0,0 +1,55 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+type boltFirewall struct {
+	client *BoltClient
+}
+
+func (b *boltFirewall) Name() string {
+	return "firewall_bolt_store"
+}
+
+func NewBoltFirewall(client *BoltClient) boltFirewall {
+	return boltFirewall{
+		client: client,
+	}
+}
+
+// Store links the ip address to the port it sent packets to, the packets never
+// reached a service so there is no CONNECTED_TO relationship
+func (b *boltFirewall) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" || !event.FirewallEvent.IsPresent() {
+		slog.Debug("Skipping firewall event without an IP address", "event", event)
+		return nil
+	}
+	firewallEvent := event.FirewallEvent.OrElse(types.FirewallParsedEvent{})
+	err := b.client.update(func(g *graph) {
+		at := event.Ingestion.Format(time.RFC3339)
+		ip := g.node("IPAddress", "address", event.IPAddress.Address).seen(at)
+		port := g.node("Port", "host", event.Hostname, "number", firewallEvent.DestinationPort, "protocol", firewallEvent.Protocol).
+			seen(at)
+		p := g.rel(ip, "PROBED", port).
+			onCreate(props{"blocked": 0, "allowed": 0}).
+			times(at).
+			set(props{"last_action": string(firewallEvent.Action)})
+		switch firewallEvent.Action {
+		case types.FirewallBlock:
+			p.add("blocked", 1)
+		case types.FirewallAllow:
+			p.add("allowed", 1)
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to store firewall event in bolt", "error", err)
+		return fmt.Errorf("failed to store firewall event in bolt: %w", err)
+	}
+	return nil
+}
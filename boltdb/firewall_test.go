@@ -0,0 +1,36 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirewallStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltFirewall(c)
+	for _, action := range []types.FirewallAction{types.FirewallBlock, types.FirewallBlock, types.FirewallAllow} {
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.KernelService,
+			Hostname:    "bastion",
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			FirewallEvent: opt.Some(types.FirewallParsedEvent{
+				Action:          action,
+				Protocol:        "TCP",
+				DestinationPort: 3389,
+			}),
+		}))
+	}
+
+	port := getNode(t, c, "Port", "host", "bastion", "number", 3389, "protocol", "TCP")
+	assert.Equal(t, int64(3), port.int("seen"))
+	probed := getRel(t, c, ref("IPAddress", "address", "203.0.113.7"), "PROBED", ref("Port", "host", "bastion", "number", 3389, "protocol", "TCP"))
+	assert.Equal(t, int64(3), probed.int("times"))
+	assert.Equal(t, int64(2), probed.int("blocked"))
+	assert.Equal(t, int64(1), probed.int("allowed"))
+	assert.Equal(t, "allow", probed.string("last_action"))
+}
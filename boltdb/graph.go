@@ -0,0 +1,250 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	"go.etcd.io/bbolt"
+)
+
+// The embedded store keeps the same graph the neo4j stores merge: every label and
+// relationship type is a bucket of json properties. Nodes are keyed by the values
+// of the properties they are merged on, relationships by the keys of both ends and
+// the properties they are merged on.
+const (
+	nodeBucketPrefix = "node:"
+	relBucketPrefix  = "rel:"
+	keySeparator     = "\x00"
+	endSeparator     = "\x01"
+)
+
+// props are the properties of a node or relationship
+type props map[string]any
+
+// int reads a counter, json numbers are decoded as json.Number
+func (p props) int(key string) int64 {
+	switch v := p[key].(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return i
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func (p props) string(key string) string {
+	s, _ := p[key].(string)
+	return s
+}
+
+// entity is a node or relationship merged by a graph
+type entity struct {
+	bucket  string
+	key     string
+	props   props
+	created bool
+}
+
+// onCreate sets the values only when the entity was created by this merge, like ON CREATE SET
+func (e *entity) onCreate(values props) *entity {
+	if e.created {
+		e.set(values)
+	}
+	return e
+}
+
+func (e *entity) set(values props) *entity {
+	for k, v := range values {
+		e.props[k] = v
+	}
+	return e
+}
+
+func (e *entity) add(key string, n int64) *entity {
+	e.props[key] = e.props.int(key) + n
+	return e
+}
+
+// appendUnique adds value to the list property unless it holds it already
+func (e *entity) appendUnique(key string, value any) *entity {
+	list, _ := e.props[key].([]any)
+	for _, v := range list {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return e
+		}
+	}
+	e.props[key] = append(list, value)
+	return e
+}
+
+// earliest and latest set the time property to the earliest or latest of its time and at,
+// so events stored out of order, e.g. by an import of old logs, don't move it back
+func (e *entity) earliest(key, at string) *entity {
+	if current, ok := e.props[key].(string); !ok || before(at, current) {
+		e.props[key] = at
+	}
+	return e
+}
+
+func (e *entity) latest(key, at string) *entity {
+	if current, ok := e.props[key].(string); !ok || before(current, at) {
+		e.props[key] = at
+	}
+	return e
+}
+
+// before compares RFC 3339 times, which don't sort as strings across time zones
+func before(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return ta.Before(tb)
+}
+
+// seen counts a sighting of a node: first_seen and last_seen take in at, seen every time
+func (e *entity) seen(at string) *entity {
+	return e.earliest("first_seen", at).latest("last_seen", at).add("seen", 1)
+}
+
+// times counts a relationship: first_time and last_time take in at, times every time
+func (e *entity) times(at string) *entity {
+	return e.earliest("first_time", at).latest("last_time", at).add("times", 1)
+}
+
+// graph merges nodes and relationships within a bolt transaction, what was merged is
+// written by flush so an entity merged twice by the same event is the same entity
+type graph struct {
+	tx     *bbolt.Tx
+	merged map[string]*entity
+	order  []*entity
+	// detached are the relationships deleted by flush, as bucket and key
+	detached [][2]string
+	err      error // the first entity that couldn't be read, nothing is written then
+
+	// activity are the resolutions authGraph counts activity in
+	activity []types.ActivityResolution
+}
+
+func newGraph(tx *bbolt.Tx) *graph {
+	return &graph{tx: tx, merged: map[string]*entity{}}
+}
+
+// node merges the node of label with the given property name and value pairs
+func (g *graph) node(label string, keyProps ...any) *entity {
+	e := g.merge(nodeBucketPrefix+label, nodeKey(keyProps...))
+	return e.onCreate(pairs(keyProps...))
+}
+
+// rel merges the relationship from -[relType {keyProps}]-> to
+func (g *graph) rel(from *entity, relType string, to *entity, keyProps ...any) *entity {
+	key := relKey(from, to, keyProps...)
+	e := g.merge(relBucketPrefix+relType, key)
+	return e.onCreate(pairs(keyProps...))
+}
+
+func (g *graph) merge(bucket, key string) *entity {
+	id := bucket + endSeparator + key
+	if e, ok := g.merged[id]; ok {
+		return e
+	}
+	e := &entity{bucket: bucket, key: key, props: props{}, created: true}
+	if b := g.tx.Bucket([]byte(bucket)); b != nil {
+		if v := b.Get([]byte(key)); v != nil {
+			e.created = false
+			if err := decodeProps(v, &e.props); err != nil && g.err == nil {
+				g.err = fmt.Errorf("failed to decode %s %q: %w", bucket, key, err)
+			}
+		}
+	}
+	g.merged[id] = e
+	g.order = append(g.order, e)
+	return e
+}
+
+// detach deletes the relationships of relType from the node to any node of label but keep,
+// like MATCH (from)-[r:relType]->(other:label) WHERE other <> keep DELETE r
+func (g *graph) detach(from *entity, relType, label string, keep *entity) {
+	b := g.tx.Bucket([]byte(relBucketPrefix + relType))
+	if b == nil {
+		return
+	}
+	kept := relKey(from, keep)
+	prefix := []byte(endKey(from) + endSeparator + label + keySeparator)
+	cursor := b.Cursor()
+	for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+		if string(k) != kept {
+			g.detached = append(g.detached, [2]string{relBucketPrefix + relType, string(k)})
+		}
+	}
+}
+
+func (g *graph) flush() error {
+	if g.err != nil {
+		return g.err
+	}
+	for _, rel := range g.detached {
+		if err := g.tx.Bucket([]byte(rel[0])).Delete([]byte(rel[1])); err != nil {
+			return fmt.Errorf("failed to delete %s %q: %w", rel[0], rel[1], err)
+		}
+	}
+	for _, e := range g.order {
+		b, err := g.tx.CreateBucketIfNotExists([]byte(e.bucket))
+		if err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", e.bucket, err)
+		}
+		v, err := json.Marshal(e.props)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s %q: %w", e.bucket, e.key, err)
+		}
+		if err := b.Put([]byte(e.key), v); err != nil {
+			return fmt.Errorf("failed to write %s %q: %w", e.bucket, e.key, err)
+		}
+	}
+	return nil
+}
+
+func decodeProps(v []byte, p *props) error {
+	decoder := json.NewDecoder(bytes.NewReader(v))
+	decoder.UseNumber()
+	return decoder.Decode(p)
+}
+
+func nodeKey(keyProps ...any) string {
+	values := make([]string, 0, len(keyProps)/2)
+	for i := 1; i < len(keyProps); i += 2 {
+		values = append(values, fmt.Sprint(keyProps[i]))
+	}
+	return strings.Join(values, keySeparator)
+}
+
+func relKey(from, to *entity, keyProps ...any) string {
+	key := endKey(from) + endSeparator + endKey(to)
+	if len(keyProps) > 0 {
+		key += endSeparator + nodeKey(keyProps...)
+	}
+	return key
+}
+
+// endKey identifies a node at the end of a relationship by its label and key
+func endKey(node *entity) string {
+	return strings.TrimPrefix(node.bucket, nodeBucketPrefix) + keySeparator + node.key
+}
+
+func pairs(keyProps ...any) props {
+	p := props{}
+	for i := 0; i+1 < len(keyProps); i += 2 {
+		p[fmt.Sprint(keyProps[i])] = keyProps[i+1]
+	}
+	return p
+}
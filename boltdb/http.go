@@ -0,0 +1,56 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+type boltHTTP struct {
+	client *BoltClient
+}
+
+func (b *boltHTTP) Name() string {
+	return "http_bolt_store"
+}
+
+func NewBoltHTTP(client *BoltClient) boltHTTP {
+	return boltHTTP{
+		client: client,
+	}
+}
+
+// Store keeps the service, ip address and username like every other service and
+// links the ip address to the probe path it requested
+func (b *boltHTTP) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" {
+		slog.Debug("Skipping HTTP event without an IP address", "event", event)
+		return nil
+	}
+	err := b.client.update(func(g *graph) {
+		nodes := authGraph(g, event, opt.None[types.AuthAttempt]())
+
+		httpEvent := event.HTTPEvent.OrElse(types.HTTPParsedEvent{})
+		if !httpEvent.Probe || httpEvent.Path == "" {
+			return
+		}
+		at := event.Ingestion.Format(time.RFC3339)
+		path := g.node("HttpPath", "path", httpEvent.Path).
+			seen(at).
+			set(props{"probe": httpEvent.Probe, "probe_category": httpEvent.ProbeCategory})
+		g.rel(nodes.ip, "REQUESTED", path).
+			times(at).
+			set(props{"last_status": httpEvent.Status, "last_user_agent": httpEvent.UserAgent}).
+			appendUnique("methods", httpEvent.Method)
+		g.rel(path, "SERVED_BY", nodes.service)
+	})
+	if err != nil {
+		slog.Error("Failed to store HTTP event in bolt", "error", err)
+		return fmt.Errorf("failed to store HTTP event in bolt: %w", err)
+	}
+	return nil
+}
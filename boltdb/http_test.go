@@ -0,0 +1,52 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltHTTP(c)
+	for _, method := range []string{"GET", "POST", "GET"} {
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.HTTPService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Service:     types.Service{Name: "nginx", Port: 443, Host: "web-1"},
+			HTTPEvent: opt.Some(types.HTTPParsedEvent{
+				Method:        method,
+				Path:          "/.env",
+				Status:        404,
+				UserAgent:     "curl/8.5.0",
+				Probe:         true,
+				ProbeCategory: "secrets",
+			}),
+		}))
+	}
+
+	path := getNode(t, c, "HttpPath", "path", "/.env")
+	assert.Equal(t, int64(3), path.int("seen"))
+	assert.Equal(t, "secrets", path.string("probe_category"))
+	requested := getRel(t, c, ref("IPAddress", "address", "203.0.113.7"), "REQUESTED", ref("HttpPath", "path", "/.env"))
+	assert.Equal(t, int64(3), requested.int("times"))
+	assert.Equal(t, []any{"GET", "POST"}, requested["methods"])
+	assert.Equal(t, int64(404), requested.int("last_status"))
+	getRel(t, c, ref("HttpPath", "path", "/.env"), "SERVED_BY", ref("Service", "name", "nginx", "port", 443, "host", "web-1"))
+
+	// paths that aren't probes are left out, every visitor would add their own
+	assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: types.HTTPService,
+		IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+		Service:     types.Service{Name: "nginx", Port: 443, Host: "web-1"},
+		HTTPEvent:   opt.Some(types.HTTPParsedEvent{Method: "GET", Path: "/index.html", Status: 200}),
+	}))
+	p, err := c.get(nodeBucketPrefix+"HttpPath", nodeKey("path", "/index.html"))
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+}
@@ -0,0 +1,129 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+type boltSSHD struct {
+	client *BoltClient
+}
+
+func (b *boltSSHD) Name() string {
+	return "sshd_bolt_store"
+}
+
+func NewBoltSSHD(client *BoltClient) boltSSHD {
+	return boltSSHD{
+		client: client,
+	}
+}
+
+func (b *boltSSHD) Store(ctx context.Context, event types.ParsedEvent) error {
+	sshdEvent := event.SSHDEvent.OrElse(types.SSHDParsedEvent{})
+	if event.IPAddress.Address == "" && sshdEvent.SessionID == "" {
+		// e.g. kex_exchange_identification errors, sshd logs the peer on a separate line
+		slog.Debug("Skipping SSHD event without an IP address or session", "event", event)
+		return nil
+	}
+	err := b.client.update(func(g *graph) {
+		if event.IPAddress.Address == "" {
+			// session lines like pam_unix session closed don't carry the peer
+			b.sessionGraph(g, event, sshdEvent, authNodes{})
+			return
+		}
+		nodes := authGraph(g, event, sshdEvent.Attempt())
+		if key, ok := sshdEvent.PublicKey.Value, sshdEvent.PublicKey.IsPresent(); ok {
+			b.publicKeyGraph(g, event, sshdEvent, *key, nodes)
+		}
+		if sshdEvent.SessionID != "" {
+			b.sessionGraph(g, event, sshdEvent, nodes)
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to store SSHD event in bolt", "error", err)
+		return fmt.Errorf("failed to store SSHD event in bolt: %w", err)
+	}
+	return nil
+}
+
+// publicKeyGraph links the key to the ip and username that presented it
+func (b *boltSSHD) publicKeyGraph(g *graph, event types.ParsedEvent, sshdEvent types.SSHDParsedEvent, key types.PublicKey, nodes authNodes) {
+	at := event.Ingestion.Format(time.RFC3339)
+	k := g.node("PublicKey", "fingerprint", key.Fingerprint).
+		onCreate(props{"type": key.Type, "hash": key.Hash}).
+		seen(at)
+	g.rel(nodes.ip, "PRESENTED_KEY", k).times(at)
+
+	if nodes.username == nil {
+		return
+	}
+	uk := g.rel(nodes.username, "PRESENTED_KEY", k).
+		onCreate(props{"successes": 0, "failures": 0}).
+		times(at)
+	if sshdEvent.Success {
+		uk.add("successes", 1)
+	} else {
+		uk.add("failures", 1)
+	}
+}
+
+// sessionGraph keeps the SSHSession node of the connection, nodes are empty for events
+// without an IP address
+func (b *boltSSHD) sessionGraph(g *graph, event types.ParsedEvent, sshdEvent types.SSHDParsedEvent, nodes authNodes) {
+	at := event.Ingestion.Format(time.RFC3339)
+	sess := g.node("SSHSession", "id", sshdEvent.SessionID).
+		onCreate(props{
+			"host":       event.Hostname,
+			"pid":        event.PID,
+			"started_at": at,
+			"events":     0,
+			"attempts":   0,
+			"failures":   0,
+			"successes":  0,
+		}).
+		set(props{"last_event_at": at}).
+		add("events", 1)
+
+	if nodes.ip != nil {
+		sess.set(props{"ip": event.IPAddress.Address})
+		g.rel(nodes.ip, "OPENED", sess)
+		g.rel(sess, "ON", nodes.service)
+		if sshdEvent.Port != 0 {
+			sess.set(props{"client_port": sshdEvent.Port})
+		}
+	}
+
+	if sshdEvent.Kind.IsAuthAttempt() {
+		sess.add("attempts", 1)
+		if sshdEvent.Success {
+			sess.add("successes", 1).set(props{
+				"outcome":          "accepted",
+				"authenticated_at": at,
+				"username":         event.Username.Name,
+			})
+			if nodes.username != nil {
+				g.rel(sess, "AS_USER", nodes.username)
+			}
+		} else {
+			sess.add("failures", 1)
+			if sess.props.string("outcome") != "accepted" {
+				sess.set(props{"outcome": "failed"})
+			}
+		}
+	}
+
+	if sshdEvent.Kind.EndsSession() {
+		sess.set(props{"ended_at": at})
+		if started, err := time.Parse(time.RFC3339, sess.props.string("started_at")); err == nil {
+			sess.set(props{"duration_seconds": int64(event.Ingestion.Sub(started).Seconds())})
+		}
+		if sess.props.string("outcome") == "" {
+			sess.set(props{"outcome": string(sshdEvent.Kind)})
+		}
+	}
+}
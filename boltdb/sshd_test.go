@@ -0,0 +1,115 @@
+package boltdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHDStore(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltSSHD(c)
+	event := func(d time.Duration, address string, sshdEvent types.SSHDParsedEvent) types.ParsedEvent {
+		sshdEvent.SessionID = "bastion-5774-1"
+		return types.ParsedEvent{
+			Ingestion:   time_help.Now().Add(d),
+			ServiceName: types.SSHDService,
+			Hostname:    "bastion",
+			PID:         5774,
+			IPAddress:   types.IPAddress{Address: address},
+			Username:    types.Username{Name: "root"},
+			Service:     types.Service{Name: "sshd", Port: 22, Host: "bastion"},
+			SSHDEvent:   opt.Some(sshdEvent),
+		}
+	}
+	key := types.PublicKey{Type: "ED25519", Hash: "SHA256", Fingerprint: "SHA256:kPk5nBMNZkbRi3Kh+gW2HrJcBNq0WeX1fNw1nZ0XGOk"}
+
+	assert.NoError(t, store.Store(t.Context(), event(0, "203.0.113.7", types.SSHDParsedEvent{
+		Kind: types.SSHDAuthFailed, Method: "password", Port: 40022,
+	})))
+	assert.NoError(t, store.Store(t.Context(), event(time.Second, "203.0.113.7", types.SSHDParsedEvent{
+		Kind: types.SSHDAuthAccepted, Success: true, Method: "publickey", Port: 40022, PublicKey: opt.Some(key),
+	})))
+	// pam_unix doesn't log the peer of the session it closes
+	assert.NoError(t, store.Store(t.Context(), event(90*time.Second, "", types.SSHDParsedEvent{
+		Kind: types.SSHDSessionClosed,
+	})))
+
+	sess := getNode(t, c, "SSHSession", "id", "bastion-5774-1")
+	assert.Equal(t, "bastion", sess.string("host"))
+	assert.Equal(t, int64(5774), sess.int("pid"))
+	assert.Equal(t, "203.0.113.7", sess.string("ip"))
+	assert.Equal(t, int64(40022), sess.int("client_port"))
+	assert.Equal(t, int64(3), sess.int("events"))
+	assert.Equal(t, int64(2), sess.int("attempts"))
+	assert.Equal(t, int64(1), sess.int("failures"))
+	assert.Equal(t, int64(1), sess.int("successes"))
+	assert.Equal(t, "accepted", sess.string("outcome"))
+	assert.Equal(t, "root", sess.string("username"))
+	assert.Equal(t, at(90*time.Second), sess.string("ended_at"))
+	assert.Equal(t, int64(90), sess.int("duration_seconds"))
+
+	sessRef := ref("SSHSession", "id", "bastion-5774-1")
+	ipRef := ref("IPAddress", "address", "203.0.113.7")
+	usernameRef := ref("Username", "name", "root")
+	getRel(t, c, ipRef, "OPENED", sessRef)
+	getRel(t, c, sessRef, "ON", ref("Service", "name", "sshd", "port", 22, "host", "bastion"))
+	getRel(t, c, sessRef, "AS_USER", usernameRef)
+
+	keyRef := ref("PublicKey", "fingerprint", key.Fingerprint)
+	assert.Equal(t, "ED25519", getNode(t, c, "PublicKey", "fingerprint", key.Fingerprint).string("type"))
+	assert.Equal(t, int64(1), getRel(t, c, ipRef, "PRESENTED_KEY", keyRef).int("times"))
+	assert.Equal(t, int64(1), getRel(t, c, usernameRef, "PRESENTED_KEY", keyRef).int("successes"))
+
+	// the session close isn't an attempt, nor does it count as a sighting of the ip
+	assert.Equal(t, int64(2), getNode(t, c, "IPAddress", "address", "203.0.113.7").int("seen"))
+}
+
+func TestSSHDStoreInvalidUser(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltSSHD(c)
+	// "Invalid user oracle from ..." and then "Failed password for invalid user oracle from ..."
+	for i, kind := range []types.SSHDEventKind{types.SSHDInvalidUser, types.SSHDAuthFailed} {
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion:   time_help.Now().Add(time.Duration(i) * time.Second),
+			ServiceName: types.SSHDService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Username:    types.Username{Name: "oracle"},
+			Service:     types.Service{Name: "sshd", Port: 22, Host: "bastion"},
+			SSHDEvent:   opt.Some(types.SSHDParsedEvent{Kind: kind, Method: "password", Port: 40022, SessionID: "bastion-6002-1"}),
+		}))
+	}
+	sess := getNode(t, c, "SSHSession", "id", "bastion-6002-1")
+	assert.Equal(t, int64(2), sess.int("events"))
+	assert.Equal(t, int64(1), sess.int("attempts"))
+	assert.Equal(t, int64(1), sess.int("failures"))
+
+	authenticated := getRel(t, c, ref("Username", "name", "oracle"), "AUTHENTICATED_ON", ref("Service", "name", "sshd", "port", 22, "host", "bastion"))
+	assert.Equal(t, int64(1), authenticated.int("times"))
+	assert.Equal(t, int64(1), authenticated.int("failures"))
+}
+
+func TestSSHDStoreSessionOutcome(t *testing.T) {
+	c := newTestClient(t)
+	store := NewBoltSSHD(c)
+	for i, kind := range []types.SSHDEventKind{types.SSHDAuthFailed, types.SSHDConnectionClosed} {
+		assert.NoError(t, store.Store(t.Context(), types.ParsedEvent{
+			Ingestion:   time_help.Now().Add(time.Duration(i) * time.Second),
+			ServiceName: types.SSHDService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Username:    types.Username{Name: "admin"},
+			Service:     types.Service{Name: "sshd", Port: 22, Host: "bastion"},
+			SSHDEvent:   opt.Some(types.SSHDParsedEvent{Kind: kind, SessionID: "bastion-6001-1"}),
+		}))
+	}
+	sess := getNode(t, c, "SSHSession", "id", "bastion-6001-1")
+	assert.Equal(t, "failed", sess.string("outcome"))
+	assert.Equal(t, int64(1), sess.int("duration_seconds"))
+
+	authenticated := getRel(t, c, ref("Username", "name", "admin"), "AUTHENTICATED_ON", ref("Service", "name", "sshd", "port", 22, "host", "bastion"))
+	assert.Equal(t, int64(1), authenticated.int("times"))
+}
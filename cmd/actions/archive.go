@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/archive"
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+const archiveUsage = `usage: actions archive query [flags]
+  Prints the archived events of ARCHIVE_DIR that match every flag given, one per line.`
+
+func runArchive(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "query" {
+		return fmt.Errorf("missing archive command\n%s", archiveUsage)
+	}
+
+	flags := flag.NewFlagSet("archive query", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), archiveUsage)
+		flags.PrintDefaults()
+	}
+	from := flags.String("from", "", "events ingested at or after, a date like 2006-01-02 or an RFC 3339 time. Defaults to 24h ago")
+	to := flags.String("to", "", "events ingested before, a date like 2006-01-02 or an RFC 3339 time")
+	ip := flags.String("ip", "", "events of the ip address")
+	username := flags.String("username", "", "events of the username")
+	service := flags.String("service", "", "events of the service, e.g. sshd")
+	asJSON := flags.Bool("json", false, "print the events as json lines")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	q := archive.Query{
+		From:      time.Now().Add(-24 * time.Hour),
+		IPAddress: *ip,
+		Username:  *username,
+		Service:   types.ServiceName(*service),
+	}
+	var err error
+	if *from != "" {
+		if q.From, err = parseQueryTime(*from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = parseQueryTime(*to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+
+	events, err := archive.NewFileStore(cfg.Must("ARCHIVE_DIR"))
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	var matched int
+	for event, err := range events.Query(ctx, q) {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "skipping:", err)
+			continue
+		}
+		matched++
+		if *asJSON {
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n",
+			event.Ingestion.Format("2006-01-02T15:04:05Z07:00"),
+			event.ServiceName,
+			event.Hostname,
+			event.IPAddress.Address,
+			event.Username.Name,
+			strings.ReplaceAll(event.Content, "\t", " "),
+		)
+	}
+	fmt.Fprintf(os.Stderr, "%d events\n", matched)
+	return nil
+}
+
+// parseQueryTime reads a date, as midnight UTC, or an RFC 3339 time
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/EduardoOliveira/ckc/deadletter"
+	"github.com/EduardoOliveira/ckc/handler"
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/storage"
+	"github.com/EduardoOliveira/ckc/types"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const deadLetterUsage = `usage: actions deadletter <command>
+  list              list the dead letters
+  inspect <id>      print a dead letter as json
+  redrive [id...]   push the dead letters back through the pipeline, all of them when no id is given`
+
+func runDeadLetter(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing dead letter command\n%s", deadLetterUsage)
+	}
+
+	store, err := deadletter.NewFileStore(cfg.Must("DEADLETTER_DIR"), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		for letter, err := range store.List() {
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n",
+				letter.ID,
+				letter.FailedAt.Format("2006-01-02T15:04:05Z07:00"),
+				letter.Stage,
+				letter.ServiceName,
+				letter.Component,
+				letter.Error,
+			)
+		}
+		return nil
+	case "inspect":
+		if len(args) != 2 {
+			return fmt.Errorf("inspect takes exactly one id\n%s", deadLetterUsage)
+		}
+		letter, err := store.Get(args[1])
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(letter)
+	case "redrive":
+		return redriveDeadLetters(ctx, store, args[1:])
+	default:
+		return fmt.Errorf("unknown dead letter command %q\n%s", args[0], deadLetterUsage)
+	}
+}
+
+// redriveDeadLetters re-processes the dead letters, the ones failing again are written
+// back as new dead letters by the handler. An original is only removed once it was
+// processed or its new dead letter was written.
+func redriveDeadLetters(ctx context.Context, store *deadletter.FileStore, ids []string) error {
+	var letters []types.DeadLetter
+	for letter, err := range store.List() {
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 || slices.Contains(ids, letter.ID) {
+			letters = append(letters, letter)
+		}
+	}
+	if len(letters) == 0 {
+		fmt.Println("No dead letters to redrive")
+		return nil
+	}
+
+	backend := storage.MustSetup(ctx, false)
+	defer backend.Close(ctx)
+
+	h, err := newHandler(ctx, backend)
+	if err != nil {
+		return err
+	}
+	// the handler writes the dead letters of entries that fail to parse itself, the sink
+	// tells which letters failed again that way
+	sink := &countingSink{DeadLetterSink: store}
+	h.SetDeadLetterSink(sink)
+
+	var redriven []string
+	var failed, kept int
+	for _, letter := range letters {
+		written, lost := sink.written, sink.lost
+		logParts := syslogformat.LogParts(letter.LogParts)
+		if err := h.Process(ctx, logParts); err != nil {
+			h.DeadLetter(ctx, logParts, err)
+		}
+		switch {
+		case sink.lost > lost:
+			// its new dead letter wasn't written, the original is all that's left of it
+			kept++
+			continue
+		case sink.written > written:
+			failed++
+		}
+		redriven = append(redriven, letter.ID)
+	}
+
+	if err := store.Remove(redriven...); err != nil {
+		return fmt.Errorf("failed to remove redriven dead letters: %w", err)
+	}
+	fmt.Printf("Redrove %d dead letters, %d failed again\n", len(redriven), failed)
+	if kept > 0 {
+		return fmt.Errorf("kept %d dead letters that failed again and couldn't be written back", kept)
+	}
+	return nil
+}
+
+// countingSink counts the dead letters written while redriving, and those it failed to write
+type countingSink struct {
+	handler.DeadLetterSink
+	written int
+	lost    int
+}
+
+func (s *countingSink) Write(ctx context.Context, letter types.DeadLetter) error {
+	if err := s.DeadLetterSink.Write(ctx, letter); err != nil {
+		s.lost++
+		return err
+	}
+	s.written++
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/deadletter"
+	"github.com/EduardoOliveira/ckc/enrichment"
+	"github.com/EduardoOliveira/ckc/handler"
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/internal/logparts"
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
+	"github.com/EduardoOliveira/ckc/storage"
+	"github.com/EduardoOliveira/ckc/tail"
+	syslog "gopkg.in/mcuadros/go-syslog.v2"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const importUsage = `usage: actions import [flags] <file>...
+  Replays archived logs through the parsers and stores at the time they were logged.
+  Files may be gzip compressed and hold syslog lines, like auth.log, or the json lines
+  of journalctl -o json and of the ingestion queue. Give the oldest file first, the
+  last seen times of the graph are those of the last event stored.`
+
+// maxImportLine bounds a line of an imported file
+const maxImportLine = 1 << 20
+
+// importProgressEvery is how often the progress of an import is reported
+const importProgressEvery = 5 * time.Second
+
+type importer struct {
+	handler   *handler.Handler
+	format    syslogfmt.Format
+	priority  int
+	batchSize int
+	workers   int
+
+	// year is set when RFC 3164 years follow the previous line instead of the file time
+	year      bool
+	reference time.Time
+
+	lines   int64
+	events  int64
+	skipped int64
+	failed  atomic.Int64
+
+	started    time.Time
+	lastReport time.Time
+}
+
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), importUsage)
+		flags.PrintDefaults()
+	}
+	year := flags.Int("year", 0, "year of the first line of files logged without years, later lines roll over into the next years. By default the year is inferred from the modification time of each file")
+	priority := flags.Int("priority", tail.DefaultPriority, "syslog priority of lines that don't start with one, e.g. 86 for authpriv.info")
+	batchSize := flags.Int("batch", 500, "events read before they are stored, each batch is stored before the next is read")
+	workers := flags.Int("workers", 8, "events of a batch stored concurrently, the lines of a process are stored in order by the same worker")
+	enrich := flags.Bool("enrich", false, "enrich the imported IPs with AbuseIPDB once the import is done")
+	verbose := flags.Bool("v", false, "log every event")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing files to import")
+	}
+	if !*verbose {
+		// years of logs are mostly lines of services we don't parse
+		slog.SetLogLoggerLevel(slog.LevelError)
+	}
+
+	zones, err := syslogfmt.ParseTimezones(cfg.GetOr("SYSLOG_TIMEZONE", "UTC"), cfg.GetOr("SYSLOG_SOURCE_TIMEZONES", ""))
+	if err != nil {
+		return err
+	}
+
+	backend := storage.MustSetup(ctx, false)
+	defer backend.Close(ctx)
+
+	h, err := newHandler(ctx, backend)
+	if err != nil {
+		return err
+	}
+	if dir, ok := cfg.Get("DEADLETTER_DIR"); ok {
+		deadLetters, err := deadletter.NewFileStore(dir, 0, 0)
+		if err != nil {
+			return err
+		}
+		h.SetDeadLetterSink(deadLetters)
+	}
+
+	imp := &importer{
+		handler:   h,
+		priority:  *priority,
+		batchSize: max(*batchSize, 1),
+		workers:   max(*workers, 1),
+		year:      *year > 0,
+		started:   time.Now(),
+	}
+	imp.format = syslogfmt.NewFormat(syslog.Automatic).WithTimezones(zones).WithClock(func() time.Time { return imp.reference })
+	if imp.year {
+		// mid year, so the first line falls in it whatever its month
+		imp.reference = time.Date(*year, time.July, 2, 12, 0, 0, 0, time.UTC)
+	}
+
+	for _, path := range flags.Args() {
+		if err := imp.importFile(ctx, path); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported %d lines in %s, %d events, %d skipped, %d failed to store\n",
+		imp.lines, time.Since(imp.started).Round(time.Second), imp.events, imp.skipped, imp.failed.Load())
+
+	if *enrich {
+		aipdbEnricher, err := enrichment.NewAIPDBEnricher(ctx, enrichment.MustLoadAIPDBConfig(), backend.Enrichments)
+		if err != nil {
+			return err
+		}
+		if err := aipdbEnricher.EnrichAll(ctx); err != nil {
+			return fmt.Errorf("failed to enrich imported IPs: %w", err)
+		}
+	}
+	return nil
+}
+
+func (imp *importer) importFile(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	modTime := info.ModTime()
+	var r io.Reader = bufio.NewReader(f)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip %s: %w", path, err)
+		}
+		defer gz.Close()
+		// gzip keeps the modification time of the file it compressed
+		if !gz.ModTime.IsZero() {
+			modTime = gz.ModTime
+		}
+		r = gz
+	}
+	if !imp.year {
+		imp.reference = modTime
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxImportLine)
+	batch := make([]syslogformat.LogParts, 0, imp.batchSize)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		imp.lines++
+		logParts, err := imp.decode(scanner.Bytes())
+		if err != nil {
+			slog.Warn("Skipping undecodable line", "path", path, "line", imp.lines, "error", err)
+			imp.skipped++
+			continue
+		}
+		if logParts == nil {
+			continue
+		}
+		batch = append(batch, logParts)
+		if len(batch) == imp.batchSize {
+			imp.store(ctx, batch)
+			batch = batch[:0]
+			imp.report(path, false)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	imp.store(ctx, batch)
+	imp.report(path, true)
+	return nil
+}
+
+// decode turns a line into log parts, no log parts are returned for blank lines
+func (imp *importer) decode(line []byte) (syslogformat.LogParts, error) {
+	var logParts syslogformat.LogParts
+	var err error
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' {
+		logParts, err = decodeJSONLine(trimmed)
+	} else {
+		logParts, err = tail.ParseLine(imp.format, line, imp.priority)
+	}
+	if logParts == nil || err != nil {
+		return nil, err
+	}
+
+	// the time it was imported isn't when it was received, and would make every event skewed
+	delete(logParts, syslogfmt.ReceivedAtKey)
+	if ts, ok := logParts["timestamp"].(time.Time); ok && imp.year {
+		imp.reference = ts
+	}
+	return logParts, nil
+}
+
+// decodeJSONLine reads an entry of journalctl -o json or of the ingestion queue
+func decodeJSONLine(line []byte) (syslogformat.LogParts, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode json line: %w", err)
+	}
+	if _, ok := fields["parts"]; ok {
+		var rec logparts.Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("failed to decode queue entry: %w", err)
+		}
+		return syslogformat.LogParts(rec), nil
+	}
+	if _, ok := fields["MESSAGE"]; ok {
+		return tail.JournalLogParts(line, time.Time{})
+	}
+	return nil, errors.New("json line is neither a journal nor a queue entry")
+}
+
+// store processes the batch with the workers, failures are kept as dead letters when enabled.
+// The lines of a process always go to the same worker, parsers correlate them in order.
+// Other lines are stored in any order, the stores keep the earliest and latest times.
+func (imp *importer) store(ctx context.Context, batch []syslogformat.LogParts) {
+	jobs := make([]chan syslogformat.LogParts, imp.workers)
+	var wg sync.WaitGroup
+	for i := range jobs {
+		jobs[i] = make(chan syslogformat.LogParts, 16)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for logParts := range jobs[i] {
+				if err := imp.handler.Process(ctx, logParts); err != nil {
+					imp.handler.DeadLetter(ctx, logParts, err)
+					imp.failed.Add(1)
+				}
+			}
+		}()
+	}
+	for _, logParts := range batch {
+		hash := fnv.New32a()
+		fmt.Fprint(hash, logParts["hostname"], "/", logParts["tag"], "/", logParts["proc_id"])
+		jobs[hash.Sum32()%uint32(len(jobs))] <- logParts
+	}
+	for _, ch := range jobs {
+		close(ch)
+	}
+	wg.Wait()
+	imp.events += int64(len(batch))
+}
+
+func (imp *importer) report(path string, done bool) {
+	if !done && time.Since(imp.lastReport) < importProgressEvery {
+		return
+	}
+	imp.lastReport = time.Now()
+	elapsed := time.Since(imp.started)
+	state := "importing"
+	if done {
+		state = "imported"
+	}
+	fmt.Fprintf(os.Stderr, "%s %s: %d lines, %d events (%.0f/s), %d skipped, %d failed\n",
+		state, path, imp.lines, imp.events, float64(imp.events)/max(elapsed.Seconds(), 1e-3), imp.skipped, imp.failed.Load())
+}
@@ -3,11 +3,12 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/EduardoOliveira/ckc/enrichment"
-	"github.com/EduardoOliveira/ckc/internal/cfg"
 	"github.com/EduardoOliveira/ckc/internal/ptr"
-	"github.com/EduardoOliveira/ckc/neo4j"
+	"github.com/EduardoOliveira/ckc/storage"
 )
 
 func main() {
@@ -21,13 +22,65 @@ func main() {
 		return
 	}
 
-	nClient := neo4j.MustSetupNeo4jClient(context.Background())
+	switch flag.Arg(0) {
+	case "deadletter":
+		if err := runDeadLetter(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "archive":
+		if err := runArchive(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "timeline":
+		if err := runTimeline(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "prune":
+		if err := runPrune(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "migrate":
+		if err := runMigrate(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "import":
+		if err := runImport(context.Background(), flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	backend := storage.MustSetup(context.Background(), false)
+	defer backend.Close(context.Background())
 
 	if ip {
-		aipdbEnricher := enrichment.NewAIPDBEnricher(context.Background(), cfg.Must("AIPDB_API_KEY"), nClient)
+		aipdbEnricher, err := enrichment.NewAIPDBEnricher(context.Background(), enrichment.MustLoadAIPDBConfig(), backend.Enrichments)
+		if err != nil {
+			panic("Failed to setup AIPDB enricher: " + err.Error())
+		}
 		if err := aipdbEnricher.EnrichAll(context.Background()); err != nil {
 			panic("Failed to enrich IPs: " + err.Error())
 		}
+		if config, ok := enrichment.MustLoadGeoIPConfig(); ok {
+			geoIPEnricher, err := enrichment.NewGeoIPEnricher(context.Background(), config, backend.GeoIP)
+			if err != nil {
+				panic("Failed to setup GeoIP enricher: " + err.Error())
+			}
+			if err := geoIPEnricher.EnrichAll(context.Background()); err != nil {
+				panic("Failed to enrich IPs with GeoIP: " + err.Error())
+			}
+		}
 
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/neo4j"
+)
+
+const migrateUsage = `usage: actions migrate <command>
+  status   print the applied schema version and the pending migrations
+  up       apply the pending migrations`
+
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("missing migrate command\n%s", migrateUsage)
+	}
+
+	if backend := cfg.GetOr("STORAGE_BACKEND", "neo4j"); backend != "neo4j" {
+		return fmt.Errorf("migrations only apply to the neo4j backend, STORAGE_BACKEND is %s", backend)
+	}
+
+	// connected without MustSetupNeo4jClient, it would migrate or refuse an outdated schema
+	nClient, err := neo4j.NewNeo4jClient(ctx, neo4j.MustLoadNeo4jConfig())
+	if err != nil {
+		return err
+	}
+	defer nClient.Close(ctx)
+
+	switch args[0] {
+	case "status":
+		status, err := nClient.SchemaStatus(ctx)
+		if err != nil {
+			return err
+		}
+		updatedAt := "never"
+		if !status.UpdatedAt.IsZero() {
+			updatedAt = status.UpdatedAt.Format("2006-01-02T15:04:05Z07:00")
+		}
+		fmt.Printf("Schema version %d of %d, updated %s\n", status.Version, status.Latest(), updatedAt)
+		for _, m := range status.Migrations {
+			state := "applied"
+			if m.Version > status.Version {
+				state = "pending"
+			}
+			fmt.Printf("%d\t%s\t%s\n", m.Version, state, m.Description)
+		}
+		return nil
+	case "up":
+		applied, err := nClient.Migrate(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %d\t%s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
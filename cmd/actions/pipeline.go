@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/EduardoOliveira/ckc/handler"
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/internal/ptr"
+	"github.com/EduardoOliveira/ckc/storage"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// newHandler builds the parsers and stores of the server without its enrichers,
+// actions run them afterwards with EnrichAll instead of once per event
+func newHandler(ctx context.Context, backend storage.Backend) (*handler.Handler, error) {
+	escalationParser := ptr.To(handler.NewEscalationParser())
+	h := handler.New(ctx,
+		map[types.ServiceName][]handler.ContentParser{
+			types.SSHDService: {
+				ptr.To(handler.NewSSHDParser()),
+			},
+			types.HTTPService: {
+				ptr.To(handler.NewHTTPParser()),
+			},
+			types.PostfixService: {
+				ptr.To(handler.NewPostfixParser()),
+			},
+			types.DovecotService: {
+				ptr.To(handler.NewDovecotParser()),
+			},
+			types.KernelService: {
+				ptr.To(handler.NewFirewallParser()),
+			},
+			types.SudoService: {
+				escalationParser,
+			},
+			types.SuService: {
+				escalationParser,
+			},
+			types.CowrieService: {
+				ptr.To(handler.NewCowrieParser()),
+			},
+		},
+		backend.Stores,
+		nil,
+	)
+	if dir, ok := cfg.Get("DEFINITIONS_DIR"); ok {
+		defs, err := handler.LoadParserDefinitions(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parser definitions: %w", err)
+		}
+		err = h.RegisterDefinitions(defs, backend.DefinitionStores, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register parser definitions: %w", err)
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/EduardoOliveira/ckc/neo4j"
+	"github.com/EduardoOliveira/ckc/storage"
+)
+
+const pruneUsage = `usage: actions prune [flags]
+  Removes the nodes RETENTION_POLICIES consider stale, like "IPAddress:365d:compact,AIPDBData:90d:delete".
+  Policies are label:age:action, actions are delete, archive to RETENTION_ARCHIVE_DIR or
+  compact into a monthly PrunedSummary of the label.`
+
+func runPrune(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), pruneUsage)
+		flags.PrintDefaults()
+	}
+	policies := flags.String("policies", "", "policies to apply instead of RETENTION_POLICIES")
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without removing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	config := neo4j.MustLoadPruneConfig()
+	config.DryRun = *dryRun
+	if *policies != "" {
+		var err error
+		if config.Policies, err = neo4j.ParseRetentionPolicies(*policies); err != nil {
+			return err
+		}
+	}
+	if len(config.Policies) == 0 {
+		flags.Usage()
+		return errors.New("missing retention policies")
+	}
+
+	backend := storage.MustSetup(ctx, false)
+	defer backend.Close(ctx)
+	if backend.Pruner == nil {
+		return fmt.Errorf("the %s backend can't be pruned", backend.Name)
+	}
+
+	results, err := backend.Pruner.Prune(ctx, config)
+	verb := "removed"
+	if config.DryRun {
+		verb = "would remove"
+	}
+	for _, result := range results {
+		fmt.Printf("%s\t%s %d nodes last seen before %s\n",
+			result.Policy, verb, result.Nodes, result.Cutoff.Format("2006-01-02T15:04:05Z07:00"))
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/EduardoOliveira/ckc/storage"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+const timelineUsage = `usage: actions timeline [flags]
+  Prints the activity buckets of an ip address, username or service, one per line.
+  Buckets are only kept by servers running with ACTIVITY_BUCKETS.`
+
+func runTimeline(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), timelineUsage)
+		flags.PrintDefaults()
+	}
+	ip := flags.String("ip", "", "timeline of the ip address")
+	username := flags.String("username", "", "timeline of the username")
+	service := flags.String("service", "", "timeline of the service name, across ports and hosts")
+	resolution := flags.String("resolution", string(types.HourActivity), "resolution of the buckets, hour or day")
+	from := flags.String("from", "", "buckets starting at or after, a date like 2006-01-02 or an RFC 3339 time")
+	to := flags.String("to", "", "buckets starting before, a date like 2006-01-02 or an RFC 3339 time")
+	asJSON := flags.Bool("json", false, "print the buckets as json lines")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	q := types.TimelineQuery{Resolution: types.ActivityResolution(*resolution)}
+	for subject, value := range map[types.ActivitySubject]string{
+		types.IPAddressActivity: *ip,
+		types.UsernameActivity:  *username,
+		types.ServiceActivity:   *service,
+	} {
+		if value == "" {
+			continue
+		}
+		if q.Subject != "" {
+			return errors.New("only one of -ip, -username and -service can be given")
+		}
+		q.Subject, q.Value = subject, value
+	}
+	if q.Subject == "" {
+		flags.Usage()
+		return errors.New("missing -ip, -username or -service")
+	}
+	if _, err := types.ParseActivityResolutions(*resolution); err != nil {
+		return err
+	}
+	var err error
+	if *from != "" {
+		if q.From, err = parseQueryTime(*from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = parseQueryTime(*to); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+
+	backend := storage.MustSetup(ctx, false)
+	defer backend.Close(ctx)
+
+	buckets, err := backend.Timelines.Timeline(ctx, q)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, bucket := range buckets {
+		if *asJSON {
+			if err := encoder.Encode(bucket); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s\t%d events\t%d attempts\t%d successes\t%d failures\n",
+			bucket.Start.Format("2006-01-02T15:04:05Z07:00"), bucket.Events, bucket.Attempts, bucket.Successes, bucket.Failures)
+	}
+	fmt.Fprintf(os.Stderr, "%d buckets\n", len(buckets))
+	return nil
+}
@@ -3,14 +3,24 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // source timezones are resolved in images without a zoneinfo database
 
+	"github.com/EduardoOliveira/ckc/deadletter"
 	"github.com/EduardoOliveira/ckc/enrichment"
 	"github.com/EduardoOliveira/ckc/handler"
 	"github.com/EduardoOliveira/ckc/internal/cfg"
 	"github.com/EduardoOliveira/ckc/internal/ptr"
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
 	"github.com/EduardoOliveira/ckc/neo4j"
+	"github.com/EduardoOliveira/ckc/queue"
+	"github.com/EduardoOliveira/ckc/storage"
+	"github.com/EduardoOliveira/ckc/tail"
 	"github.com/EduardoOliveira/ckc/types"
 	syslog "gopkg.in/mcuadros/go-syslog.v2"
 )
@@ -19,30 +29,162 @@ func main() {
 	ctx, cancel := context.WithCancelCause(context.Background())
 	defer cancel(nil)
 
-	// Initialize Neo4j connection
-	nClient := neo4j.MustSetupNeo4jClient(ctx)
-	defer nClient.Close(ctx)
-	slog.Info("Connected to Neo4j", "uri", cfg.Must("NEO4J_URI"), "database", cfg.Must("NEO4J_DATABASE"))
+	// neo4j, or the embedded bolt database of small deployments. The queue hands over one
+	// event at a time, the neo4j writes are only batched without it.
+	_, queued := cfg.Get("QUEUE_DIR")
+	backend := storage.MustSetup(ctx, !queued)
+	defer backend.Close(ctx)
 
+	// parser definitions are loaded before the handler variable shadows the package
+	definitions := mustLoadDefinitions()
+	aipdbEnricher, err := enrichment.NewAIPDBEnricher(ctx, enrichment.MustLoadAIPDBConfig(), backend.Enrichments)
+	if err != nil {
+		panic("Failed to setup AIPDB enricher: " + err.Error())
+	}
+	escalationParser := ptr.To(handler.NewEscalationParser())
+	definitionStores := backend.DefinitionStores
+	definitionEnrichers := []handler.ContentEnricher{aipdbEnricher}
+	var geoIPEnricher *enrichment.GeoIPEnricher
+	if config, ok := enrichment.MustLoadGeoIPConfig(); ok {
+		geoIPEnricher, err = enrichment.NewGeoIPEnricher(ctx, config, backend.GeoIP)
+		if err != nil {
+			panic("Failed to setup GeoIP enricher: " + err.Error())
+		}
+		definitionEnrichers = append(definitionEnrichers, geoIPEnricher)
+	}
+	var reporter *enrichment.AIPDBReporter
+	if config, ok := enrichment.MustLoadAIPDBReporterConfig(); ok {
+		reporter = enrichment.NewAIPDBReporter(ctx, config, backend.Reports)
+		definitionEnrichers = append(definitionEnrichers, reporter)
+	}
 	handler := handler.New(ctx,
 		map[types.ServiceName][]handler.ContentParser{
 			types.SSHDService: {
 				ptr.To(handler.NewSSHDParser()),
 			},
-		},
-		map[types.ServiceName][]handler.ContentStore{
-			types.SSHDService: {
-				ptr.To(neo4j.NewNeo4jSSHD(nClient)),
+			types.HTTPService: {
+				ptr.To(handler.NewHTTPParser()),
+			},
+			types.PostfixService: {
+				ptr.To(handler.NewPostfixParser()),
+			},
+			types.DovecotService: {
+				ptr.To(handler.NewDovecotParser()),
+			},
+			types.KernelService: {
+				ptr.To(handler.NewFirewallParser()),
+			},
+			types.SudoService: {
+				escalationParser,
+			},
+			types.SuService: {
+				escalationParser,
+			},
+			types.CowrieService: {
+				ptr.To(handler.NewCowrieParser()),
 			},
 		},
+		backend.Stores,
 		map[types.ServiceName][]handler.ContentEnricher{
 			types.SSHDService: {
-				ptr.To(enrichment.NewAIPDBEnricher(ctx, cfg.Must("AIPDB_API_KEY"), nClient)),
+				aipdbEnricher,
+			},
+			types.HTTPService: {
+				probeEnricher{aipdbEnricher},
+			},
+			types.PostfixService: {
+				aipdbEnricher,
+			},
+			types.DovecotService: {
+				aipdbEnricher,
+			},
+			// firewall drops are many and mostly scans, they're left to the GeoIP enricher
+			// rather than spend the AbuseIPDB checks of the day
+			types.CowrieService: {
+				aipdbEnricher,
 			},
 		},
 	)
 
-	mustRunRsyslogServer(cancel, handler)
+	if geoIPEnricher != nil {
+		// the services whose events have an ip
+		for _, service := range []types.ServiceName{types.SSHDService, types.HTTPService, types.PostfixService, types.DovecotService, types.KernelService, types.CowrieService} {
+			handler.AddEnricher(service, geoIPEnricher)
+		}
+	}
+	if reporter != nil {
+		// the services whose events are authentication attempts
+		for _, service := range []types.ServiceName{types.SSHDService, types.PostfixService, types.DovecotService, types.CowrieService} {
+			handler.AddEnricher(service, reporter)
+		}
+		slog.Info("Reporting attacking IPs to AbuseIPDB")
+	}
+
+	if len(definitions) > 0 {
+		err := handler.RegisterDefinitions(definitions, definitionStores, definitionEnrichers)
+		if err != nil {
+			panic("Failed to register parser definitions: " + err.Error())
+		}
+		slog.Info("Loaded parser definitions", "count", len(definitions))
+	}
+
+	if dir, ok := cfg.Get("DEADLETTER_DIR"); ok {
+		deadLetters, err := deadletter.NewFileStore(dir,
+			int64(cfg.GetIntOr("DEADLETTER_MAX_BYTES", 0)),
+			cfg.GetIntOr("DEADLETTER_MAX_FILES", 0),
+		)
+		if err != nil {
+			panic("Failed to setup dead letter store: " + err.Error())
+		}
+		handler.SetDeadLetterSink(deadLetters)
+		slog.Info("Dead letters enabled", "dir", dir)
+	}
+
+	if threshold := cfg.GetDurationOr("CLOCK_SKEW_THRESHOLD", 0); threshold > 0 {
+		handler.SetClockSkewThreshold(threshold)
+	}
+
+	var syslogHandler syslog.Handler = handler
+	if dir, ok := cfg.Get("QUEUE_DIR"); ok {
+		q := mustSetupQueue(ctx, cancel, dir, handler)
+		defer q.Close()
+		syslogHandler = q
+	}
+
+	if config := neo4j.MustLoadPruneConfig(); len(config.Policies) > 0 {
+		mustRunRetention(ctx, backend, config, cfg.GetDurationOr("RETENTION_INTERVAL", 24*time.Hour))
+	}
+
+	format := syslogfmt.NewFormat(syslog.Automatic).WithTimezones(mustLoadTimezones())
+	mustRunRsyslogServer(cancel, format, syslogHandler)
+
+	// hosts that can't forward syslog have their files tailed or their journal piped in
+	var files []tail.Config
+	if paths, ok := cfg.Get("TAIL_FILES"); ok {
+		for _, path := range strings.Split(paths, ",") {
+			files = append(files, tail.Config{Path: path})
+		}
+	}
+	if entries, ok := cfg.Get("TAIL_JSON_FILES"); ok {
+		// tag=path pairs of the files services write json lines to, e.g. cowrie=/var/log/cowrie/cowrie.json
+		for _, entry := range strings.Split(entries, ",") {
+			tag, path, ok := strings.Cut(entry, "=")
+			if !ok {
+				panic("Failed to parse TAIL_JSON_FILES, expected tag=path: " + entry)
+			}
+			files = append(files, tail.Config{Path: path, Tag: strings.TrimSpace(tag)})
+		}
+	}
+	mustRunFileTailers(ctx, cancel, files, format, syslogHandler)
+	if cfg.GetOr("JOURNAL_STDIN", "") == "true" {
+		go func() {
+			if err := tail.ReadJournal(ctx, os.Stdin, syslogHandler); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Failed to read journal from stdin", "error", err)
+				return
+			}
+			slog.Info("Finished reading journal from stdin")
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
@@ -51,15 +193,59 @@ func main() {
 	cancel(nil)
 }
 
-func mustRunRsyslogServer(cancel context.CancelCauseFunc, handler *handler.Handler) syslog.LogPartsChannel {
+// mustLoadDefinitions reads the grok parser definitions from DEFINITIONS_DIR, if set
+func mustLoadDefinitions() []handler.ParserDefinition {
+	dir, ok := cfg.Get("DEFINITIONS_DIR")
+	if !ok {
+		return nil
+	}
+	defs, err := handler.LoadParserDefinitions(dir)
+	if err != nil {
+		panic("Failed to load parser definitions: " + err.Error())
+	}
+	return defs
+}
+
+// mustLoadTimezones reads the zone RFC 3164 timestamps are logged in, SYSLOG_TIMEZONE for
+// every source and SYSLOG_SOURCE_TIMEZONES, like "web-1=Europe/Lisbon", per hostname or relay
+func mustLoadTimezones() syslogfmt.Timezones {
+	zones, err := syslogfmt.ParseTimezones(cfg.GetOr("SYSLOG_TIMEZONE", "UTC"), cfg.GetOr("SYSLOG_SOURCE_TIMEZONES", ""))
+	if err != nil {
+		panic("Failed to load syslog timezones: " + err.Error())
+	}
+	return zones
+}
+
+func mustRunRsyslogServer(cancel context.CancelCauseFunc, format syslogfmt.Format, handler syslog.Handler) syslog.LogPartsChannel {
 	channel := make(syslog.LogPartsChannel)
 	server := syslog.NewServer()
-	server.SetFormat(syslog.Automatic)
+	server.SetFormat(format)
 	server.SetHandler(handler)
 
 	if err := server.ListenUDP(cfg.Must("RSYSLOG_SERVER")); err != nil {
 		panic("Failed to start syslog server: " + err.Error())
 	}
+	slog.Info("Listening for syslog over UDP", "address", cfg.Must("RSYSLOG_SERVER"))
+
+	// TCP accepts both octet-counted (RFC 6587) and newline framed messages
+	if addr, ok := cfg.Get("RSYSLOG_TCP_SERVER"); ok {
+		if err := server.ListenTCP(addr); err != nil {
+			panic("Failed to start syslog TCP listener: " + err.Error())
+		}
+		slog.Info("Listening for syslog over TCP", "address", addr)
+	}
+
+	if addr, ok := cfg.Get("RSYSLOG_TLS_SERVER"); ok {
+		tlsConfig := mustLoadTLSConfig(
+			cfg.Must("RSYSLOG_TLS_CERT"),
+			cfg.Must("RSYSLOG_TLS_KEY"),
+			cfg.GetOr("RSYSLOG_TLS_CLIENT_CA", ""),
+		)
+		if err := server.ListenTCPTLS(addr, tlsConfig); err != nil {
+			panic("Failed to start syslog TLS listener: " + err.Error())
+		}
+		slog.Info("Listening for syslog over TLS", "address", addr, "mutual_tls", tlsConfig.ClientCAs != nil)
+	}
 	if err := server.Boot(); err != nil {
 		panic("Failed to start syslog server: " + err.Error())
 	}
@@ -69,3 +255,88 @@ func mustRunRsyslogServer(cancel context.CancelCauseFunc, handler *handler.Handl
 	}()
 	return channel
 }
+
+// mustRunFileTailers follows each file, keeping their offsets in TAIL_STATE_DIR
+func mustRunFileTailers(ctx context.Context, cancel context.CancelCauseFunc, files []tail.Config, format syslogfmt.Format, handler syslog.Handler) {
+	for _, file := range files {
+		path := strings.TrimSpace(file.Path)
+		if path == "" {
+			continue
+		}
+		tailer, err := tail.NewFileTailer(tail.Config{
+			Path:         path,
+			StateDir:     cfg.GetOr("TAIL_STATE_DIR", ""),
+			Format:       format,
+			Priority:     cfg.GetIntOr("TAIL_PRIORITY", 0),
+			PollInterval: cfg.GetDurationOr("TAIL_POLL_INTERVAL", 0),
+			Tag:          file.Tag,
+		})
+		if err != nil {
+			panic("Failed to setup file tailer: " + err.Error())
+		}
+		go func() {
+			if err := tailer.Run(ctx, handler); err != nil && !errors.Is(err, context.Canceled) {
+				cancel(fmt.Errorf("tailing %s stopped: %w", path, err))
+			}
+		}()
+		slog.Info("Tailing log file", "path", path, "tag", file.Tag)
+	}
+}
+
+// mustSetupQueue puts the disk queue between the syslog server and the handler,
+// so events are kept while the stores are unavailable
+func mustSetupQueue(ctx context.Context, cancel context.CancelCauseFunc, dir string, handler *handler.Handler) *queue.DiskQueue {
+	q, err := queue.NewDiskQueue(queue.Config{
+		Dir:          dir,
+		SegmentBytes: int64(cfg.GetIntOr("QUEUE_SEGMENT_BYTES", 0)),
+		MaxSegments:  cfg.GetIntOr("QUEUE_MAX_SEGMENTS", 0),
+		MaxBackoff:   cfg.GetDurationOr("QUEUE_MAX_BACKOFF", 0),
+		MaxAttempts:  cfg.GetIntOr("QUEUE_MAX_ATTEMPTS", 0),
+		IsRetryable:  neo4j.IsTransient,
+		OnGiveUp:     handler.DeadLetter,
+	})
+	if err != nil {
+		panic("Failed to setup ingestion queue: " + err.Error())
+	}
+	go func() {
+		if err := q.Run(ctx, handler.Process); err != nil && !errors.Is(err, context.Canceled) {
+			cancel(fmt.Errorf("ingestion queue stopped: %w", err))
+		}
+	}()
+	slog.Info("Ingestion queue enabled", "dir", dir, "segments", q.Len())
+	return q
+}
+
+// mustRunRetention applies the retention policies every interval, the first time right away
+func mustRunRetention(ctx context.Context, backend storage.Backend, config neo4j.PruneConfig, interval time.Duration) {
+	if backend.Pruner == nil {
+		panic("Failed to setup retention: the " + backend.Name + " backend can't be pruned")
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := backend.Pruner.Prune(ctx, config); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Failed to apply retention policies", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	slog.Info("Retention enabled", "policies", len(config.Policies), "interval", interval)
+}
+
+// probeEnricher only hands the http requests for probe paths to its enricher, ordinary
+// visitors would use up the AbuseIPDB checks of the day
+type probeEnricher struct {
+	handler.ContentEnricher
+}
+
+func (e probeEnricher) Enrich(parsed types.ParsedEvent) {
+	if parsed.HTTPEvent.OrElse(types.HTTPParsedEvent{}).Probe {
+		e.ContentEnricher.Enrich(parsed)
+	}
+}
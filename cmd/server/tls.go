@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+)
+
+// mustLoadTLSConfig builds the RFC 5425 listener config, when caFile is set
+// clients must present a certificate signed by it
+func mustLoadTLSConfig(certFile, keyFile, caFile string) *tls.Config {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		panic("Failed to load syslog TLS certificate: " + err.Error())
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile == "" {
+		return config
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		panic("Failed to read syslog TLS client CA: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic("No certificates found in syslog TLS client CA: " + caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return config
+}
@@ -0,0 +1,262 @@
+package deadletter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+const (
+	currentFile   = "deadletter.jsonl"
+	rotatedPrefix = "deadletter-"
+	rotatedSuffix = ".jsonl"
+)
+
+var ErrNotFound = errors.New("dead letter not found")
+
+// FileStore keeps dead letters as json lines, the current file is rotated once it
+// grows past maxBytes and only the newest maxFiles rotated files are kept
+type FileStore struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	mu  sync.Mutex
+	seq atomic.Uint64
+	now func() time.Time
+}
+
+func NewFileStore(dir string, maxBytes int64, maxFiles int) (*FileStore, error) {
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	if maxFiles <= 0 {
+		maxFiles = 10
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter dir: %w", err)
+	}
+	return &FileStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *FileStore) Write(_ context.Context, letter types.DeadLetter) error {
+	if letter.ID == "" {
+		letter.ID = s.newID()
+	}
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.dir, currentFile)
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// List iterates over every dead letter, oldest first
+func (s *FileStore) List() iter.Seq2[types.DeadLetter, error] {
+	return func(yield func(types.DeadLetter, error) bool) {
+		s.mu.Lock()
+		files, err := s.files()
+		s.mu.Unlock()
+		if err != nil {
+			yield(types.DeadLetter{}, err)
+			return
+		}
+		for _, file := range files {
+			letters, err := readFile(file)
+			if err != nil {
+				yield(types.DeadLetter{}, err)
+				return
+			}
+			for _, letter := range letters {
+				if !yield(letter, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *FileStore) Get(id string) (types.DeadLetter, error) {
+	for letter, err := range s.List() {
+		if err != nil {
+			return types.DeadLetter{}, err
+		}
+		if letter.ID == id {
+			return letter, nil
+		}
+	}
+	return types.DeadLetter{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Remove rewrites the files without the given dead letters
+func (s *FileStore) Remove(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		letters, err := readFile(file)
+		if err != nil {
+			return err
+		}
+		kept := slices.DeleteFunc(slices.Clone(letters), func(l types.DeadLetter) bool {
+			return slices.Contains(ids, l.ID)
+		})
+		if len(kept) == len(letters) {
+			continue
+		}
+		if err := writeFile(file, kept); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) newID() string {
+	return strconv.FormatInt(s.now().UnixNano(), 36) + "-" + strconv.FormatUint(s.seq.Add(1), 36)
+}
+
+func (s *FileStore) rotate() error {
+	rotated := filepath.Join(s.dir, rotatedPrefix+s.now().UTC().Format("20060102T150405.000000000")+rotatedSuffix)
+	if err := os.Rename(filepath.Join(s.dir, currentFile), rotated); err != nil {
+		return fmt.Errorf("failed to rotate dead letter file: %w", err)
+	}
+
+	// the current file was just renamed, so only rotated files are listed
+	rotatedFiles, err := s.files()
+	if err != nil {
+		return err
+	}
+	for len(rotatedFiles) > s.maxFiles {
+		slog.Warn("Removing old dead letter file", "file", rotatedFiles[0])
+		if err := os.Remove(rotatedFiles[0]); err != nil {
+			return fmt.Errorf("failed to remove old dead letter file: %w", err)
+		}
+		rotatedFiles = rotatedFiles[1:]
+	}
+	return nil
+}
+
+// files returns the rotated files oldest first, followed by the current file when it exists
+func (s *FileStore) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letter dir: %w", err)
+	}
+	var rtn []string
+	var hasCurrent bool
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == currentFile {
+			hasCurrent = true
+			continue
+		}
+		if strings.HasPrefix(name, rotatedPrefix) && strings.HasSuffix(name, rotatedSuffix) {
+			rtn = append(rtn, filepath.Join(s.dir, name))
+		}
+	}
+	slices.Sort(rtn)
+	if hasCurrent {
+		rtn = append(rtn, filepath.Join(s.dir, currentFile))
+	}
+	return rtn, nil
+}
+
+func readFile(path string) ([]types.DeadLetter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+	defer f.Close()
+
+	var letters []types.DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var letter types.DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			slog.Warn("Skipping undecodable dead letter", "file", path, "error", err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead letter file: %w", err)
+	}
+	return letters, nil
+}
+
+// writeFile replaces the file atomically, empty rotated files are removed
+func writeFile(path string, letters []types.DeadLetter) error {
+	if len(letters) == 0 && filepath.Base(path) != currentFile {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove dead letter file: %w", err)
+		}
+		return nil
+	}
+
+	var buf []byte
+	for _, letter := range letters {
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return fmt.Errorf("failed to encode dead letter: %w", err)
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o640); err != nil {
+		return fmt.Errorf("failed to write dead letter file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace dead letter file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package deadletter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func collect(t *testing.T, s *FileStore) []types.DeadLetter {
+	t.Helper()
+	var letters []types.DeadLetter
+	for letter, err := range s.List() {
+		assert.NoError(t, err)
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestFileStore(t *testing.T) {
+	t.Run("write, get and remove", func(t *testing.T) {
+		s, err := NewFileStore(t.TempDir(), 0, 0)
+		assert.NoError(t, err)
+
+		for _, content := range []string{"one", "two", "three"} {
+			assert.NoError(t, s.Write(context.Background(), types.DeadLetter{
+				FailedAt:    time_help.Now(),
+				Stage:       types.ParseStage,
+				ServiceName: types.SSHDService,
+				Component:   "sshd_grok_parser",
+				Error:       "no grok pattern matched",
+				Content:     content,
+				LogParts:    map[string]any{"content": content, "timestamp": time_help.Now()},
+			}))
+		}
+
+		letters := collect(t, s)
+		assert.Len(t, letters, 3)
+
+		letter, err := s.Get(letters[1].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "two", letter.Content)
+		assert.Equal(t, time_help.Now(), letter.LogParts["timestamp"])
+
+		assert.NoError(t, s.Remove(letters[0].ID, letters[2].ID))
+		letters = collect(t, s)
+		assert.Len(t, letters, 1)
+		assert.Equal(t, "two", letters[0].Content)
+
+		_, err = s.Get("missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("rotates and keeps max files", func(t *testing.T) {
+		s, err := NewFileStore(t.TempDir(), 1, 2)
+		assert.NoError(t, err)
+		tick := time_help.Now()
+		s.now = func() time.Time {
+			tick = tick.Add(time.Second)
+			return tick
+		}
+
+		for _, content := range []string{"one", "two", "three", "four", "five"} {
+			assert.NoError(t, s.Write(context.Background(), types.DeadLetter{Content: content}))
+		}
+
+		files, err := s.files()
+		assert.NoError(t, err)
+		assert.Len(t, files, 3)
+
+		var contents []string
+		for _, letter := range collect(t, s) {
+			contents = append(contents, letter.Content)
+		}
+		assert.Equal(t, []string{"three", "four", "five"}, contents)
+	})
+}
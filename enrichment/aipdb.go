@@ -1,78 +1,199 @@
 package enrichment
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/EduardoOliveira/ckc/internal/cfg"
 	"github.com/EduardoOliveira/ckc/internal/opt"
-	"github.com/EduardoOliveira/ckc/neo4j"
 	"github.com/EduardoOliveira/ckc/types"
 )
 
+// Store keeps what the enrichers learn about the ip addresses of the events
+type Store interface {
+	SaveAIPDBData(ctx context.Context, target types.IPAddress, enrichment types.AIPDBData) error
+	// GetLastEnrichedAt returns the zero time for ips that were never enriched
+	GetLastEnrichedAt(ctx context.Context, target types.IPAddress, enrichmentType string) (time.Time, error)
+	IterOverIPAddresses(ctx context.Context) (iter.Seq2[types.IPAddress, error], error)
+}
+
+const defaultAIPDBURL = "https://api.abuseipdb.com/api/v2"
+
+type AIPDBConfig struct {
+	APIKey     string
+	BaseURL    string // defaults to the AbuseIPDB v2 api
+	DailyLimit int    // checks a day of the plan, defaults to the 1000 of the free plan
+	QuotaFile  string // keeps the quota across restarts, not kept when empty
+	MaxPending int    // ips waiting for quota, those with the fewest attempts are dropped beyond it. Defaults to 10000
+}
+
+// MustLoadAIPDBConfig reads the AbuseIPDB settings from the AIPDB_* variables
+func MustLoadAIPDBConfig() AIPDBConfig {
+	return AIPDBConfig{
+		APIKey:     cfg.Must("AIPDB_API_KEY"),
+		BaseURL:    cfg.GetOr("AIPDB_URL", ""),
+		DailyLimit: cfg.GetIntOr("AIPDB_DAILY_LIMIT", 0),
+		QuotaFile:  cfg.GetOr("AIPDB_QUOTA_FILE", ""),
+		MaxPending: cfg.GetIntOr("AIPDB_MAX_PENDING", 0),
+	}
+}
+
 type AIPDBEnricher struct {
-	ctx       context.Context
-	apiKey    string
-	neoClient *neo4j.Neo4jClient
+	ctx     context.Context
+	apiKey  string
+	baseURL string
+	client  *http.Client
+	store   Store
+
+	// checks wait in pending for the quota, nil quota doesn't limit them
+	quota   *quota
+	pending *pendingIPs
+	sleep   func(ctx context.Context, d time.Duration) error
 }
 
 func (_ *AIPDBEnricher) Name() string {
 	return "AIPDB"
 }
 
-func NewAIPDBEnricher(ctx context.Context, apiKey string, n *neo4j.Neo4jClient) AIPDBEnricher {
-	ensurePool(ctx)
-	return AIPDBEnricher{
-		ctx:       ctx,
-		apiKey:    apiKey,
-		neoClient: n,
+// NewAIPDBEnricher checks the ips of the events as the quota of the plan allows, until ctx is done
+func NewAIPDBEnricher(ctx context.Context, config AIPDBConfig, store Store) (*AIPDBEnricher, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultAIPDBURL
 	}
+	if config.DailyLimit <= 0 {
+		config.DailyLimit = 1000
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = 10000
+	}
+	q, err := newQuota(config.QuotaFile, config.DailyLimit, time.Now)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &AIPDBEnricher{
+		ctx:     ctx,
+		apiKey:  config.APIKey,
+		baseURL: config.BaseURL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		store:   store,
+		quota:   q,
+		pending: newPendingIPs(config.MaxPending),
+		sleep:   sleepContext,
+	}
+	go e.run(ctx)
+	return e, nil
 }
 
+// Enrich queues the ip of the event, every event counts as an attempt towards its priority
 func (e *AIPDBEnricher) Enrich(parsed types.ParsedEvent) {
-	if err := e.enrich(parsed.IPAddress); err != nil {
+	if parsed.IPAddress.Address == "" {
+		return
+	}
+	enrich, err := e.needsEnrichment(e.ctx, parsed.IPAddress)
+	if err != nil {
 		slog.ErrorContext(e.ctx, "Failed to enrich IP with AIPDB", "ip", parsed.IPAddress, "error", err)
+		return
+	}
+	if enrich {
+		e.pending.push(parsed.IPAddress, 1)
 	}
 }
 
-func (e *AIPDBEnricher) enrich(ip types.IPAddress) error {
-	// Check if the IP was enriched in the past 24 hours
-	lastEnriched, err := e.neoClient.GetLastEnrichedAt(e.ctx, ip, "AIPDBData")
+// needsEnrichment reports if the ip wasn't enriched in the past 24 hours
+func (e *AIPDBEnricher) needsEnrichment(ctx context.Context, ip types.IPAddress) (bool, error) {
+	lastEnriched, err := e.store.GetLastEnrichedAt(ctx, ip, "AIPDBData")
 	if err != nil {
-		slog.WarnContext(e.ctx, "Failed to get last enrichment time, proceeding with enrichment", "ip", ip, "error", err)
-		return nil
-	} else {
-		if !lastEnriched.IsZero() && time.Since(lastEnriched) < 24*time.Hour {
-			slog.InfoContext(e.ctx, "IP was enriched less than 24 hours ago, skipping",
-				"ip", ip,
-				"last_enriched", lastEnriched,
-				"hours_ago", time.Since(lastEnriched).Hours())
-			return nil
+		return false, fmt.Errorf("failed to get last enrichment time: %w", err)
+	}
+	if !lastEnriched.IsZero() && time.Since(lastEnriched) < 24*time.Hour {
+		slog.DebugContext(ctx, "IP was enriched less than 24 hours ago, skipping",
+			"ip", ip,
+			"last_enriched", lastEnriched,
+			"hours_ago", time.Since(lastEnriched).Hours())
+		return false, nil
+	}
+	return true, nil
+}
+
+// run checks the pending ips, the most attempted first, as the quota allows
+func (e *AIPDBEnricher) run(ctx context.Context) {
+	for {
+		if e.pending.len() == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.pending.signal:
+				continue
+			}
+		}
+		next, ok := e.pending.pop()
+		if !ok {
+			continue
+		}
+		// the events that arrive while an ip is checked queue it again, it's only
+		// checked again when it's still due
+		enrich, err := e.needsEnrichment(ctx, next.ip)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to enrich IP with AIPDB", "ip", next.ip, "error", err)
+			continue
+		}
+		if !enrich {
+			continue
+		}
+
+		wait, err := e.quota.take()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to save AIPDB quota", "error", err)
+		}
+		if wait > 0 {
+			e.pending.push(next.ip, next.attempts)
+			slog.InfoContext(ctx, "AIPDB quota exhausted, waiting", "wait", wait, "pending", e.pending.len())
+			if err := e.sleep(ctx, wait); err != nil {
+				return
+			}
+			continue
+		}
+
+		err = e.check(ctx, next.ip)
+		if errors.Is(err, ErrRateLimited) {
+			// checked again once the backoff is over
+			e.pending.push(next.ip, next.attempts)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to enrich IP with AIPDB", "ip", next.ip, "attempts", next.attempts, "error", err)
 		}
 	}
-	slog.InfoContext(e.ctx, "Enriching IP with AIPDB", "ip", ip)
-	timeout, cancel := context.WithTimeout(e.ctx, 60*time.Second)
+}
+
+// check fetches and saves what AbuseIPDB knows of the ip
+func (e *AIPDBEnricher) check(ctx context.Context, ip types.IPAddress) error {
+	slog.InfoContext(ctx, "Enriching IP with AIPDB", "ip", ip)
+	timeout, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
+	// checks are already made one at a time by run, or EnrichAll, as the quota allows
+	job, out := e.getData(timeout, ip)
+	go job()
+	var result opt.Result[types.AIPDBData]
 	select {
 	case <-timeout.Done():
-		return fmt.Errorf("timeout while waiting for enrichment of IP %s", ip.Address)
-	case <-e.ctx.Done():
-		return fmt.Errorf("context cancelled while waiting for enrichment of IP %s: %w", ip.Address, e.ctx.Err())
-	default:
-		job, out := e.getData(timeout, ip)
-		publishJob(job)
-		result := <-out
-		if result.Error != nil {
-			return fmt.Errorf("failed to enrich IP %s: %w", ip.Address, result.Error)
-		}
-		slog.InfoContext(timeout, "Enrichment result", "ip", ip)
-		if err := e.neoClient.SaveAIPDBData(timeout, ip, result.Value); err != nil {
-			return fmt.Errorf("failed to save AIPDB data for IP %s: %w", ip.Address, err)
-		}
+		return fmt.Errorf("timeout while waiting for enrichment of IP %s: %w", ip.Address, timeout.Err())
+	case result = <-out:
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := e.store.SaveAIPDBData(timeout, ip, result.Value); err != nil {
+		return fmt.Errorf("failed to save AIPDB data for IP %s: %w", ip.Address, err)
 	}
 	return nil
 }
@@ -80,22 +201,39 @@ func (e *AIPDBEnricher) enrich(ip types.IPAddress) error {
 func (e *AIPDBEnricher) getData(ctx context.Context, ip types.IPAddress) (job, <-chan opt.Result[types.AIPDBData]) {
 	done := make(chan opt.Result[types.AIPDBData], 1)
 	return func() {
-		req, err := http.NewRequestWithContext(ctx, "GET", "https://api.abuseipdb.com/api/v2/check?verbose=false&ipAddress="+ip.Address, nil)
+		baseURL := cmp.Or(e.baseURL, defaultAIPDBURL)
+		req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/check?verbose=false&ipAddress="+ip.Address, nil)
 		if err != nil {
 			done <- opt.Err[types.AIPDBData](fmt.Errorf("failed to create request: %w", err))
 			return
 		}
 		req.Header.Set("Key", e.apiKey)
+		req.Header.Set("Accept", "application/json")
 
-		resp, err := http.DefaultClient.Do(req)
+		client := e.client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
 		if err != nil {
 			done <- opt.Err[types.AIPDBData](fmt.Errorf("failed to enrich IP %s: %w", ip.Address, err))
 			return
 		}
 		defer resp.Body.Close()
 
+		if e.quota != nil {
+			err := e.quota.observe(resp)
+			if errors.Is(err, ErrRateLimited) {
+				done <- opt.Err[types.AIPDBData](fmt.Errorf("failed to enrich IP %s: %w", ip.Address, err))
+				return
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to save AIPDB quota", "error", err)
+			}
+		}
 		if resp.StatusCode != http.StatusOK {
 			done <- opt.Err[types.AIPDBData](fmt.Errorf("failed to enrich IP %s: %s", ip.Address, resp.Status))
+			return
 		}
 
 		var response types.AbuseIPDBResponse
@@ -107,21 +245,62 @@ func (e *AIPDBEnricher) getData(ctx context.Context, ip types.IPAddress) (job, <
 	}, done
 }
 
+// EnrichAll checks the ips not enriched in the past 24 hours, the most seen first, until
+// they're all checked or the quota runs out
 func (e *AIPDBEnricher) EnrichAll(ctx context.Context) error {
 	slog.InfoContext(ctx, "Starting AIPDB enrichment for all IPs")
-	ips, err := e.neoClient.IterOverIPAddresses(ctx)
+	ips, err := e.store.IterOverIPAddresses(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to iterate over IP addresses: %w", err)
 	}
 
+	var due []types.IPAddress
 	for ip, err := range ips {
 		if err != nil {
 			slog.WarnContext(ctx, "Failed to get IP address", "error", err)
 			continue
 		}
-		e.enrich(ip)
+		enrich, err := e.needsEnrichment(ctx, ip)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to check IP address", "ip", ip, "error", err)
+			continue
+		}
+		if enrich {
+			due = append(due, ip)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b types.IPAddress) int {
+		return cmp.Compare(b.Seen, a.Seen)
+	})
+
+	for i, ip := range due {
+		wait, err := e.quota.take()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to save AIPDB quota", "error", err)
+		}
+		if wait > 0 {
+			slog.WarnContext(ctx, "AIPDB quota exhausted, leaving the least seen IPs for later", "left", len(due)-i, "available_in", wait)
+			break
+		}
+		err = e.check(ctx, ip)
+		if errors.Is(err, ErrRateLimited) {
+			slog.WarnContext(ctx, "AIPDB rate limited, leaving the least seen IPs for later", "left", len(due)-i, "error", err)
+			break
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to enrich IP with AIPDB", "ip", ip, "error", err)
+		}
 	}
 
-	slog.InfoContext(ctx, "Completed AIPDB enrichment for all IPs")
+	slog.InfoContext(ctx, "Completed AIPDB enrichment for all IPs", "remaining_quota", e.quota.remaining())
 	return nil
 }
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
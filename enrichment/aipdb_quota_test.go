@@ -0,0 +1,176 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	mu       sync.Mutex
+	ips      []types.IPAddress
+	enriched map[string]time.Time
+	saved    []string
+}
+
+func (s *memoryStore) SaveAIPDBData(_ context.Context, target types.IPAddress, _ types.AIPDBData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, target.Address)
+	if s.enriched == nil {
+		s.enriched = map[string]time.Time{}
+	}
+	s.enriched[target.Address] = time.Now()
+	return nil
+}
+
+func (s *memoryStore) GetLastEnrichedAt(_ context.Context, target types.IPAddress, _ string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enriched[target.Address], nil
+}
+
+func (s *memoryStore) IterOverIPAddresses(context.Context) (iter.Seq2[types.IPAddress, error], error) {
+	return func(yield func(types.IPAddress, error) bool) {
+		for _, ip := range s.ips {
+			if !yield(ip, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// abuseIPDB answers checks with status, the ips checked are sent to checked
+func abuseIPDB(t *testing.T, status int, header http.Header) (*httptest.Server, chan string) {
+	checked := make(chan string, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/check", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("Key"))
+		checked <- r.URL.Query().Get("ipAddress")
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(types.AbuseIPDBResponse{Data: types.AIPDBData{IPAddress: r.URL.Query().Get("ipAddress")}})
+	}))
+	t.Cleanup(server.Close)
+	return server, checked
+}
+
+func newTestEnricher(t *testing.T, url string, dailyLimit int, store Store) *AIPDBEnricher {
+	t.Helper()
+	e, err := NewAIPDBEnricher(t.Context(), AIPDBConfig{APIKey: "key", BaseURL: url, DailyLimit: dailyLimit}, store)
+	assert.NoError(t, err)
+	return e
+}
+
+func TestAIPDBGetData(t *testing.T) {
+	t.Run("a failed check has a single result", func(t *testing.T) {
+		server, _ := abuseIPDB(t, http.StatusInternalServerError, nil)
+		e := AIPDBEnricher{apiKey: "key", baseURL: server.URL}
+		job, out := e.getData(t.Context(), types.IPAddress{Address: "203.0.113.7"})
+		job()
+		assert.Len(t, out, 1)
+		assert.ErrorContains(t, (<-out).Error, "500 Internal Server Error")
+	})
+
+	t.Run("429 is rate limited", func(t *testing.T) {
+		server, _ := abuseIPDB(t, http.StatusTooManyRequests, http.Header{"Retry-After": {"30"}})
+		e := newTestEnricher(t, server.URL, 1000, &memoryStore{})
+		assert.ErrorIs(t, e.check(t.Context(), types.IPAddress{Address: "203.0.113.7"}), ErrRateLimited)
+		wait, err := e.quota.take()
+		assert.NoError(t, err)
+		assert.InDelta(t, 30*time.Second, wait, float64(time.Second))
+	})
+}
+
+func TestAIPDBPriority(t *testing.T) {
+	t.Run("the most seen ips are checked when the quota runs out", func(t *testing.T) {
+		server, checked := abuseIPDB(t, http.StatusOK, nil)
+		store := &memoryStore{
+			ips: []types.IPAddress{
+				{Address: "203.0.113.1", Seen: 2},
+				{Address: "203.0.113.2", Seen: 50},
+				{Address: "203.0.113.3", Seen: 7},
+				{Address: "203.0.113.4", Seen: 90},
+			},
+			enriched: map[string]time.Time{"203.0.113.4": time.Now()},
+		}
+		e := newTestEnricher(t, server.URL, 2, store)
+		assert.NoError(t, e.EnrichAll(t.Context()))
+		close(checked)
+
+		var order []string
+		for ip := range checked {
+			order = append(order, ip)
+		}
+		assert.Equal(t, []string{"203.0.113.2", "203.0.113.3"}, order)
+		assert.Equal(t, []string{"203.0.113.2", "203.0.113.3"}, store.saved)
+	})
+
+	t.Run("ips queued while they're checked aren't checked again", func(t *testing.T) {
+		server, checked := abuseIPDB(t, http.StatusOK, nil)
+		inFlight, release := make(chan struct{}), make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(inFlight)
+			<-release
+			server.Config.Handler.ServeHTTP(w, r)
+		}))
+		t.Cleanup(slow.Close)
+		store := &memoryStore{}
+		e := newTestEnricher(t, slow.URL, 1000, store)
+
+		event := types.ParsedEvent{IPAddress: types.IPAddress{Address: "203.0.113.7"}}
+		e.Enrich(event)
+		<-inFlight
+		e.Enrich(event)
+		close(release)
+
+		assert.Eventually(t, func() bool {
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			return len(store.saved) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool { return e.pending.len() == 0 }, 5*time.Second, 10*time.Millisecond)
+		assert.Never(t, func() bool { return len(checked) > 1 }, 100*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("pending ips are checked by attempts", func(t *testing.T) {
+		p := newPendingIPs(2)
+		p.push(types.IPAddress{Address: "203.0.113.1"}, 1)
+		p.push(types.IPAddress{Address: "203.0.113.2"}, 1)
+		p.push(types.IPAddress{Address: "203.0.113.2"}, 1)
+		// full, it takes the place of the least attempted ip only when it has more attempts
+		p.push(types.IPAddress{Address: "203.0.113.3"}, 1)
+		p.push(types.IPAddress{Address: "203.0.113.4"}, 3)
+
+		next, ok := p.pop()
+		assert.True(t, ok)
+		assert.Equal(t, "203.0.113.4", next.ip.Address)
+		next, _ = p.pop()
+		assert.Equal(t, "203.0.113.2", next.ip.Address)
+		assert.Equal(t, int64(2), next.attempts)
+		_, ok = p.pop()
+		assert.False(t, ok)
+	})
+
+	t.Run("events are checked as the quota allows", func(t *testing.T) {
+		server, checked := abuseIPDB(t, http.StatusOK, nil)
+		e := newTestEnricher(t, server.URL, 1000, &memoryStore{})
+		e.Enrich(types.ParsedEvent{IPAddress: types.IPAddress{Address: "203.0.113.7"}})
+		select {
+		case ip := <-checked:
+			assert.Equal(t, "203.0.113.7", ip)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the ip wasn't checked")
+		}
+	})
+}
@@ -0,0 +1,310 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	// geoIPTTL is how long an ip isn't looked up again, unless a database is reloaded
+	geoIPTTL = 24 * time.Hour
+	// maxGeoIPCached bounds the ips remembered as looked up, they're all forgotten beyond it
+	maxGeoIPCached = 100000
+)
+
+// GeoStore keeps the location and network of the ip addresses
+type GeoStore interface {
+	SaveGeoIPData(ctx context.Context, target types.IPAddress, data types.GeoIPData) error
+	IterOverIPAddresses(ctx context.Context) (iter.Seq2[types.IPAddress, error], error)
+}
+
+type GeoIPConfig struct {
+	CityDB         string        // GeoLite2/DB-IP City or Country .mmdb, not looked up when empty
+	ASNDB          string        // GeoLite2/DB-IP ASN .mmdb, not looked up when empty
+	Language       string        // of the city and country names, defaults to en
+	ReloadInterval time.Duration // between checks for changed databases, defaults to a minute
+}
+
+// MustLoadGeoIPConfig reads the GEOIP_* variables, the enricher is only enabled when
+// GEOIP_CITY_DB or GEOIP_ASN_DB is set
+func MustLoadGeoIPConfig() (GeoIPConfig, bool) {
+	config := GeoIPConfig{
+		CityDB:         cfg.GetOr("GEOIP_CITY_DB", ""),
+		ASNDB:          cfg.GetOr("GEOIP_ASN_DB", ""),
+		Language:       cfg.GetOr("GEOIP_LANGUAGE", ""),
+		ReloadInterval: cfg.GetDurationOr("GEOIP_RELOAD_INTERVAL", 0),
+	}
+	return config, config.CityDB != "" || config.ASNDB != ""
+}
+
+// GeoIPEnricher locates the ips of the events with local MaxMind-format databases, the
+// databases are reloaded when their files change
+type GeoIPEnricher struct {
+	ctx      context.Context
+	store    GeoStore
+	city     *geoDatabase
+	asn      *geoDatabase
+	language string
+
+	mu       sync.Mutex
+	enriched map[string]time.Time
+	now      func() time.Time
+}
+
+func (_ *GeoIPEnricher) Name() string {
+	return "GeoIP"
+}
+
+// NewGeoIPEnricher opens the databases of the config and checks them for changes until ctx is done
+func NewGeoIPEnricher(ctx context.Context, config GeoIPConfig, store GeoStore) (*GeoIPEnricher, error) {
+	if config.Language == "" {
+		config.Language = "en"
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = time.Minute
+	}
+	e := &GeoIPEnricher{
+		ctx:      ctx,
+		store:    store,
+		language: config.Language,
+		enriched: map[string]time.Time{},
+		now:      time.Now,
+	}
+	var err error
+	if config.CityDB != "" {
+		if e.city, err = openGeoDatabase(config.CityDB); err != nil {
+			return nil, err
+		}
+	}
+	if config.ASNDB != "" {
+		if e.asn, err = openGeoDatabase(config.ASNDB); err != nil {
+			return nil, err
+		}
+	}
+	go e.watch(ctx, config.ReloadInterval)
+	return e, nil
+}
+
+// Enrich saves the location and network of the ip of the event, unless it was saved recently
+func (e *GeoIPEnricher) Enrich(parsed types.ParsedEvent) {
+	addr, err := netip.ParseAddr(parsed.IPAddress.Address)
+	addr = addr.Unmap()
+	if err != nil || isInternal(addr) || !e.claim(addr.String()) {
+		return
+	}
+	if err := e.enrich(e.ctx, parsed.IPAddress, addr); err != nil {
+		e.release(addr.String())
+		slog.ErrorContext(e.ctx, "Failed to enrich IP with GeoIP", "ip", parsed.IPAddress.Address, "error", err)
+	}
+}
+
+// EnrichAll saves the location and network of every ip of the store
+func (e *GeoIPEnricher) EnrichAll(ctx context.Context) error {
+	slog.InfoContext(ctx, "Starting GeoIP enrichment for all IPs")
+	ips, err := e.store.IterOverIPAddresses(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to iterate over IP addresses: %w", err)
+	}
+	var enriched int
+	for ip, err := range ips {
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to get IP address", "error", err)
+			continue
+		}
+		addr, err := netip.ParseAddr(ip.Address)
+		if err != nil || isInternal(addr) {
+			continue
+		}
+		if err := e.enrich(ctx, ip, addr); err != nil {
+			slog.ErrorContext(ctx, "Failed to enrich IP with GeoIP", "ip", ip.Address, "error", err)
+			continue
+		}
+		enriched++
+	}
+	slog.InfoContext(ctx, "Completed GeoIP enrichment for all IPs", "enriched", enriched)
+	return nil
+}
+
+func (e *GeoIPEnricher) enrich(ctx context.Context, ip types.IPAddress, addr netip.Addr) error {
+	data, err := e.Lookup(addr)
+	if err != nil {
+		return err
+	}
+	if data.IsZero() {
+		return nil
+	}
+	if err := e.store.SaveGeoIPData(ctx, ip, data); err != nil {
+		return fmt.Errorf("failed to save GeoIP data for IP %s: %w", ip.Address, err)
+	}
+	return nil
+}
+
+// cityRecord are the fields read from City and Country databases
+type cityRecord struct {
+	City struct {
+		GeoNameID uint              `maxminddb:"geoname_id"`
+		Names     map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Lookup reads what the databases know of the ip, the zero data when none has it
+func (e *GeoIPEnricher) Lookup(addr netip.Addr) (types.GeoIPData, error) {
+	var data types.GeoIPData
+	ip := net.IP(addr.Unmap().AsSlice())
+	if e.city != nil {
+		var record cityRecord
+		if err := e.city.lookup(ip, &record); err != nil {
+			return types.GeoIPData{}, err
+		}
+		data.CountryCode = record.Country.ISOCode
+		data.CountryName = localized(record.Country.Names, e.language)
+		data.City = localized(record.City.Names, e.language)
+		data.CityID = record.City.GeoNameID
+		data.Latitude = record.Location.Latitude
+		data.Longitude = record.Location.Longitude
+	}
+	if e.asn != nil {
+		var record asnRecord
+		if err := e.asn.lookup(ip, &record); err != nil {
+			return types.GeoIPData{}, err
+		}
+		data.ASN = record.Number
+		data.ASOrganization = record.Organization
+	}
+	return data, nil
+}
+
+func localized(names map[string]string, language string) string {
+	if name, ok := names[language]; ok {
+		return name
+	}
+	return names["en"]
+}
+
+// claim reports if the ip is due a lookup, it isn't again within the ttl
+func (e *GeoIPEnricher) claim(ip string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	if at, ok := e.enriched[ip]; ok && now.Sub(at) < geoIPTTL {
+		return false
+	}
+	if len(e.enriched) >= maxGeoIPCached {
+		clear(e.enriched)
+	}
+	e.enriched[ip] = now
+	return true
+}
+
+// release makes the ip due again, after its enrichment failed
+func (e *GeoIPEnricher) release(ip string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.enriched, ip)
+}
+
+// watch reloads the databases whose files changed, every ip is looked up again after
+func (e *GeoIPEnricher) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, db := range []*geoDatabase{e.city, e.asn} {
+			if db == nil {
+				continue
+			}
+			reloaded, err := db.load()
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to reload GeoIP database, keeping the loaded one", "path", db.path, "error", err)
+				continue
+			}
+			if reloaded {
+				slog.InfoContext(ctx, "Reloaded GeoIP database", "path", db.path, "type", db.reader.Load().Metadata.DatabaseType)
+				e.mu.Lock()
+				clear(e.enriched)
+				e.mu.Unlock()
+			}
+		}
+	}
+}
+
+// geoDatabase is a .mmdb file, lookups use the reader of the last load that succeeded
+type geoDatabase struct {
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+	info   os.FileInfo // of the loaded file, only used by load
+}
+
+func openGeoDatabase(path string) (*geoDatabase, error) {
+	db := &geoDatabase{path: path}
+	if _, err := db.load(); err != nil {
+		return nil, err
+	}
+	slog.Info("Opened GeoIP database", "path", path, "type", db.reader.Load().Metadata.DatabaseType)
+	return db, nil
+}
+
+// load reads the file again when it changed since the last load. The whole file is read,
+// rather than mapped, so a database overwritten in place doesn't change under the lookups.
+func (db *geoDatabase) load() (bool, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat geoip database: %w", err)
+	}
+	if db.info != nil && os.SameFile(info, db.info) && info.ModTime().Equal(db.info.ModTime()) && info.Size() == db.info.Size() {
+		return false, nil
+	}
+	b, err := os.ReadFile(db.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read geoip database: %w", err)
+	}
+	reader, err := maxminddb.FromBytes(b)
+	if err != nil {
+		// e.g. a file still being written, it's loaded at a later check
+		return false, fmt.Errorf("failed to open geoip database %s: %w", db.path, err)
+	}
+	db.reader.Store(reader)
+	db.info = info
+	return true, nil
+}
+
+func (db *geoDatabase) lookup(ip net.IP, result any) error {
+	reader := db.reader.Load()
+	if len(ip) == net.IPv6len && reader.Metadata.IPVersion == 4 {
+		// an ipv4 only database has none of them
+		return nil
+	}
+	if err := reader.Lookup(ip, result); err != nil {
+		return fmt.Errorf("failed to look up %s in %s: %w", ip, db.path, err)
+	}
+	return nil
+}
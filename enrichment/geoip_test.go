@@ -0,0 +1,274 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"iter"
+	"maps"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryGeoStore struct {
+	mu    sync.Mutex
+	ips   []types.IPAddress
+	saved []types.GeoIPData
+}
+
+func (s *memoryGeoStore) SaveGeoIPData(_ context.Context, _ types.IPAddress, data types.GeoIPData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved = append(s.saved, data)
+	return nil
+}
+
+func (s *memoryGeoStore) IterOverIPAddresses(context.Context) (iter.Seq2[types.IPAddress, error], error) {
+	return func(yield func(types.IPAddress, error) bool) {
+		for _, ip := range s.ips {
+			if !yield(ip, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+func (s *memoryGeoStore) get() []types.GeoIPData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.saved)
+}
+
+func cityRecordOf(city, localCity string, geonameID uint32, code, country string) map[string]any {
+	return map[string]any{
+		"city": map[string]any{
+			"geoname_id": geonameID,
+			"names":      map[string]any{"en": city, "pt": localCity},
+		},
+		"country": map[string]any{
+			"iso_code": code,
+			"names":    map[string]any{"en": country},
+		},
+		"location": map[string]any{"latitude": 38.7167, "longitude": -9.1333},
+	}
+}
+
+func newTestGeoIP(t *testing.T, config GeoIPConfig) (*GeoIPEnricher, *memoryGeoStore) {
+	t.Helper()
+	dir := t.TempDir()
+	config.CityDB = filepath.Join(dir, "city.mmdb")
+	config.ASNDB = filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, config.CityDB, "GeoLite2-City", map[string]any{
+		"203.0.113.0/24": cityRecordOf("Lisbon", "Lisboa", 2267057, "PT", "Portugal"),
+	})
+	writeMMDB(t, config.ASNDB, "GeoLite2-ASN", map[string]any{
+		"203.0.113.0/24": map[string]any{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example Transit",
+		},
+	})
+	store := &memoryGeoStore{}
+	e, err := NewGeoIPEnricher(t.Context(), config, store)
+	assert.NoError(t, err)
+	return e, store
+}
+
+func geoEvent(ip string) types.ParsedEvent {
+	return types.ParsedEvent{ServiceName: types.SSHDService, IPAddress: types.IPAddress{Address: ip}}
+}
+
+func TestGeoIPEnricher(t *testing.T) {
+	lisbon := types.GeoIPData{
+		CountryCode:    "PT",
+		CountryName:    "Portugal",
+		City:           "Lisbon",
+		CityID:         2267057,
+		Latitude:       38.7167,
+		Longitude:      -9.1333,
+		ASN:            64500,
+		ASOrganization: "Example Transit",
+	}
+
+	t.Run("ips are saved once within the ttl", func(t *testing.T) {
+		e, store := newTestGeoIP(t, GeoIPConfig{})
+		e.Enrich(geoEvent("203.0.113.7"))
+		e.Enrich(geoEvent("203.0.113.7"))
+		e.Enrich(geoEvent("::ffff:203.0.113.7"))
+		assert.Equal(t, []types.GeoIPData{lisbon}, store.get())
+	})
+
+	t.Run("ips the databases don't have aren't saved", func(t *testing.T) {
+		e, store := newTestGeoIP(t, GeoIPConfig{})
+		e.Enrich(geoEvent("198.51.100.4"))
+		e.Enrich(geoEvent("2001:db8::1"))
+		e.Enrich(geoEvent("10.0.0.8"))
+		e.Enrich(geoEvent(""))
+		assert.Empty(t, store.get())
+	})
+
+	t.Run("names are in the configured language", func(t *testing.T) {
+		e, _ := newTestGeoIP(t, GeoIPConfig{Language: "pt"})
+		data, err := e.Lookup(netip.MustParseAddr("203.0.113.7"))
+		assert.NoError(t, err)
+		assert.Equal(t, "Lisboa", data.City)
+		assert.Equal(t, "Portugal", data.CountryName, "falls back to english")
+	})
+
+	t.Run("changed databases are reloaded", func(t *testing.T) {
+		e, store := newTestGeoIP(t, GeoIPConfig{ReloadInterval: 10 * time.Millisecond})
+		e.Enrich(geoEvent("203.0.113.7"))
+
+		// replaced like geoipupdate does, by renaming a new file over the old one
+		next := filepath.Join(t.TempDir(), "city.mmdb")
+		writeMMDB(t, next, "GeoLite2-City", map[string]any{
+			"203.0.113.0/24": cityRecordOf("Porto", "Porto", 2735943, "PT", "Portugal"),
+		})
+		assert.NoError(t, os.Rename(next, e.city.path))
+
+		assert.Eventually(t, func() bool {
+			e.Enrich(geoEvent("203.0.113.7"))
+			saved := store.get()
+			return len(saved) > 0 && saved[len(saved)-1].City == "Porto"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("a broken database keeps the loaded one", func(t *testing.T) {
+		e, _ := newTestGeoIP(t, GeoIPConfig{})
+		assert.NoError(t, os.WriteFile(e.city.path, []byte("half written"), 0o644))
+		_, err := e.city.load()
+		assert.Error(t, err)
+
+		data, err := e.Lookup(netip.MustParseAddr("203.0.113.7"))
+		assert.NoError(t, err)
+		assert.Equal(t, lisbon, data)
+	})
+
+	t.Run("enrich all", func(t *testing.T) {
+		e, store := newTestGeoIP(t, GeoIPConfig{})
+		store.ips = []types.IPAddress{{Address: "203.0.113.7"}, {Address: "198.51.100.4"}, {Address: "192.168.1.1"}}
+		assert.NoError(t, e.EnrichAll(t.Context()))
+		assert.Equal(t, []types.GeoIPData{lisbon}, store.get())
+	})
+}
+
+// writeMMDB writes an ipv6 MaxMind database with 24 bit records, the networks are ipv4
+// networks mapped into ::/96 like the GeoLite2 databases have them
+func writeMMDB(t *testing.T, path, databaseType string, networks map[string]any) {
+	t.Helper()
+	// nodes are the search tree, a record is a node index, -1 for no data or -2-n for
+	// the nth record of data
+	nodes := [][2]int{{-1, -1}}
+	var data bytes.Buffer
+	var offsets []int
+	for _, network := range slices.Sorted(maps.Keys(networks)) {
+		prefix := netip.MustParsePrefix(network)
+		offsets = append(offsets, data.Len())
+		encodeMMDB(t, &data, networks[network])
+
+		var ip [16]byte
+		v4 := prefix.Addr().As4()
+		copy(ip[12:], v4[:])
+		bits := 96 + prefix.Bits()
+		node := 0
+		for i := range bits {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == bits-1 {
+				nodes[node][bit] = -2 - (len(offsets) - 1)
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var db bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			value := len(nodes)
+			switch {
+			case record >= 0:
+				value = record
+			case record <= -2:
+				value = len(nodes) + 16 + offsets[-2-record]
+			}
+			db.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeMMDB(t, &db, map[string]any{
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(6),
+		"database_type":               databaseType,
+		"languages":                   []any{"en", "pt"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(2147483647),
+		"description":                 map[string]any{"en": "test database"},
+	})
+	assert.NoError(t, os.WriteFile(path, db.Bytes(), 0o644))
+}
+
+// encodeMMDB writes the value in the data section format, sizes under 285 only
+func encodeMMDB(t *testing.T, b *bytes.Buffer, value any) {
+	control := func(kind, size int) {
+		assert.Less(t, size, 285)
+		var extra []byte
+		if size >= 29 {
+			size, extra = 29, []byte{byte(size - 29)}
+		}
+		if kind <= 7 {
+			b.WriteByte(byte(kind<<5 | size))
+		} else {
+			b.Write([]byte{byte(size), byte(kind - 7)})
+		}
+		b.Write(extra)
+	}
+	unsigned := func(kind int, v uint64) {
+		raw := binary.BigEndian.AppendUint64(nil, v)
+		raw = bytes.TrimLeft(raw, "\x00")
+		control(kind, len(raw))
+		b.Write(raw)
+	}
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		b.WriteString(v)
+	case float64:
+		control(3, 8)
+		b.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case uint64:
+		unsigned(9, v)
+	case map[string]any:
+		control(7, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			encodeMMDB(t, b, k)
+			encodeMMDB(t, b, v[k])
+		}
+	case []any:
+		control(11, len(v))
+		for _, item := range v {
+			encodeMMDB(t, b, item)
+		}
+	default:
+		t.Fatalf("can't encode %T", value)
+	}
+}
@@ -0,0 +1,86 @@
+package enrichment
+
+import (
+	"sync"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// pendingIPs are the ips waiting to be checked, the ones with the most attempts go first
+// so they're the ones checked when the quota runs low
+type pendingIPs struct {
+	mu     sync.Mutex
+	ips    map[string]*pendingIP
+	max    int
+	signal chan struct{}
+}
+
+type pendingIP struct {
+	ip       types.IPAddress
+	attempts int64
+}
+
+func newPendingIPs(max int) *pendingIPs {
+	return &pendingIPs{
+		ips:    map[string]*pendingIP{},
+		max:    max,
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// push adds the attempts to the ip, when full the ip with the fewest attempts is dropped
+func (p *pendingIPs) push(ip types.IPAddress, attempts int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pending, ok := p.ips[ip.Address]; ok {
+		pending.attempts += attempts
+		return
+	}
+	if len(p.ips) >= p.max {
+		fewest := p.least()
+		if fewest.attempts >= attempts {
+			return
+		}
+		delete(p.ips, fewest.ip.Address)
+	}
+	p.ips[ip.Address] = &pendingIP{ip: ip, attempts: attempts}
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the ip with the most attempts
+func (p *pendingIPs) pop() (pendingIP, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var most *pendingIP
+	for _, pending := range p.ips {
+		if most == nil || pending.attempts > most.attempts {
+			most = pending
+		}
+	}
+	if most == nil {
+		return pendingIP{}, false
+	}
+	delete(p.ips, most.ip.Address)
+	return *most, true
+}
+
+func (p *pendingIPs) least() *pendingIP {
+	var fewest *pendingIP
+	for _, pending := range p.ips {
+		if fewest == nil || pending.attempts < fewest.attempts {
+			fewest = pending
+		}
+	}
+	return fewest
+}
+
+func (p *pendingIPs) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.ips)
+}
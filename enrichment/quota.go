@@ -0,0 +1,174 @@
+package enrichment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned while AbuseIPDB refuses checks, until the quota resets or
+// the backoff is over
+var ErrRateLimited = errors.New("abuseipdb rate limited")
+
+const (
+	minBackoff = time.Minute
+	maxBackoff = time.Hour
+)
+
+// quota is a token bucket of the checks of the plan, it's refilled over a day and kept in
+// line with what AbuseIPDB reports is left
+type quota struct {
+	mu    sync.Mutex
+	path  string // where the state is kept across restarts, not kept when empty
+	limit float64
+	state quotaState
+	now   func() time.Time
+}
+
+type quotaState struct {
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// BlockedUntil is set by 429 responses and by an exhausted quota
+	BlockedUntil time.Time `json:"blocked_until,omitzero"`
+	// RateLimited counts the 429 responses in a row, the backoff doubles with each
+	RateLimited int `json:"rate_limited,omitempty"`
+}
+
+// newQuota loads the state at path, a missing file starts with the whole daily limit
+func newQuota(path string, dailyLimit int, now func() time.Time) (*quota, error) {
+	q := &quota{
+		path:  path,
+		limit: float64(dailyLimit),
+		state: quotaState{Tokens: float64(dailyLimit), UpdatedAt: now()},
+		now:   now,
+	}
+	if path == "" {
+		return q, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read abuseipdb quota: %w", err)
+	}
+	if err := json.Unmarshal(b, &q.state); err != nil {
+		return nil, fmt.Errorf("failed to decode abuseipdb quota %s: %w", path, err)
+	}
+	q.state.Tokens = min(q.state.Tokens, q.limit)
+	return q, nil
+}
+
+// refill adds the tokens earned since the last update, the whole limit over a day
+func (q *quota) refill(now time.Time) {
+	if elapsed := now.Sub(q.state.UpdatedAt); elapsed > 0 {
+		q.state.Tokens = min(q.limit, q.state.Tokens+q.limit*elapsed.Hours()/24)
+	}
+	q.state.UpdatedAt = now
+}
+
+// take spends a token for a check, when there's none it returns how long until there is
+func (q *quota) take() (time.Duration, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	q.refill(now)
+	if now.Before(q.state.BlockedUntil) {
+		return q.state.BlockedUntil.Sub(now), nil
+	}
+	if q.state.Tokens < 1 {
+		return time.Duration((1 - q.state.Tokens) / q.limit * float64(24*time.Hour)), nil
+	}
+	q.state.Tokens--
+	return 0, q.save()
+}
+
+// remaining is the number of checks that can be made right away
+func (q *quota) remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	q.refill(now)
+	if now.Before(q.state.BlockedUntil) {
+		return 0
+	}
+	return int(q.state.Tokens)
+}
+
+// observe follows the rate limit headers of a response: what's left of the daily quota
+// and, on 429, how long to wait. It returns ErrRateLimited for 429 responses.
+func (q *quota) observe(resp *http.Response) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	q.refill(now)
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		q.state.Tokens = min(q.state.Tokens, float64(remaining))
+		if reset := parseUnix(resp.Header.Get("X-RateLimit-Reset")); remaining == 0 && reset.After(now) {
+			q.state.BlockedUntil = reset
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		q.state.RateLimited = 0
+		return q.save()
+	}
+	q.state.Tokens = 0
+	q.state.RateLimited++
+	wait := min(minBackoff<<(q.state.RateLimited-1), maxBackoff)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+	}
+	q.state.BlockedUntil = later(q.state.BlockedUntil, now.Add(wait))
+	return errors.Join(fmt.Errorf("%w until %s", ErrRateLimited, q.state.BlockedUntil.Format(time.RFC3339)), q.save())
+}
+
+// save writes the state to a temporary file renamed over the previous one
+func (q *quota) save() error {
+	if q.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(q.state)
+	if err != nil {
+		return fmt.Errorf("failed to encode abuseipdb quota: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save abuseipdb quota: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save abuseipdb quota: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save abuseipdb quota: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("failed to save abuseipdb quota: %w", err)
+	}
+	return nil
+}
+
+func parseUnix(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
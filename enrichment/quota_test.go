@@ -0,0 +1,81 @@
+package enrichment
+
+import (
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	t.Run("refills over a day and persists", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "quota.json")
+		now := time_help.Now()
+		clock := func() time.Time { return now }
+
+		q, err := newQuota(path, 24, clock)
+		assert.NoError(t, err)
+		for range 24 {
+			wait, err := q.take()
+			assert.NoError(t, err)
+			assert.Zero(t, wait)
+		}
+		wait, err := q.take()
+		assert.NoError(t, err)
+		assert.Equal(t, time.Hour, wait)
+
+		// a restart doesn't give the quota back
+		q, err = newQuota(path, 24, clock)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, q.remaining())
+
+		now = now.Add(90 * time.Minute)
+		assert.Equal(t, 1, q.remaining())
+		wait, err = q.take()
+		assert.NoError(t, err)
+		assert.Zero(t, wait)
+	})
+
+	t.Run("follows the rate limit headers", func(t *testing.T) {
+		now := time_help.Now()
+		q, err := newQuota("", 1000, func() time.Time { return now })
+		assert.NoError(t, err)
+
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		resp.Header.Set("X-RateLimit-Remaining", "10")
+		assert.NoError(t, q.observe(resp))
+		assert.Equal(t, 10, q.remaining())
+
+		resp.Header.Set("X-RateLimit-Remaining", "0")
+		resp.Header.Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+		assert.NoError(t, q.observe(resp))
+		wait, _ := q.take()
+		assert.Equal(t, time.Minute, wait)
+	})
+
+	t.Run("backs off on 429", func(t *testing.T) {
+		now := time_help.Now()
+		q, err := newQuota("", 1000, func() time.Time { return now })
+		assert.NoError(t, err)
+
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		resp.Header.Set("Retry-After", "120")
+		assert.ErrorIs(t, q.observe(resp), ErrRateLimited)
+		wait, _ := q.take()
+		assert.Equal(t, 2*time.Minute, wait)
+
+		// without Retry-After the backoff doubles with every 429 in a row
+		now = now.Add(2 * time.Minute)
+		resp.Header.Del("Retry-After")
+		assert.ErrorIs(t, q.observe(resp), ErrRateLimited)
+		wait, _ = q.take()
+		assert.Equal(t, 2*time.Minute, wait)
+
+		assert.NoError(t, q.observe(&http.Response{StatusCode: http.StatusOK, Header: http.Header{}}))
+		assert.Zero(t, q.state.RateLimited)
+	})
+}
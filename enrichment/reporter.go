@@ -0,0 +1,370 @@
+package enrichment
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// AbuseIPDB categories of the reports
+const (
+	bruteForceCategory = 18
+	sshCategory        = 22
+)
+
+const (
+	// minReportInterval is the least time AbuseIPDB accepts between two reports of an ip
+	minReportInterval = 15 * time.Minute
+	// maxCommentLength is the longest comment AbuseIPDB keeps
+	maxCommentLength = 1024
+	// maxTracked bounds the ips the reporter counts attempts of
+	maxTracked = 10000
+)
+
+// ReportStore keeps the reports made to AbuseIPDB
+type ReportStore interface {
+	SaveAIPDBReport(ctx context.Context, target types.IPAddress, report types.AIPDBSubmission) error
+	// GetLastReportedAt returns the zero time for ips that were never reported
+	GetLastReportedAt(ctx context.Context, target types.IPAddress) (time.Time, error)
+}
+
+type AIPDBReporterConfig struct {
+	APIKey            string
+	BaseURL           string        // defaults to the AbuseIPDB v2 api
+	Threshold         int           // failed attempts an ip is reported after, defaults to 10
+	Window            time.Duration // failed attempts are counted within, defaults to 24h
+	Interval          time.Duration // least time between two reports of an ip, defaults to 24h
+	HoneypotUsernames []string      // any attempt with one of them is reported right away
+	Redact            []string      // hostnames removed from the comments, along with those of the events
+}
+
+// MustLoadAIPDBReporterConfig reads the AIPDB_REPORT_* variables, the reporter is only
+// enabled with AIPDB_REPORT=true
+func MustLoadAIPDBReporterConfig() (AIPDBReporterConfig, bool) {
+	if cfg.GetOr("AIPDB_REPORT", "") != "true" {
+		return AIPDBReporterConfig{}, false
+	}
+	return AIPDBReporterConfig{
+		APIKey:            cfg.Must("AIPDB_API_KEY"),
+		BaseURL:           cfg.GetOr("AIPDB_URL", ""),
+		Threshold:         cfg.GetIntOr("AIPDB_REPORT_THRESHOLD", 0),
+		Window:            cfg.GetDurationOr("AIPDB_REPORT_WINDOW", 0),
+		Interval:          cfg.GetDurationOr("AIPDB_REPORT_INTERVAL", 0),
+		HoneypotUsernames: splitList(cfg.GetOr("AIPDB_REPORT_HONEYPOT_USERNAMES", "")),
+		Redact:            splitList(cfg.GetOr("AIPDB_REPORT_REDACT", "")),
+	}, true
+}
+
+// AIPDBReporter reports the ips that fail to authenticate too often, or try a honeypot
+// username, to AbuseIPDB
+type AIPDBReporter struct {
+	ctx     context.Context
+	apiKey  string
+	baseURL string
+	client  *http.Client
+	store   ReportStore
+	config  AIPDBReporterConfig
+
+	mu       sync.Mutex
+	attempts map[string]*failedAttempts
+	now      func() time.Time
+}
+
+// failedAttempts are those of an ip since it was last reported, within the window
+type failedAttempts struct {
+	count      int
+	first      time.Time
+	last       time.Time
+	reportedAt time.Time
+	reporting  bool // a report of the ip is being made
+	usernames  []string
+	services   []string
+	content    string
+	honeypot   string // the honeypot username the ip tried
+}
+
+func (_ *AIPDBReporter) Name() string {
+	return "AIPDBReporter"
+}
+
+func NewAIPDBReporter(ctx context.Context, config AIPDBReporterConfig, store ReportStore) *AIPDBReporter {
+	config.BaseURL = cmp.Or(config.BaseURL, defaultAIPDBURL)
+	if config.Threshold <= 0 {
+		config.Threshold = 10
+	}
+	if config.Window <= 0 {
+		config.Window = 24 * time.Hour
+	}
+	config.Interval = max(cmp.Or(config.Interval, 24*time.Hour), minReportInterval)
+	return &AIPDBReporter{
+		ctx:      ctx,
+		apiKey:   config.APIKey,
+		baseURL:  config.BaseURL,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		store:    store,
+		config:   config,
+		attempts: map[string]*failedAttempts{},
+		now:      time.Now,
+	}
+}
+
+// Enrich counts the failed attempt of the event, the ip is reported once it crosses the threshold
+func (r *AIPDBReporter) Enrich(parsed types.ParsedEvent) {
+	addr, err := netip.ParseAddr(parsed.IPAddress.Address)
+	if err != nil || isInternal(addr) {
+		return
+	}
+	attempts, ok := r.count(parsed)
+	if !ok {
+		return
+	}
+	reportedAt, err := r.report(r.ctx, parsed, attempts)
+	r.reported(parsed.IPAddress.Address, reportedAt)
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Failed to report IP to AbuseIPDB", "ip", parsed.IPAddress.Address, "error", err)
+	}
+}
+
+// count adds the event to the failed attempts of its ip, it returns them when the ip is
+// due a report. They're reset so the next report counts attempts anew.
+func (r *AIPDBReporter) count(event types.ParsedEvent) (failedAttempts, bool) {
+	attempt := event.Auth
+	if !attempt.IsPresent() && event.SSHDEvent.IsPresent() {
+		// sshd events carry theirs in the sshd event, like the stores count them
+		attempt = event.SSHDEvent.Value.Attempt()
+	}
+	honeypot := event.Username.Name != "" && slices.Contains(r.config.HoneypotUsernames, event.Username.Name)
+	if !honeypot && (!attempt.IsPresent() || attempt.Value.Success) {
+		return failedAttempts{}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	a, ok := r.attempts[event.IPAddress.Address]
+	if !ok {
+		if len(r.attempts) >= maxTracked {
+			r.forget(now)
+		}
+		a = &failedAttempts{}
+		r.attempts[event.IPAddress.Address] = a
+	}
+	if a.count > 0 && now.Sub(a.first) > r.config.Window {
+		*a = failedAttempts{reportedAt: a.reportedAt, reporting: a.reporting}
+	}
+	if a.count == 0 {
+		a.first = now
+	}
+	a.count++
+	a.last = now
+	a.content = event.Content
+	if honeypot {
+		a.honeypot = event.Username.Name
+	}
+	if name := event.Username.Name; name != "" && !slices.Contains(a.usernames, name) && len(a.usernames) < 5 {
+		a.usernames = append(a.usernames, name)
+	}
+	if service := event.ServiceName.String(); !slices.Contains(a.services, service) {
+		a.services = append(a.services, service)
+	}
+
+	if a.honeypot == "" && a.count < r.config.Threshold {
+		return failedAttempts{}, false
+	}
+	if a.reporting || !a.reportedAt.IsZero() && now.Sub(a.reportedAt) < r.config.Interval {
+		return failedAttempts{}, false
+	}
+	due := *a
+	*a = failedAttempts{reportedAt: a.reportedAt, reporting: true}
+	return due, true
+}
+
+// reported ends the report of the ip, at is when it was reported and is zero when AbuseIPDB
+// didn't take the report, the ip is then reported again once it crosses the threshold again
+func (r *AIPDBReporter) reported(ip string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.attempts[ip]
+	if !ok {
+		return
+	}
+	a.reporting = false
+	if !at.IsZero() {
+		a.reportedAt = at
+	}
+}
+
+// forget drops the ips without attempts within the window, or the oldest ones when all have
+func (r *AIPDBReporter) forget(now time.Time) {
+	for ip, a := range r.attempts {
+		if now.Sub(a.last) > r.config.Window && now.Sub(a.reportedAt) > r.config.Interval {
+			delete(r.attempts, ip)
+		}
+	}
+	for len(r.attempts) >= maxTracked {
+		oldest := ""
+		for ip, a := range r.attempts {
+			if oldest == "" || a.last.Before(r.attempts[oldest].last) {
+				oldest = ip
+			}
+		}
+		delete(r.attempts, oldest)
+	}
+}
+
+// report sends the attempts of the ip to AbuseIPDB, unless the store already has a report
+// within the interval, e.g. made before a restart. It returns when the ip was reported, which
+// is set along with the error when AbuseIPDB took the report but saving it failed.
+func (r *AIPDBReporter) report(ctx context.Context, event types.ParsedEvent, attempts failedAttempts) (time.Time, error) {
+	lastReported, err := r.store.GetLastReportedAt(ctx, event.IPAddress)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last report time: %w", err)
+	}
+	if !lastReported.IsZero() && r.now().Sub(lastReported) < r.config.Interval {
+		slog.DebugContext(ctx, "IP was reported to AbuseIPDB recently, skipping", "ip", event.IPAddress.Address, "last_reported", lastReported)
+		return lastReported, nil
+	}
+
+	submission := types.AIPDBSubmission{
+		Categories: reportCategories(attempts.services),
+		Comment:    r.comment(event, attempts),
+		ReportedAt: r.now(),
+	}
+	form := url.Values{}
+	form.Set("ip", event.IPAddress.Address)
+	form.Set("categories", joinInts(submission.Categories))
+	form.Set("comment", submission.Comment)
+	form.Set("timestamp", attempts.last.Format(time.RFC3339))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/report", strings.NewReader(form.Encode()))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Key", r.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to report IP %s: %w", event.IPAddress.Address, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// 429 is also what AbuseIPDB answers to reports within 15 minutes of the last one
+		return time.Time{}, fmt.Errorf("failed to report IP %s: %s", event.IPAddress.Address, resp.Status)
+	}
+	var response struct {
+		Data struct {
+			AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode report response for IP %s: %w", event.IPAddress.Address, err)
+	}
+	submission.AbuseConfidenceScore = response.Data.AbuseConfidenceScore
+
+	slog.InfoContext(ctx, "Reported IP to AbuseIPDB", "ip", event.IPAddress.Address, "attempts", attempts.count, "score", submission.AbuseConfidenceScore)
+	if err := r.store.SaveAIPDBReport(ctx, event.IPAddress, submission); err != nil {
+		return submission.ReportedAt, fmt.Errorf("failed to save AbuseIPDB report of IP %s: %w", event.IPAddress.Address, err)
+	}
+	return submission.ReportedAt, nil
+}
+
+func reportCategories(services []string) []int {
+	categories := []int{bruteForceCategory}
+	for _, service := range services {
+		if service == types.SSHDService.String() || service == types.CowrieService.String() {
+			return append(categories, sshCategory)
+		}
+	}
+	return categories
+}
+
+// comment describes the attempts without our hostnames or internal ips
+func (r *AIPDBReporter) comment(event types.ParsedEvent, attempts failedAttempts) string {
+	var b strings.Builder
+	if attempts.honeypot != "" {
+		fmt.Fprintf(&b, "Authentication attempt with honeypot username %s on %s.", attempts.honeypot, strings.Join(attempts.services, ", "))
+	} else {
+		fmt.Fprintf(&b, "%d failed authentication attempts on %s in %s.",
+			attempts.count, strings.Join(attempts.services, ", "), attempts.last.Sub(attempts.first).Round(time.Second))
+	}
+	if len(attempts.usernames) > 0 {
+		fmt.Fprintf(&b, " Usernames: %s.", strings.Join(attempts.usernames, ", "))
+	}
+	if attempts.content != "" {
+		fmt.Fprintf(&b, " Last: %s", attempts.content)
+	}
+
+	hostnames := append(slices.Clone(r.config.Redact), event.Hostname, event.Service.Host)
+	return sanitize(b.String(), hostnames)
+}
+
+// addressPattern finds ipv4 and ipv6 candidates, they're checked by netip.ParseAddr
+var addressPattern = regexp.MustCompile(`[0-9A-Fa-f.:]*[.:][0-9A-Fa-f.:]+`)
+
+// sanitize replaces the hostnames and internal ips in the comment
+func sanitize(comment string, hostnames []string) string {
+	// the longest first, so a hostname isn't left half replaced by its own prefix
+	hostnames = slices.DeleteFunc(slices.Clone(hostnames), func(h string) bool { return len(h) < 2 })
+	slices.SortFunc(hostnames, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	for _, hostname := range slices.Compact(hostnames) {
+		comment = regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(hostname)+`\b`).ReplaceAllString(comment, "[host]")
+	}
+	comment = addressPattern.ReplaceAllStringFunc(comment, func(candidate string) string {
+		addr, err := netip.ParseAddr(strings.Trim(candidate, ".:"))
+		if err != nil || !isInternal(addr) {
+			return candidate
+		}
+		return "[internal]"
+	})
+	if len(comment) > maxCommentLength {
+		// on a rune boundary, the comment has usernames the attackers chose
+		n := maxCommentLength
+		for n > 0 && !utf8.RuneStart(comment[n]) {
+			n--
+		}
+		comment = comment[:n]
+	}
+	return comment
+}
+
+func isInternal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() ||
+		netip.MustParsePrefix("100.64.0.0/10").Contains(addr) // carrier grade nat
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		parts = append(parts, strconv.Itoa(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitList(value string) []string {
+	var rtn []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			rtn = append(rtn, part)
+		}
+	}
+	return rtn
+}
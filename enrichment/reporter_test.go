@@ -0,0 +1,202 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryReportStore struct {
+	mu       sync.Mutex
+	reported map[string]types.AIPDBSubmission
+	saveErr  error
+}
+
+func (s *memoryReportStore) SaveAIPDBReport(_ context.Context, target types.IPAddress, report types.AIPDBSubmission) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.reported[target.Address] = report
+	return nil
+}
+
+func (s *memoryReportStore) GetLastReportedAt(_ context.Context, target types.IPAddress) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reported[target.Address].ReportedAt, nil
+}
+
+// abuseIPDBReports accepts reports, their forms are sent to reports
+func abuseIPDBReports(t *testing.T) (*httptest.Server, chan url.Values) {
+	reports := make(chan url.Values, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/report", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("Key"))
+		assert.NoError(t, r.ParseForm())
+		reports <- r.PostForm
+		w.Write([]byte(`{"data":{"ipAddress":"` + r.PostForm.Get("ip") + `","abuseConfidenceScore":52}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, reports
+}
+
+func failedLogin(ip, username string) types.ParsedEvent {
+	return types.ParsedEvent{
+		ServiceName: types.SSHDService,
+		Hostname:    "bastion.example.internal",
+		Content:     "Failed password for " + username + " from " + ip + " port 22 on bastion.example.internal via 10.0.3.4",
+		IPAddress:   types.IPAddress{Address: ip},
+		Username:    types.Username{Name: username},
+		Service:     types.Service{Name: "sshd", Host: "bastion", Port: 22},
+		SSHDEvent:   opt.Some(types.SSHDParsedEvent{Kind: types.SSHDAuthFailed}),
+	}
+}
+
+func newTestReporter(t *testing.T, url string, config AIPDBReporterConfig) (*AIPDBReporter, *memoryReportStore, *time.Time) {
+	t.Helper()
+	store := &memoryReportStore{reported: map[string]types.AIPDBSubmission{}}
+	config.APIKey, config.BaseURL = "key", url
+	r := NewAIPDBReporter(t.Context(), config, store)
+	now := time_help.Now()
+	r.now = func() time.Time { return now }
+	return r, store, &now
+}
+
+func TestAIPDBReporter(t *testing.T) {
+	t.Run("ips are reported once they cross the threshold", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, store, _ := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 3, Redact: []string{"example.internal"}})
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		r.Enrich(failedLogin("203.0.113.7", "admin"))
+		assert.Len(t, reports, 0)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 1)
+
+		form := <-reports
+		assert.Equal(t, "203.0.113.7", form.Get("ip"))
+		assert.Equal(t, "18,22", form.Get("categories"))
+		assert.Equal(t, "3 failed authentication attempts on sshd in 0s. Usernames: root, admin. "+
+			"Last: Failed password for root from 203.0.113.7 port 22 on [host] via [internal]", form.Get("comment"))
+		assert.Equal(t, 52, store.reported["203.0.113.7"].AbuseConfidenceScore)
+		assert.Equal(t, []int{18, 22}, store.reported["203.0.113.7"].Categories)
+	})
+
+	t.Run("ips aren't reported twice within the interval", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, _, now := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 1, Interval: time.Second})
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		*now = now.Add(10 * time.Minute)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 1, "the interval is never under the 15 minutes of AbuseIPDB")
+
+		*now = now.Add(5 * time.Minute)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 2)
+	})
+
+	t.Run("reports kept by the store aren't made again", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, store, now := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 1})
+		store.reported["203.0.113.7"] = types.AIPDBSubmission{ReportedAt: now.Add(-time.Hour)}
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 0)
+	})
+
+	t.Run("failed reports are made again", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		var refuse sync.Once
+		busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			refused := false
+			refuse.Do(func() {
+				refused = true
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+			if !refused {
+				server.Config.Handler.ServeHTTP(w, r)
+			}
+		}))
+		t.Cleanup(busy.Close)
+		r, store, _ := newTestReporter(t, busy.URL, AIPDBReporterConfig{Threshold: 2})
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Empty(t, store.reported)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 1)
+		assert.Contains(t, store.reported, "203.0.113.7")
+	})
+
+	t.Run("reports that failed to save aren't made again", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, store, now := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 1})
+		store.saveErr = errors.New("neo4j is down")
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		*now = now.Add(time.Hour)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 1)
+		assert.Empty(t, store.reported)
+	})
+
+	t.Run("honeypot usernames are reported right away", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, _, _ := newTestReporter(t, server.URL, AIPDBReporterConfig{HoneypotUsernames: []string{"oracle"}})
+
+		r.Enrich(failedLogin("203.0.113.7", "oracle"))
+		assert.Len(t, reports, 1)
+		assert.True(t, strings.HasPrefix((<-reports).Get("comment"), "Authentication attempt with honeypot username oracle on sshd."))
+	})
+
+	t.Run("successes and internal ips aren't reported", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, _, _ := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 1})
+
+		accepted := failedLogin("203.0.113.7", "root")
+		accepted.SSHDEvent = opt.Some(types.SSHDParsedEvent{Kind: types.SSHDAuthAccepted, Success: true})
+		r.Enrich(accepted)
+		r.Enrich(failedLogin("10.0.0.8", "root"))
+		r.Enrich(failedLogin("::1", "root"))
+		assert.Len(t, reports, 0)
+	})
+
+	t.Run("attempts outside the window are forgotten", func(t *testing.T) {
+		server, reports := abuseIPDBReports(t)
+		r, _, now := newTestReporter(t, server.URL, AIPDBReporterConfig{Threshold: 2, Window: time.Hour})
+
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		*now = now.Add(2 * time.Hour)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 0)
+		r.Enrich(failedLogin("203.0.113.7", "root"))
+		assert.Len(t, reports, 1)
+	})
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "login on [host] from 203.0.113.7 via [internal] and [internal]",
+		sanitize("login on mail.example.org from 203.0.113.7 via 192.168.1.10 and fd00::1", []string{"mail.example.org", "example.org", ""}))
+	assert.Len(t, sanitize(strings.Repeat("a", 2000), nil), maxCommentLength)
+
+	cut := sanitize("a"+strings.Repeat("é", 1000), nil)
+	assert.True(t, utf8.ValidString(cut), "cut on a rune boundary")
+	assert.Len(t, cut, maxCommentLength-1)
+}
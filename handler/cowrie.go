@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// cowrieEvent holds the fields we use from the cowrie json log, see
+// https://docs.cowrie.org/en/latest/OUTPUT.html
+type cowrieEvent struct {
+	EventID   string `json:"eventid"`
+	Timestamp string `json:"timestamp"`
+	Session   string `json:"session"`
+	Sensor    string `json:"sensor"`
+	Protocol  string `json:"protocol"`
+	SrcIP     string `json:"src_ip"`
+	DstPort   int    `json:"dst_port"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Input     string `json:"input"`
+	URL       string `json:"url"`
+	SHASum    string `json:"shasum"`
+}
+
+// cowrieDefaultPorts are used when the connect event of the session wasn't seen
+var cowrieDefaultPorts = map[string]int{
+	"ssh":    22,
+	"telnet": 23,
+}
+
+type cowrieParser struct {
+	sessions *cowrieSessions
+}
+
+func (h *cowrieParser) Name() string {
+	return "cowrie_json_parser"
+}
+
+func NewCowrieParser() cowrieParser {
+	return cowrieParser{
+		sessions: newCowrieSessions(),
+	}
+}
+
+func (h *cowrieParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	parent.ServiceName = types.CowrieService
+	var raw cowrieEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &raw); err != nil {
+		return parent, fmt.Errorf("failed to decode cowrie event: %w", err)
+	}
+	if raw.SrcIP == "" {
+		return parent, fmt.Errorf("cowrie event without src_ip: %s", content)
+	}
+
+	event := types.CowrieParsedEvent{
+		EventID: raw.EventID,
+		Session: raw.Session,
+		Sensor:  raw.Sensor,
+	}
+
+	// the event timestamp is kept over the syslog one, the log may be read well after
+	if ts, err := time.Parse(time.RFC3339Nano, raw.Timestamp); err == nil {
+		parent.Ingestion = ts
+	}
+	session := h.sessions.track(raw, parent.Ingestion)
+	event.Protocol = session.protocol
+
+	switch raw.EventID {
+	case "cowrie.session.connect":
+	case "cowrie.login.success", "cowrie.login.failed":
+		event.Password = raw.Password
+		parent.Auth = opt.Some(types.AuthAttempt{
+			Success: raw.EventID == "cowrie.login.success",
+			Method:  "password",
+		})
+	case "cowrie.command.input", "cowrie.command.failed":
+		event.Command = raw.Input
+		event.Failed = raw.EventID == "cowrie.command.failed"
+	case "cowrie.session.file_download", "cowrie.session.file_upload":
+		if raw.SHASum == "" {
+			// nothing to identify the file by
+			return parent, ErrIgnoredContent
+		}
+		event.Download = opt.Some(types.Download{
+			SHA256: raw.SHASum,
+			URL:    raw.URL,
+			Upload: raw.EventID == "cowrie.session.file_upload",
+		})
+	default:
+		// client.kex, client.size, session.closed, etc
+		return parent, ErrIgnoredContent
+	}
+
+	parent.Service = types.Service{
+		Name: "cowrie",
+		Host: raw.Sensor,
+		Port: session.port,
+	}
+	if parent.Service.Host == "" {
+		parent.Service.Host = parent.Hostname
+	}
+	parent.IPAddress = types.IPAddress{
+		Address: raw.SrcIP,
+	}
+	parent.Username = types.Username{
+		Name: raw.Username,
+	}
+	parent.CowrieEvent = opt.Some(event)
+	return parent, nil
+}
+
+// cowrieSessions remembers the port and protocol of the connect event, the events
+// after it only carry the session id
+type cowrieSessions struct {
+	mu        sync.Mutex
+	idle      time.Duration
+	open      map[string]*cowrieSession
+	lastSweep time.Time
+}
+
+type cowrieSession struct {
+	port     int
+	protocol string
+	lastSeen time.Time
+}
+
+func newCowrieSessions() *cowrieSessions {
+	return &cowrieSessions{
+		idle: 6 * time.Hour,
+		open: map[string]*cowrieSession{},
+	}
+}
+
+func (s *cowrieSessions) track(raw cowrieEvent, at time.Time) cowrieSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if at.Sub(s.lastSweep) > time.Minute {
+		s.lastSweep = at
+		for id, session := range s.open {
+			if at.Sub(session.lastSeen) > s.idle {
+				delete(s.open, id)
+			}
+		}
+	}
+
+	session, ok := s.open[raw.Session]
+	if !ok {
+		session = &cowrieSession{protocol: "ssh"}
+		if raw.Session != "" {
+			s.open[raw.Session] = session
+		}
+	}
+	if raw.Protocol != "" {
+		session.protocol = raw.Protocol
+	}
+	if raw.DstPort != 0 {
+		session.port = raw.DstPort
+	}
+	if session.port == 0 {
+		session.port = cowrieDefaultPorts[session.protocol]
+	}
+	session.lastSeen = at
+	if raw.EventID == "cowrie.session.closed" {
+		delete(s.open, raw.Session)
+	}
+	return *session
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCowrieLog(t *testing.T) {
+	parser := NewCowrieParser()
+	// in order, later events take the port and protocol from the connect event of the session
+	testCases := []struct {
+		name string
+		log  string
+	}{
+		{
+			name: "Session connect",
+			log:  `{"eventid":"cowrie.session.connect","src_ip":"203.0.113.7","src_port":50122,"dst_ip":"10.0.0.5","dst_port":2222,"session":"a1b2c3d4e5f6","protocol":"ssh","message":"New connection: 203.0.113.7:50122 (10.0.0.5:2222) [session: a1b2c3d4e5f6]","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:07.000000Z"}`,
+		},
+		{
+			name: "Login failed",
+			log:  `{"eventid":"cowrie.login.failed","username":"root","password":"123456","message":"login attempt [root/123456] failed","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:08.123456Z","src_ip":"203.0.113.7","session":"a1b2c3d4e5f6"}`,
+		},
+		{
+			name: "Login success",
+			log:  `{"eventid":"cowrie.login.success","username":"root","password":"admin","message":"login attempt [root/admin] succeeded","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:09.000000Z","src_ip":"203.0.113.7","session":"a1b2c3d4e5f6"}`,
+		},
+		{
+			name: "Command input",
+			log:  `{"eventid":"cowrie.command.input","input":"uname -a","message":"CMD: uname -a","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:10.000000Z","src_ip":"203.0.113.7","session":"a1b2c3d4e5f6"}`,
+		},
+		{
+			name: "File download",
+			log:  `{"eventid":"cowrie.session.file_download","url":"http://198.51.100.66/bins/x86","outfile":"var/lib/cowrie/downloads/9f86d0","shasum":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:11.000000Z","src_ip":"203.0.113.7","session":"a1b2c3d4e5f6"}`,
+		},
+		{
+			name: "Telnet login without connect",
+			log:  `{"eventid":"cowrie.login.failed","username":"admin","password":"admin","sensor":"honeypot-1","timestamp":"2038-01-19T03:14:12.000000Z","src_ip":"198.51.100.4","session":"0f0e0d0c","protocol":"telnet"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "localhost",
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Ignores other events", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `{"eventid":"cowrie.client.kex","hassh":"ec7378c1a92f5a8dde7e8b7a1ddf33d1","src_ip":"203.0.113.7","session":"a1b2c3d4e5f6"}`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+
+	t.Run("Not json", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `New connection: 203.0.113.7:50122`, types.ParsedEvent{})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnoredContent)
+	})
+}
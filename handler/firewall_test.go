@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFirewallLog(t *testing.T) {
+	parser := NewFirewallParser()
+	testCases := []struct {
+		name string
+		log  string
+	}{
+		{
+			name: "UFW block",
+			log:  `[UFW BLOCK] IN=eth0 OUT= MAC=52:54:00:12:34:56:52:54:00:65:43:21:08:00 SRC=203.0.113.7 DST=10.0.0.5 LEN=44 TOS=0x00 PREC=0x00 TTL=243 ID=54321 PROTO=TCP SPT=44321 DPT=3389 WINDOW=1024 RES=0x00 SYN URGP=0`,
+		},
+		{
+			name: "UFW audit with kernel timestamp",
+			log:  `[ 8123.456789] [UFW AUDIT] IN=eth0 OUT= MAC=52:54:00:12:34:56:52:54:00:65:43:21:08:00 SRC=198.51.100.4 DST=10.0.0.5 LEN=60 TOS=0x00 PREC=0x00 TTL=52 ID=1 DF PROTO=TCP SPT=51234 DPT=22 WINDOW=64240 RES=0x00 SYN URGP=0`,
+		},
+		{
+			name: "iptables drop udp",
+			log:  `IPTABLES-DROP: IN=eth0 OUT= MAC=52:54:00:12:34:56:52:54:00:65:43:21:08:00 SRC=203.0.113.7 DST=10.0.0.5 LEN=40 TOS=0x00 PREC=0x00 TTL=244 ID=0 PROTO=UDP SPT=53124 DPT=161 LEN=20`,
+		},
+		{
+			name: "nftables icmp ipv6",
+			log:  `nft allow: IN=eth0 OUT= MAC=52:54:00:12:34:56:52:54:00:65:43:21:86:dd SRC=2001:db8::7 DST=2001:db8::5 LEN=104 TC=0 HOPLIMIT=58 FLOWLBL=0 PROTO=ICMPv6 TYPE=128 CODE=0 ID=1 SEQ=1`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "localhost",
+				Tag:       "kernel",
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Ignores other kernel messages", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `[ 8123.456789] e1000e: eth0 NIC Link is Up 1000 Mbps Full Duplex`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+
+	t.Run("Ignores outgoing packets", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `[UFW BLOCK] IN= OUT=eth0 SRC=10.0.0.5 DST=203.0.113.7 LEN=44 PROTO=TCP SPT=44321 DPT=25`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+
+	t.Run("Missing source address", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `[UFW BLOCK] IN=eth0 OUT= PROTO=TCP DPT=22`, types.ParsedEvent{})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnoredContent)
+	})
+}
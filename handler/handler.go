@@ -2,14 +2,27 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"strconv"
 	"time"
 
+	"github.com/EduardoOliveira/ckc/internal/logparts"
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
 	"github.com/EduardoOliveira/ckc/types"
 	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
 )
 
+// ErrIgnoredContent is returned by parsers for messages of their service that carry nothing
+// worth storing, e.g. postfix queue messages, the handler drops them without a dead letter
+var ErrIgnoredContent = errors.New("content ignored by parser")
+
+// DefaultClockSkewThreshold is how far the sender timestamp may be from the receive time
+// before the event is flagged as skewed
+const DefaultClockSkewThreshold = 5 * time.Minute
+
 type ContentParser interface {
 	Name() string
 	Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error)
@@ -25,12 +38,49 @@ type ContentStore interface {
 	Store(ctx context.Context, parsed types.ParsedEvent) error
 }
 
+type DeadLetterSink interface {
+	Write(ctx context.Context, letter types.DeadLetter) error
+}
+
+// processTimeout bounds the parsing and storing of a single event
+const processTimeout = 5 * time.Second
+
+// StoreError is returned by Process when a store fails, the event may be stored if retried
+type StoreError struct {
+	ServiceName types.ServiceName
+	Store       string
+	Err         error
+
+	retry func(ctx context.Context) error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("store %s failed for service %s: %v", e.Store, e.ServiceName, e.Err)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Retry runs the failed store and the stores after it again, the stores that succeeded
+// aren't run twice so their counts aren't added to again
+func (e *StoreError) Retry(ctx context.Context) error {
+	if e.retry == nil {
+		return e
+	}
+	ctx, cancel := context.WithTimeout(ctx, processTimeout)
+	defer cancel()
+	return e.retry(ctx)
+}
+
 type Handler struct {
-	ctx       context.Context
-	stores    map[types.ServiceName][]ContentStore
-	parsers   map[types.ServiceName][]ContentParser
-	enrichers map[types.ServiceName][]ContentEnricher
-	now       func() time.Time // Function to get the current time, can be overridden for testing
+	ctx         context.Context
+	stores      map[types.ServiceName][]ContentStore
+	parsers     map[types.ServiceName][]ContentParser
+	enrichers   map[types.ServiceName][]ContentEnricher
+	deadLetters DeadLetterSink
+	now         func() time.Time // Function to get the current time, can be overridden for testing
+	maxSkew     time.Duration
 }
 
 func New(ctx context.Context,
@@ -44,7 +94,39 @@ func New(ctx context.Context,
 		stores:    stores,
 		enrichers: enrichers,
 		now:       time.Now,
+		maxSkew:   DefaultClockSkewThreshold,
+	}
+}
+
+func (h *Handler) AddParser(service types.ServiceName, parser ContentParser) {
+	if h.parsers == nil {
+		h.parsers = map[types.ServiceName][]ContentParser{}
+	}
+	h.parsers[service] = append(h.parsers[service], parser)
+}
+
+func (h *Handler) AddStore(service types.ServiceName, store ContentStore) {
+	if h.stores == nil {
+		h.stores = map[types.ServiceName][]ContentStore{}
+	}
+	h.stores[service] = append(h.stores[service], store)
+}
+
+func (h *Handler) AddEnricher(service types.ServiceName, enricher ContentEnricher) {
+	if h.enrichers == nil {
+		h.enrichers = map[types.ServiceName][]ContentEnricher{}
 	}
+	h.enrichers[service] = append(h.enrichers[service], enricher)
+}
+
+// SetDeadLetterSink makes the handler keep the entries it fails to parse or store
+func (h *Handler) SetDeadLetterSink(sink DeadLetterSink) {
+	h.deadLetters = sink
+}
+
+// SetClockSkewThreshold changes how far the sender timestamp may be from the receive time
+func (h *Handler) SetClockSkewThreshold(threshold time.Duration) {
+	h.maxSkew = threshold
 }
 
 func (h *Handler) Handle(logParts syslogformat.LogParts, _ int64, err error) {
@@ -52,28 +134,69 @@ func (h *Handler) Handle(logParts syslogformat.LogParts, _ int64, err error) {
 		slog.Error("Error handling log parts: ", "error", err)
 		return
 	}
+	if err := h.Process(h.ctx, logParts); err != nil {
+		slog.Error("Failed to process log parts", "error", err)
+		h.DeadLetter(h.ctx, logParts, err)
+	}
+}
+
+// DeadLetter hands log parts that Process failed on, and won't be retried, to the dead letter sink
+func (h *Handler) DeadLetter(ctx context.Context, logParts syslogformat.LogParts, err error) {
+	letter := types.DeadLetter{
+		Stage: types.StoreStage,
+		Error: err.Error(),
+	}
+	var storeErr *StoreError
+	if errors.As(err, &storeErr) {
+		letter.ServiceName = storeErr.ServiceName
+		letter.Component = storeErr.Store
+	}
+	h.writeDeadLetter(ctx, logParts, letter)
+}
 
+func (h *Handler) writeDeadLetter(ctx context.Context, logParts syslogformat.LogParts, letter types.DeadLetter) {
+	if h.deadLetters == nil {
+		return
+	}
+	letter.FailedAt = h.now()
+	letter.Content = getContent(logParts)
+	letter.LogParts = logparts.Record(logParts)
+	if err := h.deadLetters.Write(ctx, letter); err != nil {
+		slog.Error("Failed to write dead letter", "stage", letter.Stage, "component", letter.Component, "error", err)
+	}
+}
+
+// Process runs the parsers, stores and enrichers registered for the log parts service.
+// Only store failures, which may be retried, are returned. Content that can't be parsed is
+// written to the dead letter sink instead.
+func (h *Handler) Process(ctx context.Context, logParts syslogformat.LogParts) error {
 	var ok bool
+	var err error
 	var content string
-	if content = getStringValue(logParts, "content", ""); content == "" {
+	if content = getContent(logParts); content == "" {
 		slog.Warn("Log parts missing 'content', skipping", "logParts", logParts)
-		return
+		return nil
 	}
 
+	tag := getTag(logParts)
 	var serviceName types.ServiceName
-	if serviceName, ok = types.ParseServiceNameFromAny(logParts["tag"]); !ok {
+	if serviceName, ok = types.ParseServiceName(tag); !ok {
 		slog.Warn("Failed to parse service name from log parts", "logParts", logParts)
-		return
+		return nil
 	}
 
 	slog.Info("Handling log parts", "service", serviceName, slog.Any("logParts", logParts))
 	parsed := types.ParsedEvent{
-		Hostname:    getStringValue(logParts, "hostname", ""),
-		Ingestion:   getTimeFromLogParts(logParts),
+		Hostname:    getHostname(logParts),
+		Tag:         tag,
+		PID:         getIntValue(logParts, "proc_id", 0),
+		Content:     content,
 		ServiceName: serviceName,
+		Syslog:      getSyslogMetadata(logParts),
 	}
+	h.setEventTime(&parsed, logParts)
 
-	ctx, cancel := context.WithTimeout(h.ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, processTimeout)
 	defer cancel()
 
 	if len(h.parsers[serviceName]) == 0 {
@@ -82,70 +205,124 @@ func (h *Handler) Handle(logParts syslogformat.LogParts, _ int64, err error) {
 		for _, parser := range h.parsers[serviceName] {
 			if parser == nil {
 				slog.Warn("No parser found for service", "service", serviceName)
-				return
+				return nil
 			}
 			parsed, err = parser.Parse(ctx, content, parsed)
 			if err != nil {
+				if errors.Is(err, ErrIgnoredContent) {
+					slog.Debug("Parser ignored content", "service", serviceName, "parser", parser.Name())
+					return nil
+				}
 				slog.Warn("Failed to parse content for service", "service", serviceName, "parser", parser.Name(), "error", err)
-				return
+				h.writeDeadLetter(ctx, logParts, types.DeadLetter{
+					Stage:       types.ParseStage,
+					ServiceName: serviceName,
+					Component:   parser.Name(),
+					Error:       err.Error(),
+				})
+				return nil
 			}
 		}
 	}
 
 	if len(h.stores[serviceName]) == 0 {
 		slog.Warn("No stores registered for service", "service", serviceName)
-		return
-	} else {
-		for _, store := range h.stores[serviceName] {
-			if store == nil {
-				slog.Warn("No store found for service", "service", serviceName)
-				return
-			}
-			if err := store.Store(ctx, parsed); err != nil {
-				slog.Error("Failed to store parsed event", "service", serviceName, "store", store.Name(), "error", err)
-				return
-			}
+		return nil
+	}
+	return h.store(ctx, parsed, h.stores[serviceName])
+}
+
+// store runs the stores in order and then the enrichers, a failed store returns an error
+// that retries from it
+func (h *Handler) store(ctx context.Context, parsed types.ParsedEvent, stores []ContentStore) error {
+	serviceName := parsed.ServiceName
+	for i, store := range stores {
+		if store == nil {
+			slog.Warn("No store found for service", "service", serviceName)
+			return nil
+		}
+		if err := store.Store(ctx, parsed); err != nil {
+			slog.Error("Failed to store parsed event", "service", serviceName, "store", store.Name(), "error", err)
+			return &StoreError{ServiceName: serviceName, Store: store.Name(), Err: err, retry: func(ctx context.Context) error {
+				return h.store(ctx, parsed, stores[i:])
+			}}
 		}
 	}
 
 	if len(h.enrichers[serviceName]) == 0 {
-		slog.Warn("No enrichers registered for service", "service", serviceName)
-		return
+		slog.Debug("No enrichers registered for service", "service", serviceName)
+		return nil
 	} else {
 		for _, enricher := range h.enrichers[serviceName] {
 			if enricher == nil {
 				slog.Warn("No enricher found for service", "service", serviceName)
-				return
+				return nil
 			}
 			// enrichment should be done asynchronously
 			go enricher.Enrich(parsed)
 		}
 	}
+	return nil
 }
 
-// getTimestampFromLogParts extracts timestamp from log parts
-func getTimeFromLogParts(logParts map[string]any) time.Time {
-	// Try to get timestamp from log parts
-	if ts, ok := logParts["timestamp"]; ok {
-		switch v := ts.(type) {
-		case string:
-			// Layout      = "01/02 03:04:05PM '06 -0700" // The reference time, in numerical order.
-			t, err := time.Parse("2006-01-02 15:04:05 -0700 MST", v)
-			if err == nil {
-				return t
-			}
-			slog.Warn("Failed to parse timestamp from string", "timestamp", v, "error", err)
-		case time.Time:
-			return v
-		case int64:
-			return time.Unix(v, 0)
-		case int:
-			return time.Unix(int64(v), 0)
+// setEventTime sets the ingestion to the sender timestamp and measures its skew from the
+// receive time. A sender clock running ahead can't be trusted, so those events are ingested
+// at the receive time, while timestamps behind are kept since relays may hold messages back.
+func (h *Handler) setEventTime(parsed *types.ParsedEvent, logParts map[string]any) {
+	sent, hasSent := getTimeValue(logParts, "timestamp")
+	received, hasReceived := getTimeValue(logParts, syslogfmt.ReceivedAtKey)
+	switch {
+	case hasSent:
+		parsed.Ingestion = sent
+	case hasReceived:
+		parsed.Ingestion = received
+	default:
+		parsed.Ingestion = h.now()
+	}
+	if !hasReceived {
+		return
+	}
+
+	parsed.ReceivedAt = received
+	if !hasSent {
+		return
+	}
+	parsed.ClockSkew = sent.Sub(received)
+	if parsed.ClockSkew > h.maxSkew || parsed.ClockSkew < -h.maxSkew {
+		parsed.ClockSkewed = true
+		slog.Warn("Sender clock is skewed", "hostname", parsed.Hostname, "relay", parsed.Syslog.Relay, "skew", parsed.ClockSkew)
+		if parsed.ClockSkew > 0 {
+			parsed.Ingestion = received
 		}
 	}
+}
+
+// timeLayouts are the layouts of timestamps that went through a string, e.g. json or fmt
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+}
 
-	// If no timestamp found or not a valid type, use current time
-	return time.Now()
+// getTimeValue extracts a timestamp from log parts
+func getTimeValue(logParts map[string]any, key string) (time.Time, bool) {
+	switch v := logParts[key].(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case int64:
+		return time.Unix(v, 0), true
+	case int:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+		slog.Warn("Failed to parse timestamp from string", "key", key, "timestamp", v)
+	}
+	return time.Time{}, false
 }
 
 // getStringValue safely extracts a string value from map
@@ -159,3 +336,84 @@ func getStringValue(data map[string]any, key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getIntValue safely extracts an int value from map
+func getIntValue(data map[string]any, key string, defaultValue int) int {
+	switch v := data[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// getHostname falls back to the address of the sender when the message has no hostname
+func getHostname(logParts map[string]any) string {
+	if hostname := getHeaderValue(logParts, "hostname"); hostname != "" {
+		return hostname
+	}
+	client := getStringValue(logParts, "client", "")
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		return host
+	}
+	return client
+}
+
+// getContent returns the message, RFC 3164 parts name it content and RFC 5424 parts message
+func getContent(logParts map[string]any) string {
+	if content := getStringValue(logParts, "content", ""); content != "" {
+		return content
+	}
+	return getStringValue(logParts, "message", "")
+}
+
+// getTag returns the program that logged the message, RFC 5424 parts have an app_name instead of a tag
+func getTag(logParts map[string]any) string {
+	if tag := getStringValue(logParts, "tag", ""); tag != "" {
+		return tag
+	}
+	return getHeaderValue(logParts, "app_name")
+}
+
+func getSyslogMetadata(logParts map[string]any) types.SyslogMetadata {
+	metadata := types.SyslogMetadata{
+		AppName:  getHeaderValue(logParts, "app_name"),
+		ProcID:   getHeaderValue(logParts, "proc_id"),
+		MsgID:    getHeaderValue(logParts, "msg_id"),
+		Facility: getIntValue(logParts, "facility", 0),
+		Severity: getIntValue(logParts, "severity", 0),
+		TLSPeer:  getStringValue(logParts, "tls_peer", ""),
+	}
+	metadata.FacilityName = syslogfmt.FacilityName(metadata.Facility)
+	metadata.SeverityName = syslogfmt.SeverityName(metadata.Severity)
+
+	// the port of the sender is ephemeral, only the host tells which relay it was
+	client := getStringValue(logParts, "client", "")
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		metadata.Relay = host
+	} else {
+		metadata.Relay = client
+	}
+
+	data, err := syslogfmt.ParseStructuredData(getStringValue(logParts, "structured_data", ""))
+	if err != nil {
+		slog.Warn("Failed to parse structured data", "structured_data", logParts["structured_data"], "error", err)
+	}
+	metadata.StructuredData = data
+	return metadata
+}
+
+// getHeaderValue returns the RFC 5424 header field, empty when absent or nil
+func getHeaderValue(logParts map[string]any, key string) string {
+	if value := getStringValue(logParts, key, ""); value != syslogfmt.NilValue {
+		return value
+	}
+	return ""
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/stretchr/testify/assert"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+type fakeStore struct {
+	err    error
+	stored []types.ParsedEvent
+}
+
+func (s *fakeStore) Name() string { return "fake_store" }
+
+func (s *fakeStore) Store(_ context.Context, parsed types.ParsedEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.stored = append(s.stored, parsed)
+	return nil
+}
+
+type fakeDeadLetters struct {
+	letters []types.DeadLetter
+}
+
+func (d *fakeDeadLetters) Write(_ context.Context, letter types.DeadLetter) error {
+	d.letters = append(d.letters, letter)
+	return nil
+}
+
+func newTestHandler(store *fakeStore, deadLetters *fakeDeadLetters) *Handler {
+	parser := NewSSHDParser()
+	h := New(context.Background(),
+		map[types.ServiceName][]ContentParser{types.SSHDService: {&parser}},
+		map[types.ServiceName][]ContentStore{types.SSHDService: {store}},
+		nil,
+	)
+	h.now = time_help.Now
+	h.SetDeadLetterSink(deadLetters)
+	return h
+}
+
+func TestHandlerDeadLetters(t *testing.T) {
+	t.Run("parse failure", func(t *testing.T) {
+		store := &fakeStore{}
+		deadLetters := &fakeDeadLetters{}
+		h := newTestHandler(store, deadLetters)
+
+		h.Handle(syslogformat.LogParts{"tag": "sshd", "content": "garbage"}, 0, nil)
+
+		assert.Empty(t, store.stored)
+		assert.Len(t, deadLetters.letters, 1)
+		assert.Equal(t, types.ParseStage, deadLetters.letters[0].Stage)
+		assert.Equal(t, "sshd_grok_parser", deadLetters.letters[0].Component)
+		assert.Equal(t, "garbage", deadLetters.letters[0].Content)
+		assert.Equal(t, time_help.Now(), deadLetters.letters[0].FailedAt)
+	})
+
+	t.Run("store failure is returned by process", func(t *testing.T) {
+		store := &fakeStore{err: errors.New("neo4j is down")}
+		deadLetters := &fakeDeadLetters{}
+		h := newTestHandler(store, deadLetters)
+
+		logParts := syslogformat.LogParts{"tag": "sshd", "content": "Failed password for root from 116.31.116.24 port 29160 ssh2"}
+		err := h.Process(context.Background(), logParts)
+		var storeErr *StoreError
+		assert.ErrorAs(t, err, &storeErr)
+		assert.Empty(t, deadLetters.letters)
+
+		h.Handle(logParts, 0, nil)
+		assert.Len(t, deadLetters.letters, 1)
+		assert.Equal(t, types.StoreStage, deadLetters.letters[0].Stage)
+		assert.Equal(t, "fake_store", deadLetters.letters[0].Component)
+		assert.Equal(t, types.SSHDService, deadLetters.letters[0].ServiceName)
+	})
+	t.Run("retries run only the failed store and those after it", func(t *testing.T) {
+		stored := &fakeStore{}
+		failing := &fakeStore{err: errors.New("neo4j is down")}
+		h := newTestHandler(stored, &fakeDeadLetters{})
+		h.AddStore(types.SSHDService, failing)
+
+		logParts := syslogformat.LogParts{"tag": "sshd", "content": "Failed password for root from 116.31.116.24 port 29160 ssh2"}
+		err := h.Process(context.Background(), logParts)
+		var storeErr *StoreError
+		assert.ErrorAs(t, err, &storeErr)
+		assert.ErrorAs(t, storeErr.Retry(context.Background()), &storeErr)
+
+		failing.err = nil
+		assert.NoError(t, storeErr.Retry(context.Background()))
+		assert.Len(t, stored.stored, 1)
+		assert.Len(t, failing.stored, 1)
+	})
+	t.Run("ignored content", func(t *testing.T) {
+		store := &fakeStore{}
+		deadLetters := &fakeDeadLetters{}
+		h := newTestHandler(store, deadLetters)
+		parser := NewPostfixParser()
+		h.AddParser(types.PostfixService, &parser)
+		h.AddStore(types.PostfixService, store)
+
+		h.Handle(syslogformat.LogParts{"tag": "postfix/smtpd", "content": "connect from unknown[203.0.113.5]"}, 0, nil)
+
+		assert.Empty(t, store.stored)
+		assert.Empty(t, deadLetters.letters)
+	})
+}
+
+func TestHandlerSyslogMetadata(t *testing.T) {
+	store := &fakeStore{}
+	h := newTestHandler(store, &fakeDeadLetters{})
+
+	err := h.Process(context.Background(), syslogformat.LogParts{
+		"priority":        38,
+		"facility":        4,
+		"severity":        6,
+		"version":         1,
+		"timestamp":       time_help.Now(),
+		"hostname":        "bastion",
+		"app_name":        "sshd",
+		"proc_id":         "444326",
+		"msg_id":          "-",
+		"structured_data": `[origin ip="10.0.0.5" software="rsyslogd"]`,
+		"message":         "Failed password for root from 187.174.238.116 port 49494 ssh2",
+		"client":          "192.0.2.10:51514",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, store.stored, 1)
+
+	event := store.stored[0]
+	assert.Equal(t, types.SSHDService, event.ServiceName)
+	assert.Equal(t, "sshd", event.Tag)
+	assert.Equal(t, 444326, event.PID)
+	assert.Equal(t, "bastion", event.Hostname)
+	assert.Equal(t, types.SyslogMetadata{
+		AppName:      "sshd",
+		ProcID:       "444326",
+		Facility:     4,
+		FacilityName: "auth",
+		Severity:     6,
+		SeverityName: "info",
+		StructuredData: map[string]map[string]string{
+			"origin": {"ip": "10.0.0.5", "software": "rsyslogd"},
+		},
+		Relay: "192.0.2.10",
+	}, event.Syslog)
+}
+
+func TestHandlerClockSkew(t *testing.T) {
+	content := "Failed password for root from 116.31.116.24 port 29160 ssh2"
+	received := time_help.Now()
+	testCases := []struct {
+		name      string
+		sent      time.Time
+		ingestion time.Time
+		skewed    bool
+	}{
+		{name: "within threshold", sent: received.Add(-2 * time.Second), ingestion: received.Add(-2 * time.Second)},
+		{name: "sender ahead", sent: received.Add(time.Hour), ingestion: received, skewed: true},
+		{name: "sender behind", sent: received.Add(-time.Hour), ingestion: received.Add(-time.Hour), skewed: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeStore{}
+			h := newTestHandler(store, &fakeDeadLetters{})
+
+			err := h.Process(context.Background(), syslogformat.LogParts{
+				"tag":         "sshd",
+				"content":     content,
+				"timestamp":   tc.sent,
+				"received_at": received,
+			})
+			assert.NoError(t, err)
+			assert.Len(t, store.stored, 1)
+			event := store.stored[0]
+			assert.Equal(t, tc.ingestion, event.Ingestion)
+			assert.Equal(t, received, event.ReceivedAt)
+			assert.Equal(t, tc.sent.Sub(received), event.ClockSkew)
+			assert.Equal(t, tc.skewed, event.ClockSkewed)
+		})
+	}
+
+	t.Run("string timestamps", func(t *testing.T) {
+		store := &fakeStore{}
+		h := newTestHandler(store, &fakeDeadLetters{})
+
+		err := h.Process(context.Background(), syslogformat.LogParts{
+			"tag":         "sshd",
+			"content":     content,
+			"timestamp":   received.Format(time.RFC3339Nano),
+			"received_at": received.Format(time.RFC3339Nano),
+		})
+		assert.NoError(t, err)
+		assert.True(t, received.Equal(store.stored[0].Ingestion))
+		assert.True(t, received.Equal(store.stored[0].ReceivedAt))
+	})
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/elastic/go-grok"
+)
+
+// httpCombinedPattern matches the common and combined log formats of nginx and apache,
+// anything nginx appends after the user agent (e.g. $http_x_forwarded_for) is ignored
+const httpCombinedPattern = `^%{IPORHOST:http.client.ip} (?:-|%{NOTSPACE:http.ident}) (?:-|%{NOTSPACE:http.user}) \[%{HTTPDATE:http.timestamp}\] "(?:%{WORD:http.method} %{NOTSPACE:http.path}(?: HTTP/%{NUMBER:http.version})?|%{DATA:http.request})" (?:-|%{INT:http.status}) (?:-|%{INT:http.bytes})(?: "(?:-|%{DATA:http.referrer})" "(?:-|%{DATA:http.user_agent})")?.*$`
+
+type httpProbe struct {
+	category  string
+	fragments []string // matched against the lower cased path
+}
+
+// httpProbes are paths requested by scanners and never served by our web tier,
+// the first one to match decides the category
+var httpProbes = []httpProbe{
+	{category: "traversal", fragments: []string{"../", "..%2f", ".%2e", "%2e."}},
+	{category: "dotenv", fragments: []string{"/.env"}},
+	{category: "vcs", fragments: []string{"/.git", "/.svn/", "/.hg/"}},
+	{category: "credentials", fragments: []string{"/.aws/", "/.ssh/", "/.docker/", "/id_rsa", "/.htpasswd", "/.ds_store"}},
+	{category: "wordpress", fragments: []string{"/wp-login.php", "/wp-admin", "/wp-content/", "/wp-includes/", "/xmlrpc.php", "/wp-config"}},
+	{category: "phpmyadmin", fragments: []string{"/phpmyadmin", "/pma/", "/myadmin/"}},
+	{category: "cgi", fragments: []string{"/cgi-bin/"}},
+	{category: "php", fragments: []string{"/vendor/phpunit/", "/phpinfo.php", "/info.php", "/shell.php", "/eval-stdin.php"}},
+	{category: "actuator", fragments: []string{"/actuator/"}},
+	{category: "router", fragments: []string{"/hnap1", "/boaform/", "/goform/", "/setup.cgi"}},
+	{category: "config", fragments: []string{"/config.json", "/.vscode/", "/server-status", "/admin/config"}},
+}
+
+type httpParser struct {
+	grok *grok.Grok
+}
+
+func (h *httpParser) Name() string {
+	return "http_access_log_parser"
+}
+
+func NewHTTPParser() httpParser {
+	g := grok.New()
+	if err := g.Compile(httpCombinedPattern, true); err != nil {
+		panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+	}
+	return httpParser{grok: g}
+}
+
+func (h *httpParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	parent.ServiceName = types.HTTPService
+	content = strings.TrimSpace(content)
+
+	var fields map[string]string
+	var err error
+	if strings.HasPrefix(content, "{") {
+		fields, err = parseHTTPJSON(content)
+	} else {
+		fields, err = h.parseCombined(content)
+	}
+	if err != nil {
+		return parent, fmt.Errorf("failed to parse content: %w", err)
+	}
+
+	parent.Service = types.Service{
+		Name: "http",
+		Host: parent.Hostname,
+		Port: 80,
+	}
+	if port, err := strconv.Atoi(fields["http.server.port"]); err == nil {
+		parent.Service.Port = port
+	}
+	parent.IPAddress = types.IPAddress{
+		Address: fields["http.client.ip"],
+	}
+	parent.Username = types.Username{
+		Name: fields["http.user"],
+	}
+
+	event := types.HTTPParsedEvent{
+		Method:    fields["http.method"],
+		Protocol:  opt.FromMap(fields, "http.version").OrElse(""),
+		Referrer:  fields["http.referrer"],
+		UserAgent: fields["http.user_agent"],
+	}
+	if event.Protocol != "" && !strings.HasPrefix(event.Protocol, "HTTP/") {
+		event.Protocol = "HTTP/" + event.Protocol
+	}
+	event.Path, event.Query, _ = strings.Cut(fields["http.path"], "?")
+	if request := fields["http.request"]; event.Path == "" && request != "" {
+		// garbage request lines, e.g. TLS handshakes sent to a plain text port
+		event.Path = request
+		event.Probe = true
+		event.ProbeCategory = "malformed"
+	}
+	if status, ok := fields["http.status"]; ok {
+		event.Status, _ = strconv.Atoi(status)
+	}
+	if bytes, ok := fields["http.bytes"]; ok {
+		event.Bytes, _ = strconv.ParseInt(bytes, 10, 64)
+	}
+	if category, ok := probeCategory(event.Path); ok && !event.Probe {
+		event.Probe = true
+		event.ProbeCategory = category
+	}
+	parent.HTTPEvent = opt.Some(event)
+
+	return parent, nil
+}
+
+func (h *httpParser) parseCombined(content string) (map[string]string, error) {
+	if !h.grok.MatchString(content) {
+		return nil, fmt.Errorf("no grok pattern matched for content: %s", content)
+	}
+	matches, err := h.grok.ParseString(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log with grok pattern: %w", err)
+	}
+	return matches, nil
+}
+
+// httpJSONKeys are the keys nginx (log_format escape=json) and apache json logs are
+// usually written with, mapped to the names captured by httpCombinedPattern
+var httpJSONKeys = map[string][]string{
+	"http.client.ip":   {"remote_addr", "client_ip", "clientip", "remote_ip"},
+	"http.user":        {"remote_user", "user"},
+	"http.method":      {"request_method", "method"},
+	"http.path":        {"request_uri", "uri", "path"},
+	"http.request":     {"request"},
+	"http.version":     {"server_protocol", "protocol"},
+	"http.status":      {"status", "status_code"},
+	"http.bytes":       {"body_bytes_sent", "bytes_sent", "bytes"},
+	"http.referrer":    {"http_referer", "http_referrer", "referer", "referrer"},
+	"http.user_agent":  {"http_user_agent", "user_agent", "agent"},
+	"http.server.port": {"server_port"},
+}
+
+func parseHTTPJSON(content string) (map[string]string, error) {
+	var raw map[string]any
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode json access log: %w", err)
+	}
+
+	fields := map[string]string{}
+	for field, keys := range httpJSONKeys {
+		for _, key := range keys {
+			if value, ok := raw[key]; ok && value != nil && value != "" && value != "-" {
+				fields[field] = fmt.Sprintf("%v", value)
+				break
+			}
+		}
+	}
+	if _, ok := fields["http.method"]; !ok {
+		// only the request line was logged, e.g. "GET /index.html HTTP/1.1"
+		if parts := strings.Fields(fields["http.request"]); len(parts) >= 2 {
+			fields["http.method"] = parts[0]
+			fields["http.path"] = parts[1]
+			if len(parts) > 2 {
+				fields["http.version"] = parts[2]
+			}
+		}
+	}
+	if fields["http.client.ip"] == "" {
+		return nil, fmt.Errorf("json access log has no client address: %s", content)
+	}
+	return fields, nil
+}
+
+func probeCategory(path string) (string, bool) {
+	path = strings.ToLower(path)
+	for _, probe := range httpProbes {
+		for _, fragment := range probe.fragments {
+			if strings.Contains(path, fragment) {
+				return probe.category, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHTTPLog(t *testing.T) {
+	parser := NewHTTPParser()
+	testCases := []struct {
+		name string
+		log  string
+	}{
+		{
+			name: "Combined log format",
+			log:  `198.51.100.23 - - [19/Jan/2038:03:14:07 +0000] "GET /index.html?lang=en HTTP/1.1" 200 5120 "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`,
+		},
+		{
+			name: "Common log format with user",
+			log:  `198.51.100.23 - alice [19/Jan/2038:03:14:07 +0000] "POST /api/login HTTP/1.1" 401 -`,
+		},
+		{
+			name: "Dotenv probe",
+			log:  `203.0.113.9 - - [19/Jan/2038:03:14:07 +0000] "GET /.env HTTP/1.1" 404 153 "-" "zgrab/0.x"`,
+		},
+		{
+			name: "Wordpress probe with forwarded for",
+			log:  `203.0.113.9 - - [19/Jan/2038:03:14:07 +0000] "POST /wp-login.php HTTP/1.1" 404 153 "-" "Mozilla/5.0" "-"`,
+		},
+		{
+			name: "Cgi traversal probe",
+			log:  `203.0.113.9 - - [19/Jan/2038:03:14:07 +0000] "GET /cgi-bin/.%2e/.%2e/bin/sh HTTP/1.1" 400 157 "-" "-"`,
+		},
+		{
+			name: "Garbage request line",
+			log:  `203.0.113.9 - - [19/Jan/2038:03:14:07 +0000] "\x16\x03\x01\x00\xCA\x01" 400 157 "-" "-"`,
+		},
+		{
+			name: "Nginx json log format",
+			log:  `{"remote_addr":"203.0.113.9","remote_user":"","request_method":"GET","request_uri":"/vendor/phpunit/phpunit/src/Util/PHP/eval-stdin.php","server_protocol":"HTTP/1.1","status":404,"body_bytes_sent":"153","http_user_agent":"curl/8.0","server_port":"443"}`,
+		},
+		{
+			name: "Json log format with request line",
+			log:  `{"client_ip":"198.51.100.23","request":"HEAD /HNAP1/ HTTP/1.0","status":"404"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "localhost",
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Unknown message", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `upstream timed out (110: Connection timed out) while reading response header`, types.ParsedEvent{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Json without client address", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `{"request_method":"GET","request_uri":"/"}`, types.ParsedEvent{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePostfixLog(t *testing.T) {
+	parser := NewPostfixParser()
+	testCases := []struct {
+		name string
+		tag  string
+		log  string
+	}{
+		{
+			name: "SASL login failed",
+			tag:  "postfix/submission/smtpd",
+			log:  `warning: unknown[203.0.113.5]: SASL LOGIN authentication failed: UGFzc3dvcmQ6`,
+		},
+		{
+			name: "SASL plain failed with username",
+			tag:  "postfix/smtps/smtpd",
+			log:  `warning: unknown[203.0.113.5]: SASL PLAIN authentication failed: authentication failure, sasl_username=admin@example.com`,
+		},
+		{
+			name: "SASL login accepted",
+			tag:  "postfix/smtpd",
+			log:  `4F3A21C0D2: client=mail.example.net[198.51.100.2]:52114, sasl_method=PLAIN, sasl_username=alice@example.com`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "localhost",
+				Tag:       tc.tag,
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Ignores other messages", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `connect from unknown[203.0.113.5]`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+}
+
+func TestParseDovecotLog(t *testing.T) {
+	parser := NewDovecotParser()
+	testCases := []struct {
+		name string
+		log  string
+	}{
+		{
+			name: "IMAP auth failed",
+			log:  `imap-login: Disconnected (auth failed, 1 attempts in 2 secs): user=<admin>, method=PLAIN, rip=203.0.113.5, lip=10.0.0.1, TLS, session=<kx0Vv9sZ2tfLqA0B>`,
+		},
+		{
+			name: "IMAP auth failed on connection closed",
+			log:  `imap-login: Disconnected: Connection closed (auth failed, 3 attempts in 14 secs): user=<info@example.com>, method=LOGIN, rip=203.0.113.5, lip=10.0.0.1, TLS, session=<kx0Vv9sZ2tfLqA0B>`,
+		},
+		{
+			name: "POP3 aborted login",
+			log:  `pop3-login: Aborted login (auth failed, 2 attempts in 10 secs): user=<test>, method=PLAIN, rip=203.0.113.5, lip=10.0.0.1, session=<kx0Vv9sZ2tfLqA0B>`,
+		},
+		{
+			name: "IMAP login",
+			log:  `imap-login: Login: user=<alice@example.com>, method=PLAIN, rip=198.51.100.2, lip=10.0.0.1, mpid=4121, TLS, session=<kx0Vv9sZ2tfLqA0B>`,
+		},
+		{
+			name: "Disconnect without auth attempts",
+			log:  `imap-login: Disconnected (no auth attempts in 0 secs): user=<>, rip=203.0.113.5, lip=10.0.0.1, session=<kx0Vv9sZ2tfLqA0B>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := parser.Parse(context.Background(), tc.log, types.ParsedEvent{
+				Hostname:  "localhost",
+				Tag:       "dovecot",
+				Ingestion: time_help.Now(),
+			})
+			assert.NoError(t, err)
+			snaps.MatchSnapshot(t, result)
+		})
+	}
+
+	t.Run("Ignores other messages", func(t *testing.T) {
+		_, err := parser.Parse(context.Background(), `auth-worker(4119): pam(admin,203.0.113.5,<kx0Vv9sZ2tfLqA0B>): unknown user`, types.ParsedEvent{})
+		assert.ErrorIs(t, err, ErrIgnoredContent)
+	})
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/elastic/go-grok"
+)
+
+type postfixPattern struct {
+	success bool
+	grok    string
+}
+
+// postfixPatterns only cover SASL authentication, every other smtpd message is ignored
+var postfixPatterns = []postfixPattern{
+	{
+		grok: `^warning: %{NOTSPACE:postfix.client.host}\[%{IP:postfix.client.ip}\](:%{NUMBER:postfix.client.port})?: SASL %{NOTSPACE:postfix.sasl.method} authentication failed: %{DATA:postfix.sasl.reason}(, sasl_username=%{NOTSPACE:postfix.sasl.username})?$`,
+	},
+	{
+		success: true,
+		grok:    `^%{NOTSPACE:postfix.queue_id}: client=%{NOTSPACE:postfix.client.host}\[%{IP:postfix.client.ip}\](:%{NUMBER:postfix.client.port})?, sasl_method=%{DATA:postfix.sasl.method}, sasl_username=%{DATA:postfix.sasl.username}(, %{GREEDYDATA})?$`,
+	},
+}
+
+// postfixPorts maps the master.cf service in the syslog tag to the port it listens on
+var postfixPorts = map[string]int{
+	"smtpd":      25,
+	"submission": 587,
+	"smtps":      465,
+}
+
+type compiledPostfixPattern struct {
+	postfixPattern
+	grok *grok.Grok
+}
+
+type postfixParser struct {
+	groks []compiledPostfixPattern
+}
+
+func (h *postfixParser) Name() string {
+	return "postfix_grok_parser"
+}
+
+func NewPostfixParser() postfixParser {
+	rtn := postfixParser{
+		groks: make([]compiledPostfixPattern, len(postfixPatterns)),
+	}
+	for i, p := range postfixPatterns {
+		g := grok.New()
+		if err := g.Compile(p.grok, true); err != nil {
+			panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+		}
+		rtn.groks[i] = compiledPostfixPattern{postfixPattern: p, grok: g}
+	}
+	return rtn
+}
+
+func (h *postfixParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
+	parent.ServiceName = types.PostfixService
+	pattern, matches, ok := h.parseContent(strings.TrimSpace(content))
+	if !ok {
+		return parent, ErrIgnoredContent
+	}
+
+	parent.Service = types.Service{
+		Name: "smtp",
+		Host: parent.Hostname,
+		Port: postfixPort(parent.Tag),
+	}
+	parent.IPAddress = types.IPAddress{
+		Address: matches["postfix.client.ip"],
+	}
+	parent.Username = types.Username{
+		Name: matches["postfix.sasl.username"],
+	}
+	parent.Auth = opt.Some(types.AuthAttempt{
+		Success: pattern.success,
+		Method:  matches["postfix.sasl.method"],
+	})
+	return parent, nil
+}
+
+// postfixPort takes the service from tags like postfix/submission/smtpd, plain
+// postfix/smtpd is the port 25 listener
+func postfixPort(tag string) int {
+	parts := strings.Split(tag, "/")
+	if len(parts) > 2 {
+		if port, ok := postfixPorts[parts[1]]; ok {
+			return port
+		}
+	}
+	return postfixPorts["smtpd"]
+}
+
+func (h *postfixParser) parseContent(content string) (postfixPattern, map[string]string, bool) {
+	for _, g := range h.groks {
+		if !g.grok.MatchString(content) {
+			continue
+		}
+		matches, err := g.grok.ParseString(content)
+		if err != nil {
+			continue
+		}
+		return g.postfixPattern, matches, true
+	}
+	return postfixPattern{}, nil, false
+}
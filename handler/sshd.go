@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,10 +12,122 @@ import (
 	"github.com/elastic/go-grok"
 )
 
+var sshdGrokDefinitions = map[string]string{
+	"SSHD_ACCEPTED": `Accepted`,
+	"SSHD_FAILED":   `Failed`,
+	"SSHD_INVALID":  `Invalid`,
+}
+
+type sshdPattern struct {
+	kind   types.SSHDEventKind
+	reason string // used when the pattern doesn't capture one
+	grok   string
+}
+
+// sshdPatterns are tried in order against the message with the [preauth] suffix removed,
+// the first one to match decides the event kind
+var sshdPatterns = []sshdPattern{
+	{
+		kind: types.SSHDAuthAccepted,
+		grok: `^%{SSHD_ACCEPTED:system.auth.ssh.event} %{NOTSPACE:system.auth.ssh.method} for %{DATA:system.auth.user} from %{IPORHOST:system.auth.ip} port %{NUMBER:system.auth.port} ssh2(: %{GREEDYDATA:system.auth.ssh.signature})?$`,
+	},
+	{
+		kind: types.SSHDAuthFailed,
+		grok: `^%{SSHD_FAILED:system.auth.ssh.event} %{NOTSPACE:system.auth.ssh.method} for (invalid user )?%{DATA:system.auth.user} from %{IPORHOST:system.auth.ip} port %{NUMBER:system.auth.port} ssh2(: %{GREEDYDATA:system.auth.ssh.signature})?$`,
+	},
+	{
+		kind: types.SSHDInvalidUser,
+		grok: `^%{SSHD_INVALID:system.auth.ssh.event} user %{DATA:system.auth.user} from %{IPORHOST:system.auth.ip}( port %{NUMBER:system.auth.port})?$`,
+	},
+	{
+		kind:   types.SSHDMaxAuthAttempts,
+		reason: "maximum authentication attempts exceeded",
+		grok:   `^error: maximum authentication attempts exceeded for (invalid user )?%{DATA:system.auth.user} from %{IPORHOST:system.auth.ip} port %{NUMBER:system.auth.port} ssh2$`,
+	},
+	{
+		kind:   types.SSHDConnectionClosed,
+		reason: "connection closed while authenticating",
+		grok:   `^Connection (closed|reset) by (invalid|authenticating) user %{DATA:system.auth.user} %{IP:system.auth.ip} port %{NUMBER:system.auth.port}$`,
+	},
+	{
+		kind:   types.SSHDPreauthProbe,
+		reason: "connection closed before authentication",
+		grok:   `^Connection (closed|reset) by %{IP:system.auth.ip} port %{NUMBER:system.auth.port}$`,
+	},
+	{
+		kind: types.SSHDDisconnect,
+		grok: `^Disconnected from ((invalid|authenticating) )?user %{DATA:system.auth.user} %{IP:system.auth.ip} port %{NUMBER:system.auth.port}$`,
+	},
+	{
+		kind: types.SSHDDisconnect,
+		grok: `^Disconnected from %{IP:system.auth.ip} port %{NUMBER:system.auth.port}$`,
+	},
+	{
+		kind: types.SSHDDisconnect,
+		grok: `^Disconnecting ((invalid|authenticating) )?user %{DATA:system.auth.user} %{IP:system.auth.ip} port %{NUMBER:system.auth.port}: %{GREEDYDATA:system.auth.ssh.reason}$`,
+	},
+	{
+		kind: types.SSHDDisconnect,
+		grok: `^Received disconnect from %{IP:system.auth.ip} port %{NUMBER:system.auth.port}:%{NUMBER:system.auth.ssh.disconnect.code}: %{GREEDYDATA:system.auth.ssh.reason}$`,
+	},
+	{
+		kind: types.SSHDKexFailure,
+		grok: `^Unable to negotiate with %{IP:system.auth.ip} port %{NUMBER:system.auth.port}: %{DATA:system.auth.ssh.reason}\. Their offer: %{GREEDYDATA:system.auth.ssh.offer}$`,
+	},
+	{
+		kind:   types.SSHDPreauthProbe,
+		reason: "did not receive identification string",
+		grok:   `^Did not receive identification string from %{IP:system.auth.ip}( port %{NUMBER:system.auth.port})?$`,
+	},
+	{
+		kind: types.SSHDPreauthProbe,
+		grok: `^banner exchange: Connection from %{IP:system.auth.ip} port %{NUMBER:system.auth.port}: %{GREEDYDATA:system.auth.ssh.reason}$`,
+	},
+	{
+		kind:   types.SSHDPreauthProbe,
+		reason: "bad protocol version identification",
+		grok:   `^Bad protocol version identification '%{DATA:system.auth.ssh.banner}' from %{IP:system.auth.ip} port %{NUMBER:system.auth.port}$`,
+	},
+	{
+		kind: types.SSHDSessionOpened,
+		grok: `^pam_unix\(%{DATA}:session\): session opened for user %{USERNAME:system.auth.user}(\(uid=%{NUMBER}\))?( by %{GREEDYDATA})?$`,
+	},
+	{
+		kind: types.SSHDSessionClosed,
+		grok: `^pam_unix\(%{DATA}:session\): session closed for user %{USERNAME:system.auth.user}$`,
+	},
+	{
+		kind: types.SSHDPreauthProbe,
+		grok: `^error: kex_exchange_identification: %{GREEDYDATA:system.auth.ssh.reason}$`,
+	},
+}
+
+// sshdIgnoredPrefixes start the sshd messages that say nothing about a client, or repeat
+// what the lines matched by the patterns log, like the pam_unix lines of a failed password
+var sshdIgnoredPrefixes = []string{
+	"Server listening on ",
+	"Received signal ",
+	"Connection from ",
+	"Postponed ",
+	"Starting session: ",
+	"User child is on pid ",
+	"Close session: ",
+	"Transferred: ",
+	"pam_unix(sshd:auth): ",
+	"PAM ",
+	"error: PAM: ",
+}
+
+type compiledSSHDPattern struct {
+	sshdPattern
+	grok *grok.Grok
+}
+
 type sshdParser struct {
-	target types.Service
-	groks  []*grok.Grok
-	now    func() time.Time
+	target   types.Service
+	groks    []compiledSSHDPattern
+	sessions *sshdSessions
+	now      func() time.Time
 }
 
 func (h *sshdParser) Name() string {
@@ -23,23 +136,23 @@ func (h *sshdParser) Name() string {
 
 func NewSSHDParser() sshdParser {
 	rtn := sshdParser{
-		groks: make([]*grok.Grok, 2),
-		now:   time.Now,
+		groks:    make([]compiledSSHDPattern, len(sshdPatterns)),
+		sessions: newSSHDSessions(),
+		now:      time.Now,
 		target: types.Service{
 			Name: "ssh",
 			Port: 22,
 		},
 	}
-	rtn.groks[0] = grok.New()
-	err := rtn.groks[0].Compile(`%{DATA:system.auth.ssh.event} %{DATA:system.auth.ssh.method} for (invalid user )?%{DATA:system.auth.user} from %{IPORHOST:system.auth.ip} port %{NUMBER:system.auth.port} ssh2(: %{GREEDYDATA:system.auth.ssh.signature})?`, true)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
-	}
-
-	rtn.groks[1] = grok.New()
-	err = rtn.groks[1].Compile(`%{DATA:system.auth.ssh.event} user %{DATA:system.auth.user} from %{IPORHOST:system.auth.ip}`, true)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+	for i, p := range sshdPatterns {
+		g := grok.New()
+		if err := g.AddPatterns(sshdGrokDefinitions); err != nil {
+			panic(fmt.Sprintf("Failed to add grok definitions: %v", err))
+		}
+		if err := g.Compile(p.grok, true); err != nil {
+			panic(fmt.Sprintf("Failed to compile grok pattern: %v", err))
+		}
+		rtn.groks[i] = compiledSSHDPattern{sshdPattern: p, grok: g}
 	}
 
 	return rtn
@@ -47,8 +160,14 @@ func NewSSHDParser() sshdParser {
 
 func (h *sshdParser) Parse(ctx context.Context, content string, parent types.ParsedEvent) (types.ParsedEvent, error) {
 	parent.ServiceName = types.SSHDService
-	matches, err := h.parseContent(content)
+	content, preauth := strings.CutSuffix(strings.TrimSpace(content), " [preauth]")
+	pattern, matches, err := h.parseContent(content)
 	if err != nil {
+		for _, prefix := range sshdIgnoredPrefixes {
+			if strings.HasPrefix(content, prefix) {
+				return parent, ErrIgnoredContent
+			}
+		}
 		return parent, fmt.Errorf("failed to parse content: %w", err)
 	}
 	parent.Service = types.Service{
@@ -63,35 +182,71 @@ func (h *sshdParser) Parse(ctx context.Context, content string, parent types.Par
 		Name: matches["system.auth.user"],
 	}
 
-	parent.SSHDEvent = opt.Some(types.SSHDParsedEvent{
+	event := types.SSHDParsedEvent{
+		Kind:      pattern.kind,
 		Method:    matches["system.auth.ssh.method"],
 		Signature: matches["system.auth.ssh.signature"],
-		Result:    matches["system.auth.ssh.event"],
-	})
-	if strings.HasPrefix(matches["system.auth.ssh.event"], "Accepted") {
-		parent.SSHDEvent.Value.Success = true
+		Result:    opt.FromMap(matches, "system.auth.ssh.event").OrElse(string(pattern.kind)),
+		Reason:    opt.FromMap(matches, "system.auth.ssh.reason").OrElse(pattern.reason),
+		Preauth:   preauth,
+	}
+	if pattern.kind == types.SSHDAuthAccepted {
+		event.Success = true
+	}
+	if pattern.kind.IsAuthAttempt() {
+		parent.Auth = opt.Some(types.AuthAttempt{Success: event.Success, Method: event.Method})
 	}
+	if port, ok := matches["system.auth.port"]; ok {
+		event.Port, _ = strconv.Atoi(port)
+	}
+	if key, ok := parsePublicKey(event.Signature); ok {
+		event.PublicKey = opt.Some(key)
+	}
+	event.SessionID = h.sessions.track(parent.Hostname, parent.PID, parent.IPAddress.Address, parent.Ingestion, pattern.kind)
+	parent.SSHDEvent = opt.Some(event)
 
 	return parent, nil
 }
 
-func (h *sshdParser) parseContent(content string) (map[string]string, error) {
-	bestMatch := map[string]string{}
+// parsePublicKey splits the signature sshd appends to publickey lines, like
+// "RSA SHA256:Zt0Q...", "ED25519-CERT SHA256:... ID user (serial 1) CA ..." or
+// the legacy "RSA 39:33:99:..." md5 fingerprint
+func parsePublicKey(signature string) (types.PublicKey, bool) {
+	fields := strings.Fields(signature)
+	if len(fields) < 2 {
+		return types.PublicKey{}, false
+	}
+	key := types.PublicKey{Type: fields[0], Fingerprint: fields[1]}
+	if hash, _, ok := strings.Cut(fields[1], ":"); ok && !isHexPair(hash) {
+		key.Hash = hash
+	} else {
+		// same form as ssh-keygen -E md5 so both notations end up on the same node
+		key.Hash = "MD5"
+		key.Fingerprint = "MD5:" + fields[1]
+	}
+	return key, true
+}
+
+func isHexPair(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 8)
+	return err == nil
+}
 
+func (h *sshdParser) parseContent(content string) (sshdPattern, map[string]string, error) {
 	for _, g := range h.groks {
-		matches, err := g.ParseString(content)
-		if err != nil {
-			fmt.Printf("Failed to parse log with grok pattern: %v\n", err)
+		if !g.grok.MatchString(content) {
 			continue
 		}
-		if len(matches) > 0 && len(matches) > len(bestMatch) {
-			bestMatch = matches
+		matches, err := g.grok.ParseString(content)
+		if err != nil {
+			return sshdPattern{}, nil, fmt.Errorf("failed to parse log with grok pattern: %w", err)
 		}
+		return g.sshdPattern, matches, nil
 	}
-	if len(bestMatch) == 0 {
-		return nil, fmt.Errorf("no grok pattern matched for content: %s", content)
-	}
-	return bestMatch, nil
+	return sshdPattern{}, nil, fmt.Errorf("no grok pattern matched for content: %s", content)
 }
 
 /*
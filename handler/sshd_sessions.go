@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// sshdSessions correlates the events logged by the same sshd process into a session.
+// A connection may log several closing lines (received disconnect, disconnected,
+// session closed) so an ended session keeps absorbing events for a grace period.
+type sshdSessions struct {
+	mu        sync.Mutex
+	idle      time.Duration // sessions without events for this long are forgotten, pids get reused
+	grace     time.Duration
+	open      map[string]*sshdSession
+	lastSweep time.Time
+}
+
+type sshdSession struct {
+	id       string
+	ip       string
+	lastSeen time.Time
+	endedAt  time.Time
+}
+
+func newSSHDSessions() *sshdSessions {
+	return &sshdSessions{
+		idle:  6 * time.Hour,
+		grace: time.Minute,
+		open:  map[string]*sshdSession{},
+	}
+}
+
+// track returns the id of the session the event belongs to, events without a pid have none
+func (s *sshdSessions) track(host string, pid int, ip string, at time.Time, kind types.SSHDEventKind) string {
+	if pid == 0 {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(at)
+
+	key := fmt.Sprintf("%s/%d", host, pid)
+	session, ok := s.open[key]
+	if ok && !s.belongs(session, ip, at) {
+		ok = false
+	}
+	if !ok {
+		session = &sshdSession{
+			id: fmt.Sprintf("%s-%d-%d", host, pid, at.UnixNano()),
+		}
+		s.open[key] = session
+	}
+
+	if ip != "" {
+		session.ip = ip
+	}
+	session.lastSeen = at
+	if kind.EndsSession() && session.endedAt.IsZero() {
+		session.endedAt = at
+	}
+	return session.id
+}
+
+func (s *sshdSessions) belongs(session *sshdSession, ip string, at time.Time) bool {
+	if ip != "" && session.ip != "" && ip != session.ip {
+		return false
+	}
+	if !session.endedAt.IsZero() && at.Sub(session.endedAt) > s.grace {
+		return false
+	}
+	return at.Sub(session.lastSeen) <= s.idle
+}
+
+func (s *sshdSessions) sweep(at time.Time) {
+	if at.Sub(s.lastSweep) < s.grace {
+		return
+	}
+	s.lastSweep = at
+	for key, session := range s.open {
+		if !s.belongs(session, "", at) {
+			delete(s.open, key)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/EduardoOliveira/ckc/internal/time_help"
 	"github.com/EduardoOliveira/ckc/types"
@@ -47,6 +48,94 @@ func TestParseSSHDLog(t *testing.T) {
 			name: "Invalid user SSHD log",
 			log:  `Invalid user test from 10.0.2.2`,
 		},
+		{
+			name: "Invalid user with port",
+			log:  `Invalid user admin from 203.0.113.7 port 41822`,
+		},
+		{
+			name: "Failed publickey with fingerprint",
+			log:  `Failed publickey for root from 198.51.100.4 port 50022 ssh2: RSA SHA256:Zt0QyJ2yqzB3m2f4q4f0a1m8F3xq6Bf2rX5W0aC1kLs`,
+		},
+		{
+			name: "Accepted publickey with certificate",
+			log:  `Accepted publickey for deploy from 198.51.100.4 port 50022 ssh2: ED25519-CERT SHA256:Zt0QyJ2yqzB3m2f4q4f0a1m8F3xq6Bf2rX5W0aC1kLs ID deploy@ci (serial 42) CA ED25519 SHA256:q4f0a1m8F3xq6Bf2rX5W0aC1kLsZt0QyJ2yqzB3m2f`,
+		},
+		{
+			name: "Failed none for invalid user",
+			log:  `Failed none for invalid user oracle from 203.0.113.7 port 41822 ssh2`,
+		},
+		{
+			name: "Connection closed by authenticating user",
+			log:  `Connection closed by authenticating user root 198.51.100.4 port 50022 [preauth]`,
+		},
+		{
+			name: "Connection closed by invalid user",
+			log:  `Connection closed by invalid user admin 203.0.113.7 port 41822 [preauth]`,
+		},
+		{
+			name: "Connection closed before authentication",
+			log:  `Connection closed by 192.0.2.10 port 33110 [preauth]`,
+		},
+		{
+			name: "Connection reset before authentication",
+			log:  `Connection reset by 192.0.2.10 port 33112 [preauth]`,
+		},
+		{
+			name: "Disconnected from invalid user",
+			log:  `Disconnected from invalid user admin 203.0.113.7 port 41822 [preauth]`,
+		},
+		{
+			name: "Disconnected from authenticating user",
+			log:  `Disconnected from authenticating user root 198.51.100.4 port 50022 [preauth]`,
+		},
+		{
+			name: "Disconnected from user",
+			log:  `Disconnected from user vagrant 10.0.2.2 port 63673`,
+		},
+		{
+			name: "Disconnected without user",
+			log:  `Disconnected from 192.0.2.10 port 33110 [preauth]`,
+		},
+		{
+			name: "Received disconnect",
+			log:  `Received disconnect from 198.51.100.4 port 50022:11: Bye Bye [preauth]`,
+		},
+		{
+			name: "Maximum authentication attempts exceeded",
+			log:  `error: maximum authentication attempts exceeded for root from 198.51.100.4 port 50022 ssh2 [preauth]`,
+		},
+		{
+			name: "Maximum authentication attempts exceeded for invalid user",
+			log:  `error: maximum authentication attempts exceeded for invalid user support from 203.0.113.7 port 41822 ssh2 [preauth]`,
+		},
+		{
+			name: "Unable to negotiate key exchange",
+			log:  `Unable to negotiate with 192.0.2.10 port 33114: no matching key exchange method found. Their offer: diffie-hellman-group14-sha1,diffie-hellman-group1-sha1 [preauth]`,
+		},
+		{
+			name: "Unable to negotiate host key",
+			log:  `Unable to negotiate with 192.0.2.10 port 33116: no matching host key type found. Their offer: ssh-rsa,ssh-dss [preauth]`,
+		},
+		{
+			name: "Banner exchange invalid format",
+			log:  `banner exchange: Connection from 192.0.2.10 port 33118: invalid format`,
+		},
+		{
+			name: "Did not receive identification string",
+			log:  `Did not receive identification string from 192.0.2.10 port 33120`,
+		},
+		{
+			name: "Bad protocol version identification",
+			log:  `Bad protocol version identification 'GET / HTTP/1.1' from 192.0.2.10 port 33122`,
+		},
+		{
+			name: "Kex exchange identification closed",
+			log:  `error: kex_exchange_identification: Connection closed by remote host`,
+		},
+		{
+			name: "Kex exchange identification reset",
+			log:  `error: kex_exchange_identification: read: Connection reset by peer`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -58,4 +147,60 @@ func TestParseSSHDLog(t *testing.T) {
 			snaps.MatchSnapshot(t, result)
 		})
 	}
+
+	t.Run("Unknown message", func(t *testing.T) {
+		_, err := handler.Parse(context.Background(), `Corrupted MAC on input.`, types.ParsedEvent{})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrIgnoredContent)
+	})
+
+	t.Run("Ignores messages that say nothing about a client", func(t *testing.T) {
+		for _, log := range []string{
+			`Server listening on 0.0.0.0 port 22.`,
+			`Received signal 15; terminating.`,
+			`pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=116.31.116.24  user=root`,
+			`Postponed publickey for alice from 192.0.2.15 port 51234 ssh2 [preauth]`,
+			`PAM 2 more authentication failures; logname= uid=0 euid=0 tty=ssh ruser= rhost=116.31.116.24  user=root`,
+		} {
+			_, err := handler.Parse(context.Background(), log, types.ParsedEvent{})
+			assert.ErrorIs(t, err, ErrIgnoredContent, log)
+		}
+	})
+}
+
+func TestSSHDSessions(t *testing.T) {
+	handler := NewSSHDParser()
+	start := time_help.Now()
+
+	parse := func(pid int, offset time.Duration, log string) types.SSHDParsedEvent {
+		t.Helper()
+		result, err := handler.Parse(context.Background(), log, types.ParsedEvent{
+			Hostname:  "bastion",
+			PID:       pid,
+			Ingestion: start.Add(offset),
+		})
+		assert.NoError(t, err)
+		return result.SSHDEvent.OrElse(types.SSHDParsedEvent{})
+	}
+
+	failed := parse(5774, 0, `Failed password for root from 116.31.116.24 port 29160 ssh2`)
+	accepted := parse(5774, 2*time.Second, `Accepted password for root from 116.31.116.24 port 29160 ssh2`)
+	opened := parse(5774, 2*time.Second, `pam_unix(sshd:session): session opened for user root(uid=0) by (uid=0)`)
+	received := parse(5774, time.Hour, `Received disconnect from 116.31.116.24 port 29160:11: disconnected by user`)
+	disconnected := parse(5774, time.Hour, `Disconnected from user root 116.31.116.24 port 29160`)
+	closed := parse(5774, time.Hour+time.Second, `pam_unix(sshd:session): session closed for user root`)
+	other := parse(5801, time.Second, `Invalid user admin from 203.0.113.7 port 41822`)
+	reused := parse(5774, 2*time.Hour, `Connection closed by 192.0.2.10 port 33110 [preauth]`)
+	noPID := parse(0, 0, `Connection closed by 192.0.2.10 port 33110 [preauth]`)
+
+	assert.NotEmpty(t, failed.SessionID)
+	assert.Equal(t, 29160, failed.Port)
+	for _, e := range []types.SSHDParsedEvent{accepted, opened, received, disconnected, closed} {
+		assert.Equal(t, failed.SessionID, e.SessionID, e.Kind)
+	}
+	assert.Equal(t, types.SSHDSessionOpened, opened.Kind)
+	assert.Equal(t, types.SSHDSessionClosed, closed.Kind)
+	assert.NotEqual(t, failed.SessionID, other.SessionID)
+	assert.NotEqual(t, failed.SessionID, reused.SessionID)
+	assert.Empty(t, noPID.SessionID)
 }
@@ -1,6 +1,11 @@
 package cfg
 
-import "github.com/joho/godotenv"
+import (
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
 
 var env map[string]string
 
@@ -24,3 +29,49 @@ func Must(key string) string {
 	}
 	return value
 }
+
+// Get returns the value for key and whether it was set and non empty
+func Get(key string) (string, bool) {
+	if env == nil {
+		MustRead()
+	}
+	value, ok := env[key]
+	if !ok || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// GetOr returns the value for key or defaultValue when it is not set
+func GetOr(key, defaultValue string) string {
+	if value, ok := Get(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// GetIntOr returns the value for key parsed as an int or defaultValue when it is not set
+func GetIntOr(key string, defaultValue int) int {
+	value, ok := Get(key)
+	if !ok {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		panic("Environment variable is not an integer: " + key)
+	}
+	return i
+}
+
+// GetDurationOr returns the value for key parsed as a duration or defaultValue when it is not set
+func GetDurationOr(key string, defaultValue time.Duration) time.Duration {
+	value, ok := Get(key)
+	if !ok {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		panic("Environment variable is not a duration: " + key)
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package logparts
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Record wraps syslog log parts so they survive a json round trip,
+// plain json turns the timestamp and receive time into strings and numbers into floats
+type Record syslogformat.LogParts
+
+type encoded struct {
+	Timestamp  time.Time      `json:"timestamp,omitzero"`
+	ReceivedAt time.Time      `json:"received_at,omitzero"`
+	Parts      map[string]any `json:"parts"`
+}
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	e := encoded{Parts: r}
+	if ts, ok := r["timestamp"].(time.Time); ok {
+		e.Timestamp = ts
+	}
+	if ts, ok := r[syslogfmt.ReceivedAtKey].(time.Time); ok {
+		e.ReceivedAt = ts
+	}
+	return json.Marshal(e)
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var e encoded
+	if err := decoder.Decode(&e); err != nil {
+		return err
+	}
+
+	parts := make(Record, len(e.Parts))
+	for k, v := range e.Parts {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = int(i)
+			} else if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+		parts[k] = v
+	}
+	if !e.Timestamp.IsZero() {
+		parts["timestamp"] = e.Timestamp
+	}
+	if !e.ReceivedAt.IsZero() {
+		parts[syslogfmt.ReceivedAtKey] = e.ReceivedAt
+	}
+	*r = parts
+	return nil
+}
@@ -0,0 +1,113 @@
+package syslogfmt
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// ReceivedAtKey is the log part with the time the message was received
+const ReceivedAtKey = "received_at"
+
+// Format wraps a syslog format to keep the process id, the RFC 3164 parser
+// drops it from the tag while RFC 5424 already reports it as proc_id.
+// It also records when each message was received and puts RFC 3164 timestamps,
+// which the parser reads as UTC of the current year, in the zone and year of the sender.
+type Format struct {
+	syslogformat.Format
+	timezones Timezones
+	now       func() time.Time
+}
+
+func NewFormat(f syslogformat.Format) Format {
+	return Format{Format: f, timezones: Timezones{Default: time.UTC}, now: time.Now}
+}
+
+// WithTimezones returns the format reading RFC 3164 timestamps in the zones of their sources
+func (f Format) WithTimezones(zones Timezones) Format {
+	f.timezones = zones
+	return f
+}
+
+// WithClock returns the format taking the receive time, which the year of RFC 3164
+// timestamps is inferred from, from now, e.g. the modification time of an archived log
+func (f Format) WithClock(now func() time.Time) Format {
+	f.now = now
+	return f
+}
+
+func (f Format) GetParser(line []byte) syslogformat.LogParser {
+	return &parser{LogParser: f.Format.GetParser(line), line: line, timezones: f.timezones, received: f.now()}
+}
+
+type parser struct {
+	syslogformat.LogParser
+	line      []byte
+	timezones Timezones
+	received  time.Time
+}
+
+func (p *parser) Dump() syslogformat.LogParts {
+	logParts := p.LogParser.Dump()
+	logParts[ReceivedAtKey] = p.received
+	p.fixTimestamp(logParts)
+	if _, ok := logParts["proc_id"]; ok {
+		return logParts
+	}
+	if tag, ok := logParts["tag"].(string); ok && tag != "" {
+		if pid, ok := pidFromTag(p.line, tag); ok {
+			logParts["proc_id"] = pid
+		}
+	}
+	return logParts
+}
+
+// pidFromTag finds the digits in "tag[1234]:"
+func pidFromTag(line []byte, tag string) (string, bool) {
+	i := bytes.Index(line, []byte(tag+"["))
+	if i < 0 {
+		return "", false
+	}
+	rest := line[i+len(tag)+1:]
+	end := bytes.IndexByte(rest, ']')
+	if end <= 0 {
+		return "", false
+	}
+	for _, b := range rest[:end] {
+		if b < '0' || b > '9' {
+			return "", false
+		}
+	}
+	return string(rest[:end]), true
+}
+
+// fixTimestamp reads the wall clock of a "Jan _2 15:04:05" timestamp in the zone of the
+// source and picks its year from the receive time, RFC 5424 and RFC 3339 timestamps are kept
+func (p *parser) fixTimestamp(logParts syslogformat.LogParts) {
+	ts, ok := logParts["timestamp"].(time.Time)
+	if !ok || ts.IsZero() {
+		return
+	}
+	if _, ok := logParts["version"]; ok || !hasStampTimestamp(p.line) {
+		return
+	}
+	hostname, _ := logParts["hostname"].(string)
+	relay, _ := logParts["client"].(string)
+	if host, _, err := net.SplitHostPort(relay); err == nil {
+		relay = host
+	}
+	loc := p.timezones.For(hostname, relay)
+	logParts["timestamp"] = InferYear(inLocation(ts, loc), p.received.In(loc))
+}
+
+// hasStampTimestamp tells whether the timestamp after "<PRI>" starts with the month name
+func hasStampTimestamp(line []byte) bool {
+	i := bytes.IndexByte(line, '>')
+	if i < 0 || i+1 >= len(line) {
+		return false
+	}
+	c := line[i+1]
+	return c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,64 @@
+package syslogfmt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	syslog "gopkg.in/mcuadros/go-syslog.v2"
+)
+
+func TestFormat(t *testing.T) {
+	f := NewFormat(syslog.Automatic)
+
+	t.Run("rfc3164 keeps the pid", func(t *testing.T) {
+		p := f.GetParser([]byte(`<38>Feb 21 08:35:22 bastion sshd[5774]: Failed password for root from 116.31.116.24 port 29160 ssh2`))
+		assert.NoError(t, p.Parse())
+		logParts := p.Dump()
+		assert.Equal(t, "sshd", logParts["tag"])
+		assert.Equal(t, "5774", logParts["proc_id"])
+	})
+
+	t.Run("rfc3164 without pid", func(t *testing.T) {
+		p := f.GetParser([]byte(`<4>Feb 21 08:35:22 bastion kernel: [UFW BLOCK] IN=eth0`))
+		assert.NoError(t, p.Parse())
+		_, ok := p.Dump()["proc_id"]
+		assert.False(t, ok)
+	})
+
+	t.Run("rfc5424 proc_id is untouched", func(t *testing.T) {
+		p := f.GetParser([]byte(`<38>1 2025-07-31T10:53:17.000Z bastion sshd 444326 - - Failed password for root from 187.174.238.116 port 49494 ssh2`))
+		assert.NoError(t, p.Parse())
+		assert.Equal(t, "444326", p.Dump()["proc_id"])
+	})
+}
+
+func TestFormatTimestamps(t *testing.T) {
+	zones, err := ParseTimezones("UTC", "bastion=America/New_York")
+	assert.NoError(t, err)
+	f := NewFormat(syslog.Automatic).WithTimezones(zones)
+	f.now = func() time.Time { return time.Date(2039, 1, 1, 0, 0, 30, 0, time.UTC) }
+
+	t.Run("rfc3164 in the zone and year of the source", func(t *testing.T) {
+		p := f.GetParser([]byte(`<38>Dec 31 19:00:10 bastion sshd[5774]: Failed password for root from 116.31.116.24 port 29160 ssh2`))
+		assert.NoError(t, p.Parse())
+		logParts := p.Dump()
+		ts := logParts["timestamp"].(time.Time)
+		assert.True(t, time.Date(2039, 1, 1, 0, 0, 10, 0, time.UTC).Equal(ts), ts)
+		assert.Equal(t, f.now(), logParts[ReceivedAtKey])
+	})
+
+	t.Run("rfc3164 of other sources in the default zone", func(t *testing.T) {
+		p := f.GetParser([]byte(`<38>Dec 31 23:59:50 web-1 sshd[5774]: Failed password for root from 116.31.116.24 port 29160 ssh2`))
+		assert.NoError(t, p.Parse())
+		ts := p.Dump()["timestamp"].(time.Time)
+		assert.True(t, time.Date(2038, 12, 31, 23, 59, 50, 0, time.UTC).Equal(ts), ts)
+	})
+
+	t.Run("rfc5424 timestamp is untouched", func(t *testing.T) {
+		p := f.GetParser([]byte(`<38>1 2025-07-31T10:53:17.000Z bastion sshd 444326 - - Failed password for root from 187.174.238.116 port 49494 ssh2`))
+		assert.NoError(t, p.Parse())
+		ts := p.Dump()["timestamp"].(time.Time)
+		assert.True(t, time.Date(2025, 7, 31, 10, 53, 17, 0, time.UTC).Equal(ts), ts)
+	})
+}
@@ -0,0 +1,89 @@
+package syslogfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NilValue is how RFC 5424 marks a header field or the structured data as absent
+const NilValue = "-"
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// FacilityName returns the keyword RFC 5424 and rsyslog use for the facility code
+func FacilityName(facility int) string {
+	if facility < 0 || facility >= len(facilityNames) {
+		return ""
+	}
+	return facilityNames[facility]
+}
+
+// SeverityName returns the keyword RFC 5424 and rsyslog use for the severity code
+func SeverityName(severity int) string {
+	if severity < 0 || severity >= len(severityNames) {
+		return ""
+	}
+	return severityNames[severity]
+}
+
+// ParseStructuredData splits RFC 5424 structured data, like
+// `[exampleSDID@32473 iut="3" eventSource="Application"][origin ip="192.0.2.1"]`,
+// into the params of each SD-ID
+func ParseStructuredData(data string) (map[string]map[string]string, error) {
+	if data == "" || data == NilValue {
+		return nil, nil
+	}
+
+	rtn := map[string]map[string]string{}
+	for i := 0; i < len(data); {
+		if data[i] != '[' {
+			return nil, fmt.Errorf("expected [ at %d of structured data", i)
+		}
+		i++
+		end := strings.IndexAny(data[i:], " ]")
+		if end <= 0 {
+			return nil, fmt.Errorf("missing SD-ID at %d of structured data", i)
+		}
+		id := data[i : i+end]
+		i += end
+		params := map[string]string{}
+		rtn[id] = params
+
+		for i < len(data) && data[i] == ' ' {
+			i++
+			eq := strings.IndexByte(data[i:], '=')
+			if eq <= 0 || i+eq+1 >= len(data) || data[i+eq+1] != '"' {
+				return nil, fmt.Errorf("malformed param of %s in structured data", id)
+			}
+			name := data[i : i+eq]
+			i += eq + 2
+
+			// PARAM-VALUE escapes '"', '\' and ']' with a backslash
+			var value strings.Builder
+			for ; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' && i+1 < len(data) && strings.IndexByte(`"\]`, data[i+1]) >= 0 {
+					i++
+				}
+				value.WriteByte(data[i])
+			}
+			if i >= len(data) {
+				return nil, fmt.Errorf("unterminated value of %s.%s in structured data", id, name)
+			}
+			i++
+			params[name] = value.String()
+		}
+		if i >= len(data) || data[i] != ']' {
+			return nil, fmt.Errorf("unterminated element %s in structured data", id)
+		}
+		i++
+	}
+	return rtn, nil
+}
@@ -0,0 +1,40 @@
+package syslogfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStructuredData(t *testing.T) {
+	t.Run("nil value", func(t *testing.T) {
+		data, err := ParseStructuredData("-")
+		assert.NoError(t, err)
+		assert.Nil(t, data)
+	})
+
+	t.Run("elements and escapes", func(t *testing.T) {
+		data, err := ParseStructuredData(`[exampleSDID@32473 iut="3" eventSource="Application"][origin ip="192.0.2.1" software="relay \"one\" \]"][meta@1]`)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]string{
+			"exampleSDID@32473": {"iut": "3", "eventSource": "Application"},
+			"origin":            {"ip": "192.0.2.1", "software": `relay "one" ]`},
+			"meta@1":            {},
+		}, data)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, data := range []string{`origin ip="1"`, `[origin ip=1]`, `[origin ip="1"`, `[origin ip="1]`} {
+			_, err := ParseStructuredData(data)
+			assert.Error(t, err, data)
+		}
+	})
+}
+
+func TestFacilityAndSeverityNames(t *testing.T) {
+	assert.Equal(t, "authpriv", FacilityName(10))
+	assert.Equal(t, "local7", FacilityName(23))
+	assert.Equal(t, "", FacilityName(24))
+	assert.Equal(t, "info", SeverityName(6))
+	assert.Equal(t, "", SeverityName(-1))
+}
@@ -0,0 +1,84 @@
+package syslogfmt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timezones tells the zone of the hosts that send RFC 3164 timestamps,
+// which carry neither a year nor a zone
+type Timezones struct {
+	Default *time.Location
+	// Sources by hostname or relay address
+	Sources map[string]*time.Location
+}
+
+// ParseTimezones reads the default zone, e.g. "UTC", and the zone of each source,
+// like "web-1=Europe/Lisbon,192.0.2.10=America/New_York"
+func ParseTimezones(defaultZone, sources string) (Timezones, error) {
+	zones := Timezones{Default: time.UTC, Sources: map[string]*time.Location{}}
+	if defaultZone != "" {
+		loc, err := time.LoadLocation(defaultZone)
+		if err != nil {
+			return Timezones{}, fmt.Errorf("invalid default timezone %q: %w", defaultZone, err)
+		}
+		zones.Default = loc
+	}
+
+	for _, entry := range strings.Split(sources, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		source, zone, ok := strings.Cut(entry, "=")
+		if !ok || source == "" {
+			return Timezones{}, fmt.Errorf("invalid source timezone %q, expected source=zone", entry)
+		}
+		loc, err := time.LoadLocation(strings.TrimSpace(zone))
+		if err != nil {
+			return Timezones{}, fmt.Errorf("invalid timezone of %s: %w", source, err)
+		}
+		zones.Sources[strings.TrimSpace(source)] = loc
+	}
+	return zones, nil
+}
+
+// For returns the zone of the hostname, or of the relay that delivered the message
+func (z Timezones) For(hostname, relay string) *time.Location {
+	if loc, ok := z.Sources[hostname]; ok {
+		return loc
+	}
+	if loc, ok := z.Sources[relay]; ok {
+		return loc
+	}
+	if z.Default != nil {
+		return z.Default
+	}
+	return time.UTC
+}
+
+// InferYear moves a timestamp logged without a year to the year that puts it
+// closest to when it was received, e.g. "Dec 31 23:59:59" received on January 1st
+func InferYear(ts, received time.Time) time.Time {
+	var best time.Time
+	for i, year := range []int{received.Year() - 1, received.Year(), received.Year() + 1} {
+		candidate := time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+		if i == 0 || absDuration(candidate.Sub(received)) < absDuration(best.Sub(received)) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+// inLocation reads the wall clock of ts as a time of loc
+func inLocation(ts time.Time, loc *time.Location) time.Time {
+	return time.Date(ts.Year(), ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), loc)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
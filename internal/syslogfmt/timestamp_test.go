@@ -0,0 +1,58 @@
+package syslogfmt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimezones(t *testing.T) {
+	zones, err := ParseTimezones("Europe/Lisbon", " web-1=America/New_York, 192.0.2.10=Asia/Tokyo ")
+	assert.NoError(t, err)
+	assert.Equal(t, "America/New_York", zones.For("web-1", "192.0.2.10").String())
+	assert.Equal(t, "Asia/Tokyo", zones.For("db-1", "192.0.2.10").String())
+	assert.Equal(t, "Europe/Lisbon", zones.For("db-1", "192.0.2.11").String())
+
+	for _, sources := range []string{"web-1", "=UTC", "web-1=Mars/Olympus"} {
+		_, err := ParseTimezones("", sources)
+		assert.Error(t, err, sources)
+	}
+	_, err = ParseTimezones("Mars/Olympus", "")
+	assert.Error(t, err)
+}
+
+func TestInferYear(t *testing.T) {
+	received := time.Date(2039, 1, 1, 0, 0, 5, 0, time.UTC)
+	testCases := []struct {
+		name string
+		ts   time.Time
+		want time.Time
+	}{
+		{
+			name: "last year",
+			ts:   time.Date(2039, 12, 31, 23, 59, 59, 0, time.UTC),
+			want: time.Date(2038, 12, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name: "same year",
+			ts:   time.Date(0, 1, 1, 0, 0, 1, 0, time.UTC),
+			want: time.Date(2039, 1, 1, 0, 0, 1, 0, time.UTC),
+		},
+		{
+			name: "year set by the parser is replaced",
+			ts:   time.Date(2038, 1, 1, 0, 0, 1, 0, time.UTC),
+			want: time.Date(2039, 1, 1, 0, 0, 1, 0, time.UTC),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, InferYear(tc.ts, received))
+		})
+	}
+
+	t.Run("next year", func(t *testing.T) {
+		received := time.Date(2038, 12, 31, 23, 59, 58, 0, time.UTC)
+		assert.Equal(t, time.Date(2039, 1, 1, 0, 0, 2, 0, time.UTC), InferYear(time.Date(2038, 1, 1, 0, 0, 2, 0, time.UTC), received))
+	})
+}
@@ -0,0 +1,157 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// SetActivityBuckets makes the stores count the events of every ip address, username and
+// service in ActivityBucket nodes of the resolutions, none are kept by default
+func (c *Neo4jClient) SetActivityBuckets(resolutions ...types.ActivityResolution) {
+	c.activity = resolutions
+}
+
+// activityBuckets are the resolutions events are counted in, none for stores without a client
+func (c *Neo4jClient) activityBuckets() []types.ActivityResolution {
+	if c == nil {
+		return nil
+	}
+	return c.activity
+}
+
+// activityRow counts events on the buckets they fall in, the events of a row share the
+// buckets of every resolution
+type activityRow struct {
+	buckets   []map[string]any
+	events    int
+	attempts  int
+	successes int
+	failures  int
+}
+
+func newActivityRow(resolutions []types.ActivityResolution, at time.Time) *activityRow {
+	row := &activityRow{}
+	for _, r := range resolutions {
+		row.buckets = append(row.buckets, map[string]any{
+			"resolution": string(r),
+			"start":      r.Start(at).Format(time.RFC3339),
+		})
+	}
+	return row
+}
+
+// add counts the event, attempts only count when they are linked to a username like AUTHENTICATED_ON
+func (r *activityRow) add(event types.ParsedEvent, attempt opt.Optional[types.AuthAttempt]) {
+	r.events++
+	if event.Username.Name == "" || !attempt.IsPresent() {
+		return
+	}
+	r.attempts++
+	if attempt.Value.Success {
+		r.successes++
+	} else {
+		r.failures++
+	}
+}
+
+func (r *activityRow) params() map[string]any {
+	return map[string]any{
+		"buckets":   r.buckets,
+		"events":    r.events,
+		"attempts":  r.attempts,
+		"successes": r.successes,
+		"failures":  r.failures,
+	}
+}
+
+// activityCypher adds the counts of the activity row to the ACTIVE_IN relationships of the
+// subjects, a list of the nodes the events are of, to its buckets. It runs in a subquery.
+func activityCypher(activity, subjects string) string {
+	return fmt.Sprintf(`
+			UNWIND %[1]s.buckets AS bucket
+			MERGE (ab:ActivityBucket {resolution: bucket.resolution, start: datetime(bucket.start)})
+			WITH *
+			UNWIND %[2]s AS subject
+			MERGE (subject)-[active:ACTIVE_IN]->(ab)
+			ON CREATE SET active.events = 0, active.attempts = 0, active.successes = 0, active.failures = 0
+			SET active.events = active.events + %[1]s.events, active.attempts = active.attempts + %[1]s.attempts,
+				active.successes = active.successes + %[1]s.successes, active.failures = active.failures + %[1]s.failures`,
+		activity, subjects)
+}
+
+// timelineSubjects matches the subject of a timeline query
+var timelineSubjects = map[types.ActivitySubject]string{
+	types.IPAddressActivity: "(subject:IPAddress {address: $value})",
+	types.UsernameActivity:  "(subject:Username {name: $value})",
+	types.ServiceActivity:   "(subject:Service {name: $value})",
+}
+
+func timelineCypher(q types.TimelineQuery) (string, map[string]any, error) {
+	subject, ok := timelineSubjects[q.Subject]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown activity subject %q", q.Subject)
+	}
+	cypher := fmt.Sprintf(`
+		MATCH %s-[active:ACTIVE_IN]->(ab:ActivityBucket {resolution: $resolution})
+		WHERE ($from IS NULL OR ab.start >= datetime($from)) AND ($to IS NULL OR ab.start < datetime($to))
+		RETURN ab.start AS start, sum(active.events) AS events, sum(active.attempts) AS attempts,
+			sum(active.successes) AS successes, sum(active.failures) AS failures
+		ORDER BY start`, subject)
+	params := map[string]any{
+		"value":      q.Value,
+		"resolution": string(q.Resolution),
+		"from":       nil,
+		"to":         nil,
+	}
+	if !q.From.IsZero() {
+		params["from"] = q.From.UTC().Format(time.RFC3339)
+	}
+	if !q.To.IsZero() {
+		params["to"] = q.To.UTC().Format(time.RFC3339)
+	}
+	return cypher, params, nil
+}
+
+// Timeline returns the activity buckets of the subject in order, buckets without events are left out
+func (c *Neo4jClient) Timeline(ctx context.Context, q types.TimelineQuery) ([]types.ActivityBucket, error) {
+	cypher, params, err := timelineCypher(q)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.ExecuteRead(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, cypher, params)
+		if err != nil {
+			return nil, err
+		}
+		var buckets []types.ActivityBucket
+		for result.Next(ctx) {
+			record := result.Record()
+			bucket := types.ActivityBucket{Resolution: q.Resolution}
+			if bucket.Start, _, err = neo.GetRecordValue[time.Time](record, "start"); err != nil {
+				return nil, err
+			}
+			for key, count := range map[string]*int64{
+				"events":    &bucket.Events,
+				"attempts":  &bucket.Attempts,
+				"successes": &bucket.Successes,
+				"failures":  &bucket.Failures,
+			} {
+				if *count, _, err = neo.GetRecordValue[int64](record, key); err != nil {
+					return nil, err
+				}
+			}
+			buckets = append(buckets, bucket)
+		}
+		return buckets, result.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s %s timeline: %w", q.Subject, q.Value, err)
+	}
+	buckets, _ := res.([]types.ActivityBucket)
+	return buckets, nil
+}
@@ -0,0 +1,76 @@
+package neo4j
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthCypherActivity(t *testing.T) {
+	activity := []types.ActivityResolution{types.HourActivity, types.DayActivity}
+	cypher, params := authCypher(batchTestEvent("203.0.113.7", "root", 0, false), opt.Some(types.AuthAttempt{}), activity)
+	snaps.MatchSnapshot(t, cypher, params)
+
+	assert.Equal(t, map[string]any{
+		"buckets": []map[string]any{
+			{"resolution": "hour", "start": "2038-01-19T03:00:00Z"},
+			{"resolution": "day", "start": "2038-01-19T00:00:00Z"},
+		},
+		"events":    1,
+		"attempts":  1,
+		"successes": 0,
+		"failures":  1,
+	}, params["activity"])
+
+	// without a username the attempt isn't counted, like on AUTHENTICATED_ON
+	_, params = authCypher(batchTestEvent("203.0.113.7", "", 0, false), opt.Some(types.AuthAttempt{}), activity)
+	assert.Equal(t, 0, params["activity"].(map[string]any)["attempts"])
+}
+
+func TestBatchAuthCypherActivity(t *testing.T) {
+	sshd := neo4jSSHD{}
+	var events []batchedEvent
+	for _, event := range []types.ParsedEvent{
+		batchTestEvent("203.0.113.7", "root", 0, false),
+		batchTestEvent("203.0.113.7", "root", time.Minute, true),
+		// the next hour is counted by a row of its own
+		batchTestEvent("203.0.113.7", "root", time.Hour, false),
+	} {
+		part, ok := sshd.batchPart(event)
+		assert.True(t, ok)
+		events = append(events, batchedEvent{event: event, part: part})
+	}
+
+	cypher, params := batchAuthCypher(events, []types.ActivityResolution{types.HourActivity})
+	snaps.MatchSnapshot(t, cypher)
+
+	rows := params["rows"].([]map[string]any)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, map[string]any{
+		"buckets":   []map[string]any{{"resolution": "hour", "start": "2038-01-19T03:00:00Z"}},
+		"events":    2,
+		"attempts":  2,
+		"successes": 1,
+		"failures":  1,
+	}, rows[0]["activity"])
+	assert.Equal(t, "2038-01-19T04:00:00Z", rows[1]["activity"].(map[string]any)["buckets"].([]map[string]any)[0]["start"])
+}
+
+func TestTimelineCypher(t *testing.T) {
+	cypher, params, err := timelineCypher(types.TimelineQuery{
+		Subject:    types.ServiceActivity,
+		Value:      "sshd",
+		Resolution: types.DayActivity,
+		From:       time_help.Now().AddDate(0, 0, -7),
+	})
+	assert.NoError(t, err)
+	snaps.MatchSnapshot(t, cypher, params)
+
+	_, _, err = timelineCypher(types.TimelineQuery{Subject: "port"})
+	assert.Error(t, err)
+}
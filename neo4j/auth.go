@@ -0,0 +1,154 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// neo4jAuth stores the events of any service into the IPAddress, Username and Service graph
+type neo4jAuth struct {
+	client *Neo4jClient
+}
+
+func (n *neo4jAuth) Name() string {
+	return "auth_neo4j_store"
+}
+
+func NewNeo4jAuth(client *Neo4jClient) neo4jAuth {
+	return neo4jAuth{
+		client: client,
+	}
+}
+
+func (n *neo4jAuth) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" {
+		slog.Debug("Skipping event without an IP address", "service", event.ServiceName, "event", event)
+		return nil
+	}
+	cypher, props := authCypher(event, event.Auth, n.client.activityBuckets())
+	cypher = fmt.Sprintf("%s\nFINISH", cypher)
+
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.Error("Failed to store event in Neo4j", "service", event.ServiceName, "error", err)
+		return fmt.Errorf("failed to store %s event in Neo4j: %w", event.ServiceName, err)
+	}
+	return nil
+}
+
+func (n *neo4jAuth) batchPart(event types.ParsedEvent) (batchPart, bool) {
+	if event.IPAddress.Address == "" {
+		return batchPart{}, false
+	}
+	return batchPart{auth: true, attempt: event.Auth}, true
+}
+
+// authCypher merges the service, ip address and username of the event and, for
+// authentication attempts, counts the outcome on AUTHENTICATED_ON and in the activity
+// buckets of the resolutions. It binds s, ip and username (when the event has one)
+// for the cypher appended after it.
+func authCypher(event types.ParsedEvent, attempt opt.Optional[types.AuthAttempt], activity []types.ActivityResolution) (string, map[string]any) {
+	cypher := `
+		MERGE (s:Service {name: $serviceName, port: $port, host: $host})
+		ON CREATE SET s.seen = 0
+		SET ` + earliestCypher("s.first_seen", ingestionCypher) + `, s.seen = s.seen + 1
+		WITH *
+`
+	// tells which relay delivered the events of the service and lets them be filtered by facility
+	if event.Syslog.FacilityName != "" {
+		cypher += `		SET s.facility = $facility
+		WITH *
+`
+	}
+	if event.Syslog.Relay != "" {
+		cypher += `		SET s.last_relay = $relay,
+			s.relays = CASE WHEN $relay IN coalesce(s.relays, []) THEN s.relays ELSE coalesce(s.relays, []) + $relay END
+		WITH *
+`
+	}
+	// the enrichers merge the ip as well and may create it first, without a seen
+	cypher += `
+		MERGE (ip:IPAddress {address: $ip_address})
+		SET ` + spanCypher("ip.first_seen", "ip.last_seen", ingestionCypher) + `
+		SET ip.seen = coalesce(ip.seen, 0) + 1
+		WITH *
+
+		MERGE (ip)-[ct:CONNECTED_TO]->(s)
+		ON CREATE SET ct.times = 0
+		SET ct.times = ct.times + 1, ` + spanCypher("ct.fist_time", "ct.last_time", ingestionCypher) + `
+		WITH *
+ 
+`
+	if event.Username.Name != "" {
+		cypher += `
+		MERGE (username:Username {name: $username})
+		ON CREATE SET username.seen = 0
+		SET ` + spanCypher("username.first_seen", "username.last_seen", ingestionCypher) + `, username.seen = username.seen + 1
+		WITH *
+
+		MERGE (ip)-[w:WITH_USERNAME]->(username)
+		ON CREATE SET w.times = 0
+		SET ` + spanCypher("w.first_time", "w.last_time", ingestionCypher) + `, w.times = w.times + 1
+		WITH *
+`
+		if attempt.IsPresent() {
+			cypher += `
+		MERGE (username)-[a:AUTHENTICATED_ON]->(s)
+		ON CREATE SET a.failures = 0, a.successes = 0, a.times = 0
+		SET ` + spanCypher("a.first_time", "a.last_time", ingestionCypher) + `, a.times = a.times + 1,
+	`
+			if attempt.Value.Success {
+				cypher += `a.successes = a.successes + 1
+		`
+			} else {
+				cypher += `a.failures = a.failures + 1
+		`
+			}
+		}
+	}
+
+	subjects := "[ip, s]"
+	if event.Username.Name != "" {
+		subjects = "[ip, s, username]"
+	}
+	if len(activity) > 0 {
+		cypher += fmt.Sprintf(`
+		WITH *
+		CALL {
+			WITH %s%s
+		}
+		WITH *
+`, strings.Trim(subjects, "[]"), activityCypher("$activity", subjects))
+	}
+
+	params := map[string]any{
+		"serviceName": event.Service.Name,
+		"port":        event.Service.Port,
+		"host":        event.Service.Host,
+		"ip_address":  event.IPAddress.Address,
+		"ingestion":   event.Ingestion.Format(time.RFC3339),
+		"username":    event.Username.Name,
+	}
+	if event.Syslog.FacilityName != "" {
+		params["facility"] = event.Syslog.FacilityName
+	}
+	if event.Syslog.Relay != "" {
+		params["relay"] = event.Syslog.Relay
+	}
+	if len(activity) > 0 {
+		row := newActivityRow(activity, event.Ingestion)
+		row.add(event, attempt)
+		params["activity"] = row.params()
+	}
+
+	return cypher, params
+}
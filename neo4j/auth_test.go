@@ -0,0 +1,26 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestAuthCypherSyslogMetadata(t *testing.T) {
+	cypher, props := authCypher(types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: types.DovecotService,
+		IPAddress:   types.IPAddress{Address: "203.0.113.5"},
+		Username:    types.Username{Name: "admin"},
+		Service:     types.Service{Name: "imap", Port: 993, Host: "mail"},
+		Syslog: types.SyslogMetadata{
+			Facility:     2,
+			FacilityName: "mail",
+			Relay:        "192.0.2.10",
+		},
+	}, opt.Some(types.AuthAttempt{Method: "PLAIN"}), nil)
+	snaps.MatchSnapshot(t, cypher, props)
+}
@@ -0,0 +1,515 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type BatchConfig struct {
+	Size int // most events written in one transaction, defaults to 500
+	// FlushInterval is how long a batch gathers events after its first one, unless it's
+	// full before, defaults to 20ms. The stores wait for it on top of the write.
+	FlushInterval time.Duration
+	MinBackoff    time.Duration
+	MaxBackoff    time.Duration
+}
+
+// errBatchStopped is returned by the stores of a batch that stopped writing
+var errBatchStopped = errors.New("neo4j batch writer stopped")
+
+// Neo4jBatch writes the events of the stores it wraps in batches. The auth graph of the
+// whole batch is merged with a single UNWIND query, what each store adds on top of it
+// runs after it, event by event, in the same transaction. A batch is written once it's
+// full or FlushInterval after its first event, the stores wait for the batch of their event
+// to be written.
+type Neo4jBatch struct {
+	client  *Neo4jClient
+	config  BatchConfig
+	events  chan batchedEvent
+	stopped chan struct{} // closed once Run returned
+
+	mu  sync.Mutex
+	err error // set while a batch is retried, new events are refused meanwhile
+
+	exec  func(ctx context.Context, events []batchedEvent) error
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// batchable stores split their events into the auth graph and the remainder of the event
+type batchable interface {
+	Name() string
+	// batchPart returns false for events the store doesn't keep
+	batchPart(event types.ParsedEvent) (batchPart, bool)
+}
+
+type batchPart struct {
+	auth    bool // whether the event is merged into the auth graph
+	attempt opt.Optional[types.AuthAttempt]
+	// cypher runs after the auth graph was merged, empty when there's nothing left
+	cypher string
+	params map[string]any
+}
+
+type batchedEvent struct {
+	ctx   context.Context // of the Store call, the event is left out once it's done
+	event types.ParsedEvent
+	part  batchPart
+	done  chan error // receives the outcome of the write of the event
+}
+
+func NewNeo4jBatch(client *Neo4jClient, config BatchConfig) *Neo4jBatch {
+	if config.Size <= 0 {
+		config.Size = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 20 * time.Millisecond
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+	b := &Neo4jBatch{
+		client:  client,
+		config:  config,
+		events:  make(chan batchedEvent),
+		stopped: make(chan struct{}),
+		sleep:   sleepContext,
+	}
+	b.exec = b.write
+	return b
+}
+
+// Wrap returns a store that hands the events of store to the batch
+func (b *Neo4jBatch) Wrap(store batchable) *batchedStore {
+	return &batchedStore{batch: b, store: store}
+}
+
+// Run writes the batches until ctx is done, the batch being written then gets one last attempt
+func (b *Neo4jBatch) Run(ctx context.Context) error {
+	defer close(b.stopped)
+
+	pending := make([]batchedEvent, 0, b.config.Size)
+	for {
+		pending = pending[:0]
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-b.events:
+			pending = append(pending, e)
+		}
+		timer := time.NewTimer(b.config.FlushInterval)
+	gather:
+		for len(pending) < b.config.Size {
+			select {
+			case e := <-b.events:
+				pending = append(pending, e)
+			case <-timer.C:
+				break gather
+			case <-ctx.Done():
+				break gather
+			}
+		}
+		timer.Stop()
+		if err := b.flush(ctx, pending); err != nil {
+			b.writeLast(ctx, pending)
+			return err
+		}
+	}
+}
+
+// writeLast makes one last attempt at the batch as the server shuts down
+func (b *Neo4jBatch) writeLast(ctx context.Context, events []batchedEvent) {
+	events = waiting(events)
+	if len(events) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	err := b.exec(ctx, events)
+	if err != nil {
+		slog.Error("Failed to write the last batch to Neo4j", "events", len(events), "error", err)
+	}
+	answer(events, err)
+}
+
+// flush writes the batch and hands the outcome to its stores, retrying with backoff while
+// Neo4j is unavailable. The events whose stores gave up meanwhile are left out, their
+// callers keep them. A batch failing for any other reason is written event by event so
+// one bad event doesn't take the others with it. It only fails once ctx is done.
+func (b *Neo4jBatch) flush(ctx context.Context, events []batchedEvent) error {
+	defer b.setErr(nil)
+	backoff := b.config.MinBackoff
+	for {
+		events = waiting(events)
+		if len(events) == 0 {
+			return nil
+		}
+		err := b.exec(ctx, events)
+		if err == nil {
+			answer(events, nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !IsTransient(err) {
+			slog.Warn("Failed to write batch to Neo4j, writing its events one by one", "events", len(events), "error", err)
+			for _, e := range events {
+				e.done <- b.exec(ctx, []batchedEvent{e})
+			}
+			return nil
+		}
+
+		b.setErr(err)
+		slog.Warn("Failed to write batch to Neo4j, retrying", "events", len(events), "backoff", backoff, "error", err)
+		if err := b.sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, b.config.MaxBackoff)
+	}
+}
+
+// waiting are the events whose stores still wait for them to be written
+func waiting(events []batchedEvent) []batchedEvent {
+	return slices.DeleteFunc(slices.Clone(events), func(e batchedEvent) bool { return e.ctx.Err() != nil })
+}
+
+func answer(events []batchedEvent, err error) {
+	for _, e := range events {
+		e.done <- err
+	}
+}
+
+// write runs the batch in a single transaction
+func (b *Neo4jBatch) write(ctx context.Context, events []batchedEvent) error {
+	cypher, params := batchAuthCypher(events, b.client.activityBuckets())
+	_, err := b.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		if cypher != "" {
+			res, err := tx.Run(ctx, cypher, params)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := res.Consume(ctx); err != nil {
+				return nil, err
+			}
+		}
+		for _, e := range events {
+			if e.part.cypher == "" {
+				continue
+			}
+			res, err := tx.Run(ctx, e.part.cypher+"\nFINISH", e.part.params)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := res.Consume(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (b *Neo4jBatch) setErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+func (b *Neo4jBatch) failing() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// batchedStore is the ContentStore of a store whose events are written by a Neo4jBatch
+type batchedStore struct {
+	batch *Neo4jBatch
+	store batchable
+}
+
+func (s *batchedStore) Name() string {
+	return s.store.Name()
+}
+
+// Store hands the event to the batch and returns once its batch was written, it fails
+// while batches can't be written so the caller keeps the event. An event whose ctx is
+// done while its batch is being written may still be written.
+func (s *batchedStore) Store(ctx context.Context, event types.ParsedEvent) error {
+	if err := s.batch.failing(); err != nil {
+		return fmt.Errorf("neo4j batch writes are failing: %w", err)
+	}
+	part, ok := s.store.batchPart(event)
+	if !ok {
+		slog.Debug("Skipping event the store doesn't keep", "store", s.store.Name(), "event", event)
+		return nil
+	}
+	done := make(chan error, 1)
+	select {
+	case s.batch.events <- batchedEvent{ctx: ctx, event: event, part: part, done: done}:
+	case <-s.batch.stopped:
+		return fmt.Errorf("failed to store %s event: %w", event.ServiceName, errBatchStopped)
+	case <-ctx.Done():
+		return fmt.Errorf("failed to hand %s event to the batch: %w", event.ServiceName, ctx.Err())
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to store %s event in Neo4j: %w", event.ServiceName, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to store %s event: %w", event.ServiceName, ctx.Err())
+	}
+}
+
+// authRow sums the events of a batch with the same service, ip address and username,
+// so the counters of the UNWIND query add up when an ip repeats within the batch
+type authRow struct {
+	serviceName string
+	port        int
+	host        string
+	ipAddress   string
+	username    string
+	facility    string
+	relays      []string
+
+	events       int
+	attempts     int
+	successes    int
+	failures     int
+	firstTime    time.Time
+	lastTime     time.Time
+	firstAttempt time.Time
+	lastAttempt  time.Time
+
+	activity *activityRow // nil when no activity buckets are kept
+}
+
+func (r *authRow) add(event types.ParsedEvent, attempt opt.Optional[types.AuthAttempt]) {
+	if r.events == 0 || event.Ingestion.Before(r.firstTime) {
+		r.firstTime = event.Ingestion
+	}
+	if r.events == 0 || event.Ingestion.After(r.lastTime) {
+		r.lastTime = event.Ingestion
+	}
+	r.events++
+	if r.activity != nil {
+		r.activity.add(event, attempt)
+	}
+	if event.Syslog.FacilityName != "" {
+		r.facility = event.Syslog.FacilityName
+	}
+	if relay := event.Syslog.Relay; relay != "" {
+		r.relays = append(r.relays, relay)
+	}
+
+	// AUTHENTICATED_ON links the username to the service
+	if r.username == "" || !attempt.IsPresent() {
+		return
+	}
+	if r.attempts == 0 || event.Ingestion.Before(r.firstAttempt) {
+		r.firstAttempt = event.Ingestion
+	}
+	if r.attempts == 0 || event.Ingestion.After(r.lastAttempt) {
+		r.lastAttempt = event.Ingestion
+	}
+	r.attempts++
+	if attempt.Value.Success {
+		r.successes++
+	} else {
+		r.failures++
+	}
+}
+
+func (r *authRow) params() map[string]any {
+	row := map[string]any{
+		"service_name": r.serviceName,
+		"port":         r.port,
+		"host":         r.host,
+		"ip_address":   r.ipAddress,
+		"username":     nil,
+		"facility":     nil,
+		"last_relay":   nil,
+		"relays":       []string{},
+		"events":       r.events,
+		"attempts":     r.attempts,
+		"successes":    r.successes,
+		"failures":     r.failures,
+		"first_time":   r.firstTime.Format(time.RFC3339),
+		"last_time":    r.lastTime.Format(time.RFC3339),
+	}
+	if r.username != "" {
+		row["username"] = r.username
+	}
+	if r.facility != "" {
+		row["facility"] = r.facility
+	}
+	if len(r.relays) > 0 {
+		row["last_relay"] = r.relays[len(r.relays)-1]
+		var relays []string
+		for _, relay := range r.relays {
+			if !slices.Contains(relays, relay) {
+				relays = append(relays, relay)
+			}
+		}
+		row["relays"] = relays
+	}
+	if r.attempts > 0 {
+		row["first_attempt"] = r.firstAttempt.Format(time.RFC3339)
+		row["last_attempt"] = r.lastAttempt.Format(time.RFC3339)
+	}
+	if r.activity != nil {
+		row["activity"] = r.activity.params()
+	}
+	return row
+}
+
+// batchAuthCypher merges the auth graph of the batch like authCypher does for one event,
+// events of different activity buckets are merged by different rows
+func batchAuthCypher(events []batchedEvent, activity []types.ActivityResolution) (string, map[string]any) {
+	var rows []*authRow
+	byKey := map[string]*authRow{}
+	for _, e := range events {
+		if !e.part.auth {
+			continue
+		}
+		event := e.event
+		key := fmt.Sprintf("%s|%d|%s|%s|%s", event.Service.Name, event.Service.Port, event.Service.Host, event.IPAddress.Address, event.Username.Name)
+		for _, r := range activity {
+			key += "|" + r.Start(event.Ingestion).Format(time.RFC3339)
+		}
+		row, ok := byKey[key]
+		if !ok {
+			row = &authRow{
+				serviceName: event.Service.Name,
+				port:        event.Service.Port,
+				host:        event.Service.Host,
+				ipAddress:   event.IPAddress.Address,
+				username:    event.Username.Name,
+			}
+			if len(activity) > 0 {
+				row.activity = newActivityRow(activity, event.Ingestion)
+			}
+			byKey[key] = row
+			rows = append(rows, row)
+		}
+		row.add(event, e.part.attempt)
+	}
+	if len(rows) == 0 {
+		return "", nil
+	}
+
+	params := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		params = append(params, row.params())
+	}
+
+	// an ip repeats in the rows of its usernames and activity buckets, the times are
+	// widened rather than set so a later row doesn't move them back
+	firstTime, lastTime := "datetime(row.first_time)", "datetime(row.last_time)"
+	cypher := `
+		UNWIND $rows AS row
+		MERGE (s:Service {name: row.service_name, port: row.port, host: row.host})
+		ON CREATE SET s.seen = 0
+		SET ` + earliestCypher("s.first_seen", firstTime) + `, s.seen = s.seen + row.events,
+			s.facility = coalesce(row.facility, s.facility),
+			s.last_relay = coalesce(row.last_relay, s.last_relay),
+			s.relays = CASE WHEN size(row.relays) = 0 THEN s.relays
+				ELSE coalesce(s.relays, []) + [relay IN row.relays WHERE NOT relay IN coalesce(s.relays, [])] END
+		WITH *
+
+		MERGE (ip:IPAddress {address: row.ip_address})
+		SET ` + earliestCypher("ip.first_seen", firstTime) + `,
+			` + latestCypher("ip.last_seen", lastTime) + `
+		SET ip.seen = coalesce(ip.seen, 0) + row.events
+		WITH *
+
+		MERGE (ip)-[ct:CONNECTED_TO]->(s)
+		ON CREATE SET ct.times = 0
+		SET ct.times = ct.times + row.events,
+			` + earliestCypher("ct.fist_time", firstTime) + `,
+			` + latestCypher("ct.last_time", lastTime) + `
+		WITH *
+
+		CALL {
+			WITH row, ip, s
+			WITH * WHERE row.username IS NOT NULL
+			MERGE (username:Username {name: row.username})
+			ON CREATE SET username.seen = 0
+			SET ` + earliestCypher("username.first_seen", firstTime) + `,
+				` + latestCypher("username.last_seen", lastTime) + `,
+				username.seen = username.seen + row.events
+			WITH *
+
+			MERGE (ip)-[w:WITH_USERNAME]->(username)
+			ON CREATE SET w.times = 0
+			SET ` + earliestCypher("w.first_time", firstTime) + `,
+				` + latestCypher("w.last_time", lastTime) + `,
+				w.times = w.times + row.events
+			WITH *
+
+			WITH * WHERE row.attempts > 0
+			MERGE (username)-[a:AUTHENTICATED_ON]->(s)
+			ON CREATE SET a.failures = 0, a.successes = 0, a.times = 0
+			SET ` + earliestCypher("a.first_time", "datetime(row.first_attempt)") + `,
+				` + latestCypher("a.last_time", "datetime(row.last_attempt)") + `,
+				a.times = a.times + row.attempts,
+				a.successes = a.successes + row.successes, a.failures = a.failures + row.failures
+		}
+`
+	if len(activity) > 0 {
+		cypher += `
+		CALL {
+			WITH row, ip, s
+			OPTIONAL MATCH (username:Username {name: row.username})
+			WITH *` + activityCypher("row.activity", "[ip, s] + [u IN [username] WHERE u IS NOT NULL]") + `
+		}
+`
+	}
+	cypher += `		FINISH`
+	return cypher, map[string]any{"rows": params}
+}
+
+// bindAuthCypher matches the nodes authCypher binds, for the remainder of an event whose
+// auth graph was merged by batchAuthCypher
+func bindAuthCypher(event types.ParsedEvent) (string, map[string]any) {
+	cypher := `
+		MATCH (s:Service {name: $serviceName, port: $port, host: $host})
+		MATCH (ip:IPAddress {address: $ip_address})
+`
+	if event.Username.Name != "" {
+		cypher += `		MATCH (username:Username {name: $username})
+`
+	}
+	cypher += `		WITH *
+`
+	return cypher, map[string]any{
+		"serviceName": event.Service.Name,
+		"port":        event.Service.Port,
+		"host":        event.Service.Host,
+		"ip_address":  event.IPAddress.Address,
+		"ingestion":   event.Ingestion.Format(time.RFC3339),
+		"username":    event.Username.Name,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
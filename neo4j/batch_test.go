@@ -0,0 +1,201 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchTestEvent(ip, username string, at time.Duration, success bool) types.ParsedEvent {
+	return types.ParsedEvent{
+		Ingestion:   time_help.Now().Add(at),
+		ServiceName: types.SSHDService,
+		Hostname:    "bastion",
+		PID:         5774,
+		IPAddress:   types.IPAddress{Address: ip},
+		Username:    types.Username{Name: username},
+		Service:     types.Service{Name: "sshd", Port: 22, Host: "bastion"},
+		Syslog:      types.SyslogMetadata{FacilityName: "auth", Relay: "192.0.2.10"},
+		SSHDEvent: opt.Some(types.SSHDParsedEvent{
+			Kind:    types.SSHDAuthFailed,
+			Success: success,
+		}),
+	}
+}
+
+func TestBatchAuthCypher(t *testing.T) {
+	sshd := neo4jSSHD{}
+	auth := neo4jAuth{}
+	var events []batchedEvent
+	add := func(store batchable, event types.ParsedEvent) {
+		part, ok := store.batchPart(event)
+		assert.True(t, ok)
+		events = append(events, batchedEvent{event: event, part: part})
+	}
+	// the same ip and username repeat within the batch, later events may be logged earlier
+	add(&sshd, batchTestEvent("203.0.113.7", "root", 0, false))
+	add(&sshd, batchTestEvent("203.0.113.7", "root", 2*time.Second, false))
+	add(&sshd, batchTestEvent("203.0.113.7", "root", time.Second, true))
+	add(&sshd, batchTestEvent("203.0.113.7", "admin", 3*time.Second, false))
+	add(&auth, types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: types.PostfixService,
+		IPAddress:   types.IPAddress{Address: "198.51.100.4"},
+		Service:     types.Service{Name: "smtp", Port: 25, Host: "mx"},
+	})
+
+	cypher, params := batchAuthCypher(events, nil)
+	snaps.MatchSnapshot(t, cypher, params)
+
+	rows := params["rows"].([]map[string]any)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, 3, rows[0]["events"])
+	assert.Equal(t, 3, rows[0]["attempts"])
+	assert.Equal(t, 1, rows[0]["successes"])
+	assert.Equal(t, 2, rows[0]["failures"])
+	assert.Equal(t, time_help.Now().Format(time.RFC3339), rows[0]["first_time"])
+	assert.Equal(t, time_help.Now().Add(2*time.Second).Format(time.RFC3339), rows[0]["last_time"])
+	assert.Equal(t, []string{"192.0.2.10"}, rows[0]["relays"])
+	assert.Nil(t, rows[2]["username"])
+	assert.Equal(t, 0, rows[2]["attempts"])
+}
+
+func TestBatchPart(t *testing.T) {
+	sshd := neo4jSSHD{}
+	t.Run("session remainder", func(t *testing.T) {
+		event := batchTestEvent("203.0.113.7", "root", 0, true)
+		event.SSHDEvent.Value.SessionID = "bastion-5774-1"
+		part, ok := sshd.batchPart(event)
+		assert.True(t, ok)
+		assert.True(t, part.auth)
+		snaps.MatchSnapshot(t, part.cypher, part.params)
+	})
+
+	t.Run("session without ip", func(t *testing.T) {
+		event := batchTestEvent("", "root", 0, true)
+		event.SSHDEvent = opt.Some(types.SSHDParsedEvent{Kind: types.SSHDSessionClosed, SessionID: "bastion-5774-1"})
+		part, ok := sshd.batchPart(event)
+		assert.True(t, ok)
+		assert.False(t, part.auth)
+		assert.NotEmpty(t, part.cypher)
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		event := batchTestEvent("", "", 0, false)
+		event.SSHDEvent = opt.Some(types.SSHDParsedEvent{})
+		_, ok := sshd.batchPart(event)
+		assert.False(t, ok)
+		_, ok = (&neo4jAuth{}).batchPart(event)
+		assert.False(t, ok)
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	deadlock := &neo.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"}
+	assert.True(t, IsTransient(fmt.Errorf("failed to store: %w", deadlock)))
+	assert.True(t, IsTransient(&neo.ConnectivityError{Inner: errors.New("connection refused")}))
+	assert.True(t, IsTransient(&neo.TransactionExecutionLimit{Cause: "timeout", Errors: []error{deadlock}}))
+	assert.True(t, IsTransient(fmt.Errorf("failed to store: %w", context.DeadlineExceeded)))
+
+	assert.False(t, IsTransient(&neo.Neo4jError{Code: "Neo.ClientError.Schema.ConstraintValidationFailed"}))
+	assert.False(t, IsTransient(&neo.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError"}))
+	assert.False(t, IsTransient(errBatchStopped))
+}
+
+func TestBatchedStore(t *testing.T) {
+	// runBatch writes the batches with exec until the test ends
+	runBatch := func(t *testing.T, config BatchConfig, exec func(events []batchedEvent) error) (*Neo4jBatch, *batchedStore) {
+		batch := NewNeo4jBatch(nil, config)
+		batch.exec = func(_ context.Context, events []batchedEvent) error { return exec(events) }
+		ctx, cancel := context.WithCancel(t.Context())
+		t.Cleanup(func() {
+			cancel()
+			<-batch.stopped
+		})
+		go batch.Run(ctx)
+		return batch, batch.Wrap(&neo4jAuth{})
+	}
+	event := batchTestEvent("203.0.113.7", "root", 0, false)
+
+	t.Run("stores return once their batch is written", func(t *testing.T) {
+		var written []string
+		_, store := runBatch(t, BatchConfig{Size: 2}, func(events []batchedEvent) error {
+			for _, e := range events {
+				written = append(written, e.event.IPAddress.Address)
+			}
+			return nil
+		})
+		assert.Equal(t, "auth_neo4j_store", store.Name())
+		assert.NoError(t, store.Store(t.Context(), event))
+		assert.Equal(t, []string{"203.0.113.7"}, written)
+	})
+
+	t.Run("batches gather events until they're full", func(t *testing.T) {
+		var written []int
+		_, store := runBatch(t, BatchConfig{Size: 2, FlushInterval: time.Hour}, func(events []batchedEvent) error {
+			written = append(written, len(events))
+			return nil
+		})
+		errs := make(chan error, 2)
+		for range 2 {
+			go func() { errs <- store.Store(t.Context(), event) }()
+		}
+		assert.NoError(t, <-errs)
+		assert.NoError(t, <-errs)
+		assert.Equal(t, []int{2}, written)
+	})
+
+	t.Run("failed writes fail the store", func(t *testing.T) {
+		_, store := runBatch(t, BatchConfig{Size: 2}, func([]batchedEvent) error { return errors.New("constraint violated") })
+		assert.ErrorContains(t, store.Store(t.Context(), event), "constraint violated")
+	})
+
+	t.Run("refused while a batch is retried", func(t *testing.T) {
+		batch, store := runBatch(t, BatchConfig{Size: 2}, func([]batchedEvent) error { return nil })
+		batch.setErr(errors.New("connection refused"))
+		assert.ErrorContains(t, store.Store(t.Context(), event), "connection refused")
+	})
+
+	t.Run("refused once the batch stopped", func(t *testing.T) {
+		batch := NewNeo4jBatch(nil, BatchConfig{})
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		assert.ErrorIs(t, batch.Run(ctx), context.Canceled)
+		assert.ErrorIs(t, batch.Wrap(&neo4jAuth{}).Store(t.Context(), event), errBatchStopped)
+	})
+
+	t.Run("events whose stores gave up aren't retried", func(t *testing.T) {
+		batch := NewNeo4jBatch(nil, BatchConfig{})
+		gone, giveUp := context.WithCancel(t.Context())
+		var written []int
+		batch.exec = func(_ context.Context, events []batchedEvent) error {
+			written = append(written, len(events))
+			if len(written) == 1 {
+				return context.DeadlineExceeded
+			}
+			return nil
+		}
+		batch.sleep = func(context.Context, time.Duration) error {
+			assert.Error(t, batch.failing())
+			giveUp()
+			return nil
+		}
+
+		kept := batchedEvent{ctx: t.Context(), event: event, done: make(chan error, 1)}
+		left := batchedEvent{ctx: gone, event: event, done: make(chan error, 1)}
+		assert.NoError(t, batch.flush(t.Context(), []batchedEvent{kept, left}))
+		assert.Equal(t, []int{2, 1}, written)
+		assert.NoError(t, <-kept.done)
+		assert.Empty(t, left.done)
+		assert.NoError(t, batch.failing())
+	})
+}
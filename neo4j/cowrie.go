@@ -0,0 +1,136 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type neo4jCowrie struct {
+	client *Neo4jClient
+}
+
+func (n *neo4jCowrie) Name() string {
+	return "cowrie_neo4j_store"
+}
+
+func NewNeo4jCowrie(client *Neo4jClient) neo4jCowrie {
+	return neo4jCowrie{
+		client: client,
+	}
+}
+
+func (n *neo4jCowrie) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" || !event.CowrieEvent.IsPresent() {
+		slog.Debug("Skipping cowrie event without an IP address", "event", event)
+		return nil
+	}
+	cypher, props := n.storeCypher(event)
+
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.Error("Failed to store cowrie event in Neo4j", "error", err)
+		return fmt.Errorf("failed to store cowrie event in Neo4j: %w", err)
+	}
+	return nil
+}
+
+// storeCypher keeps connections and logins in the same graph as sshd, the commands
+// and downloads of a session only carry the ip address
+func (n *neo4jCowrie) storeCypher(event types.ParsedEvent) (string, map[string]any) {
+	cowrieEvent := event.CowrieEvent.OrElse(types.CowrieParsedEvent{})
+
+	var cypher string
+	var params map[string]any
+	if cowrieEvent.Command == "" && !cowrieEvent.Download.IsPresent() {
+		cypher, params = authCypher(event, event.Auth, n.client.activityBuckets())
+	} else {
+		cypher = `
+		MERGE (ip:IPAddress {address: $ip_address})
+		SET ` + spanCypher("ip.first_seen", "ip.last_seen", ingestionCypher) + `,
+			ip.seen = coalesce(ip.seen, 0)
+		WITH *
+`
+		params = map[string]any{
+			"ip_address": event.IPAddress.Address,
+			"ingestion":  event.Ingestion.Format(time.RFC3339),
+		}
+	}
+	maps.Copy(params, map[string]any{
+		"session": cowrieEvent.Session,
+		"sensor":  event.Service.Host,
+	})
+
+	if cowrieEvent.Password != "" {
+		cypher += `
+		MERGE (password:Password {value: $password})
+		ON CREATE SET password.seen = 0
+		SET ` + spanCypher("password.first_seen", "password.last_seen", ingestionCypher) + `, password.seen = password.seen + 1
+		WITH *
+
+		MERGE (ip)-[ipp:USED_PASSWORD]->(password)
+		ON CREATE SET ipp.times = 0
+		SET ` + spanCypher("ipp.first_time", "ipp.last_time", ingestionCypher) + `, ipp.times = ipp.times + 1
+		WITH *
+`
+		if event.Username.Name != "" {
+			cypher += `
+		MERGE (username)-[up:TRIED_PASSWORD]->(password)
+		ON CREATE SET up.times = 0, up.successes = 0
+		SET ` + spanCypher("up.first_time", "up.last_time", ingestionCypher) + `, up.times = up.times + 1
+`
+			if event.Auth.OrElse(types.AuthAttempt{}).Success {
+				cypher += `		SET up.successes = up.successes + 1
+`
+			}
+			cypher += `		WITH *
+`
+		}
+		params["password"] = cowrieEvent.Password
+	}
+
+	if cowrieEvent.Command != "" {
+		cypher += `
+		MERGE (command:Command {command: $command})
+		ON CREATE SET command.seen = 0
+		SET ` + spanCypher("command.first_seen", "command.last_seen", ingestionCypher) + `, command.seen = command.seen + 1
+		WITH *
+
+		MERGE (ip)-[r:RAN_COMMAND {host: $sensor}]->(command)
+		ON CREATE SET r.times = 0, r.honeypot = true
+		SET ` + spanCypher("r.first_time", "r.last_time", ingestionCypher) + `,
+			r.times = r.times + 1, r.last_session = $session, r.emulated = $emulated
+		WITH *
+`
+		params["command"] = cowrieEvent.Command
+		params["emulated"] = !cowrieEvent.Failed
+	}
+
+	if download, ok := cowrieEvent.Download.Value, cowrieEvent.Download.IsPresent(); ok {
+		cypher += `
+		MERGE (download:Download {sha256: $sha256})
+		ON CREATE SET download.seen = 0, download.urls = []
+		SET ` + spanCypher("download.first_seen", "download.last_seen", ingestionCypher) + `, download.seen = download.seen + 1,
+			download.urls = CASE WHEN $url = '' OR $url IN download.urls THEN download.urls ELSE download.urls + $url END
+		WITH *
+
+		MERGE (ip)-[d:DOWNLOADED {host: $sensor}]->(download)
+		ON CREATE SET d.times = 0
+		SET ` + spanCypher("d.first_time", "d.last_time", ingestionCypher) + `,
+			d.times = d.times + 1, d.last_session = $session, d.upload = $upload
+		WITH *
+`
+		params["sha256"] = download.SHA256
+		params["url"] = download.URL
+		params["upload"] = download.Upload
+	}
+
+	return fmt.Sprintf("%s\nFINISH", cypher), params
+}
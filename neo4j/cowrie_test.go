@@ -0,0 +1,62 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestCowrieStoreCypher(t *testing.T) {
+	h := neo4jCowrie{}
+	service := types.Service{Name: "cowrie", Port: 2222, Host: "honeypot-1"}
+	t.Run("login", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.CowrieService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Username:    types.Username{Name: "root"},
+			Service:     service,
+			Auth:        opt.Some(types.AuthAttempt{Success: true, Method: "password"}),
+			CowrieEvent: opt.Some(types.CowrieParsedEvent{
+				EventID:  "cowrie.login.success",
+				Session:  "a1b2c3d4e5f6",
+				Password: "admin",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+	t.Run("command", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.CowrieService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Service:     service,
+			CowrieEvent: opt.Some(types.CowrieParsedEvent{
+				EventID: "cowrie.command.input",
+				Session: "a1b2c3d4e5f6",
+				Command: "uname -a",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+	t.Run("download", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.CowrieService,
+			IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+			Service:     service,
+			CowrieEvent: opt.Some(types.CowrieParsedEvent{
+				EventID: "cowrie.session.file_download",
+				Session: "a1b2c3d4e5f6",
+				Download: opt.Some(types.Download{
+					SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+					URL:    "http://198.51.100.66/bins/x86",
+				}),
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"time"
 
@@ -55,29 +57,34 @@ SET enriched.last_enrichment = datetime($now)
 
 	lowerContryCode := strings.ToLower(enrichment.CountryCode)
 	cypher += fmt.Sprintf(`
-MERGE (c:Country {country_code: $loc_country_code, country_name: $loc_country_name})
+MERGE (c:Country {country_code: $loc_country_code})
+	SET c.country_name = $loc_country_name
 WITH ip_1, c
 MERGE (ip_1)-[:LOCATED_IN]->(c)
-WITH ip_1
-	`)
+WITH ip_1, c
+%sWITH ip_1
+	`, detachOthersCypher("ip_1", "LOCATED_IN", "Country", "c"))
 	props["loc_country_code"] = lowerContryCode
 	props["loc_country_name"] = enrichment.CountryName
 
-	reportsCount := make(map[types.Country]int)
+	// countries are merged by code, reports may name the same one differently
+	reportsCount := make(map[string]int)
+	countryNames := make(map[string]string)
 	for _, report := range enrichment.Reports {
-		c := types.Country{
-			Code: strings.ToLower(report.ReporterCountryCode),
-			Name: report.ReporterCountryName,
+		code := strings.ToLower(report.ReporterCountryCode)
+		reportsCount[code]++
+		if countryNames[code] == "" {
+			countryNames[code] = report.ReporterCountryName
 		}
-		if _, exists := reportsCount[c]; !exists {
-			reportsCount[c] = 0
-		}
-		reportsCount[c]++
 	}
 
-	for c, count := range reportsCount {
+	// in order, so the same reports always make the same cypher
+	for _, code := range slices.Sorted(maps.Keys(reportsCount)) {
+		c := types.Country{Code: code, Name: countryNames[code]}
+		count := reportsCount[code]
 		cypher += fmt.Sprintf(`
-MERGE (c_%s:Country {country_code: $%s_r_country_code, country_name: $%s_r_country_name})
+MERGE (c_%s:Country {country_code: $%s_r_country_code})
+	SET c_%s.country_name = $%s_r_country_name
 WITH ip_1, c_%s
 MERGE (ip_1)-[:REPORTED_IN]->(c_%s)
 	SET c_%s.times = %d 
@@ -89,6 +96,7 @@ WITH ip_1
 			c.Code,
 			c.Code,
 			c.Code,
+			c.Code,
 			count,
 		)
 		props[fmt.Sprintf("%s_r_country_code", c.Code)] = c.Code
@@ -115,7 +123,8 @@ LIMIT 1
 	slog.InfoContext(ctx, "Query executed", "cypher", cypher, "props", props, "result", res)
 
 	if len(res.Records) == 0 {
-		return time.Time{}, fmt.Errorf("no enrichment record found for IP: %s", target.Address)
+		// never enriched
+		return time.Time{}, nil
 	}
 	ts, _, err := n.GetRecordValue[time.Time](res.Records[0], "last_enrichment")
 	if err != nil {
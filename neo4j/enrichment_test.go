@@ -1,11 +1,13 @@
 package neo4j
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/EduardoOliveira/ckc/internal/time_help"
 	"github.com/EduardoOliveira/ckc/types"
 	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNeo4jEnrichment(t *testing.T) {
@@ -73,4 +75,27 @@ func TestNeo4jEnrichment(t *testing.T) {
 		)
 		snaps.MatchSnapshot(t, cypher, params)
 	})
+	t.Run("countries named differently", func(t *testing.T) {
+		t.Parallel()
+		c := &Neo4jClient{}
+		c.now = time_help.Now
+		cypher, params := c.saveAIPDBCypher(types.IPAddress{Address: "127.0.0.1"},
+			types.AIPDBData{
+				CountryName: "Portugal",
+				CountryCode: "PT",
+				Reports: []types.AIDBReport{
+					{
+						ReporterCountryName: "France",
+						ReporterCountryCode: "FR",
+					},
+					{
+						ReporterCountryName: "French Republic",
+						ReporterCountryCode: "fr",
+					},
+				},
+			},
+		)
+		assert.Equal(t, 1, strings.Count(cypher, "MERGE (c_fr:Country"))
+		snaps.MatchSnapshot(t, cypher, params)
+	})
 }
@@ -0,0 +1,106 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type neo4jEscalation struct {
+	client *Neo4jClient
+}
+
+func (n *neo4jEscalation) Name() string {
+	return "escalation_neo4j_store"
+}
+
+func NewNeo4jEscalation(client *Neo4jClient) neo4jEscalation {
+	return neo4jEscalation{
+		client: client,
+	}
+}
+
+func (n *neo4jEscalation) Store(ctx context.Context, event types.ParsedEvent) error {
+	escalation := event.EscalationEvent.OrElse(types.EscalationParsedEvent{})
+	if event.Username.Name == "" || escalation.ToUser == "" {
+		slog.Debug("Skipping escalation event without users", "event", event)
+		return nil
+	}
+	cypher, props := n.storeCypher(event)
+
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.Error("Failed to store escalation event in Neo4j", "error", err)
+		return fmt.Errorf("failed to store escalation event in Neo4j: %w", err)
+	}
+	return nil
+}
+
+// storeCypher hangs the escalation off the same Username nodes the sshd store creates,
+// so an AUTHENTICATED_ON can be followed to ESCALATED_TO and RAN_COMMAND
+func (n *neo4jEscalation) storeCypher(event types.ParsedEvent) (string, map[string]any) {
+	escalation := event.EscalationEvent.OrElse(types.EscalationParsedEvent{})
+	cypher := `
+		MERGE (username:Username {name: $username})
+		ON CREATE SET username.seen = 0
+		SET ` + spanCypher("username.first_seen", "username.last_seen", ingestionCypher) + `, username.seen = username.seen + 1
+		WITH *
+
+		MERGE (target:Username {name: $to_user})
+		ON CREATE SET target.seen = 0
+		SET ` + earliestCypher("target.first_seen", ingestionCypher) + `
+		WITH *
+
+		MERGE (username)-[e:ESCALATED_TO {host: $host, tool: $tool}]->(target)
+		ON CREATE SET e.times = 0, e.successes = 0, e.failures = 0, e.auth_failures = 0
+		SET ` + spanCypher("e.first_time", "e.last_time", ingestionCypher) + `, e.times = e.times + 1,
+`
+	switch escalation.Kind {
+	case types.EscalationCommand, types.EscalationSession:
+		cypher += `			e.successes = e.successes + 1, ` + latestCypher("e.last_success", ingestionCypher) + `
+		WITH *
+`
+	case types.EscalationAuthFailure:
+		cypher += `			e.auth_failures = e.auth_failures + 1
+		WITH *
+`
+	default:
+		cypher += `			e.failures = e.failures + 1, e.last_reason = $reason
+		WITH *
+`
+	}
+
+	params := map[string]any{
+		"username":  event.Username.Name,
+		"to_user":   escalation.ToUser,
+		"host":      event.Hostname,
+		"tool":      escalation.Tool,
+		"reason":    escalation.Reason,
+		"ingestion": event.Ingestion.Format(time.RFC3339),
+	}
+
+	if escalation.Kind == types.EscalationCommand && escalation.Command != "" {
+		cypher += `
+		MERGE (command:Command {command: $command})
+		ON CREATE SET command.seen = 0
+		SET ` + spanCypher("command.first_seen", "command.last_seen", ingestionCypher) + `, command.seen = command.seen + 1
+		WITH *
+
+		MERGE (username)-[r:RAN_COMMAND {host: $host, as_user: $to_user}]->(command)
+		ON CREATE SET r.times = 0
+		SET ` + spanCypher("r.first_time", "r.last_time", ingestionCypher) + `, r.times = r.times + 1, r.pwd = $pwd, r.tty = $tty
+		WITH *
+`
+		params["command"] = escalation.Command
+		params["pwd"] = escalation.PWD
+		params["tty"] = escalation.TTY
+	}
+
+	return fmt.Sprintf("%s\nFINISH", cypher), params
+}
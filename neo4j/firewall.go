@@ -0,0 +1,83 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type neo4jFirewall struct {
+	client *Neo4jClient
+}
+
+func (n *neo4jFirewall) Name() string {
+	return "firewall_neo4j_store"
+}
+
+func NewNeo4jFirewall(client *Neo4jClient) neo4jFirewall {
+	return neo4jFirewall{
+		client: client,
+	}
+}
+
+func (n *neo4jFirewall) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" || !event.FirewallEvent.IsPresent() {
+		slog.Debug("Skipping firewall event without an IP address", "event", event)
+		return nil
+	}
+	cypher, props := n.storeCypher(event)
+
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.Error("Failed to store firewall event in Neo4j", "error", err)
+		return fmt.Errorf("failed to store firewall event in Neo4j: %w", err)
+	}
+	return nil
+}
+
+// storeCypher links the ip address to the port it sent packets to, the packets never
+// reached a service so there is no CONNECTED_TO edge
+func (n *neo4jFirewall) storeCypher(event types.ParsedEvent) (string, map[string]any) {
+	firewallEvent := event.FirewallEvent.OrElse(types.FirewallParsedEvent{})
+	cypher := `
+		MERGE (ip:IPAddress {address: $ip_address})
+		SET ` + spanCypher("ip.first_seen", "ip.last_seen", ingestionCypher) + `
+		SET ip.seen = coalesce(ip.seen, 0) + 1
+		WITH *
+
+		MERGE (port:Port {host: $host, number: $port, protocol: $protocol})
+		ON CREATE SET port.seen = 0
+		SET ` + spanCypher("port.first_seen", "port.last_seen", ingestionCypher) + `, port.seen = port.seen + 1
+		WITH *
+
+		MERGE (ip)-[p:PROBED]->(port)
+		ON CREATE SET p.times = 0, p.blocked = 0, p.allowed = 0
+		SET ` + spanCypher("p.first_time", "p.last_time", ingestionCypher) + `, p.times = p.times + 1, p.last_action = $action
+`
+	switch firewallEvent.Action {
+	case types.FirewallBlock:
+		cypher += `		SET p.blocked = p.blocked + 1
+`
+	case types.FirewallAllow:
+		cypher += `		SET p.allowed = p.allowed + 1
+`
+	}
+	cypher += `		WITH *
+		FINISH`
+
+	params := map[string]any{
+		"ip_address": event.IPAddress.Address,
+		"host":       event.Hostname,
+		"port":       firewallEvent.DestinationPort,
+		"protocol":   firewallEvent.Protocol,
+		"action":     string(firewallEvent.Action),
+		"ingestion":  event.Ingestion.Format(time.RFC3339),
+	}
+	return cypher, params
+}
@@ -0,0 +1,27 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestFirewallStoreCypher(t *testing.T) {
+	h := neo4jFirewall{}
+	cypher, props := h.storeCypher(types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: types.KernelService,
+		Hostname:    "localhost",
+		IPAddress:   types.IPAddress{Address: "203.0.113.7"},
+		FirewallEvent: opt.Some(types.FirewallParsedEvent{
+			Action:          types.FirewallBlock,
+			Prefix:          "[UFW BLOCK]",
+			Protocol:        "tcp",
+			DestinationPort: 3389,
+		}),
+	})
+	snaps.MatchSnapshot(t, cypher, props)
+}
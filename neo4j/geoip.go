@@ -0,0 +1,79 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	n "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// countryCodePattern are the iso codes that can name the variable of MergeCountryCypher
+var countryCodePattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+// SaveGeoIPData locates the ip in its country and city and links it to its ASN, the city
+// and ASN the ip was linked to before are replaced
+func (c *Neo4jClient) SaveGeoIPData(ctx context.Context, target types.IPAddress, data types.GeoIPData) error {
+	cypher, props := c.saveGeoIPCypher(target, data)
+
+	result, err := c.ExecuteWrite(ctx, func(tx n.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save GeoIP data", "ip", target.Address, "result", result, "error", err, "cypher", cypher, "props", props)
+		return fmt.Errorf("failed to save GeoIP data: %w", err)
+	}
+	return nil
+}
+
+func (c *Neo4jClient) saveGeoIPCypher(target types.IPAddress, data types.GeoIPData) (string, map[string]any) {
+	props := map[string]any{
+		"ip_address": target.Address,
+		"now":        c.now().Format(time.RFC3339),
+	}
+	cypher := `
+MERGE (ip:IPAddress {address: $ip_address})
+SET ip.geoip_enriched_at = datetime($now)
+`
+	if data.Latitude != 0 || data.Longitude != 0 {
+		cypher += "SET ip.latitude = $latitude, ip.longitude = $longitude\n"
+		props["latitude"] = data.Latitude
+		props["longitude"] = data.Longitude
+	}
+	cypher += "WITH *\n"
+
+	if code := strings.ToLower(data.CountryCode); countryCodePattern.MatchString(code) {
+		countryKey, countryCypher, countryProps := MergeCountryCypher(code, data.CountryName, nil)
+		cypher += countryCypher + MergeWithIPAddressFromWithCountry("ip", countryKey) + "\nWITH *\n"
+		cypher += detachOthersCypher("ip", "LOCATED_IN", "Country", countryKey)
+		maps.Copy(props, countryProps)
+
+		if data.City != "" {
+			cityProps := map[string]any{}
+			if data.CityID != 0 {
+				cityProps["geoname_id"] = int64(data.CityID)
+			}
+			cityKey, cityCypher, cityProps := MergeCityWithCountryCypher(1, data.City, countryKey, cityProps)
+			cypher += cityCypher + MergeSimpleRelationship("ip", "LOCATED_IN", cityKey) + "\nWITH *\n"
+			cypher += detachOthersCypher("ip", "LOCATED_IN", "City", cityKey)
+			maps.Copy(props, cityProps)
+		}
+	}
+
+	if data.ASN != 0 {
+		cypher += `MERGE (asn:ASN {number: $asn_number})
+SET asn.organization = $asn_organization
+MERGE (ip)-[:BELONGS_TO]->(asn)
+WITH *
+` + detachOthersCypher("ip", "BELONGS_TO", "ASN", "asn")
+		props["asn_number"] = int64(data.ASN)
+		props["asn_organization"] = data.ASOrganization
+	}
+	cypher += "FINISH\n"
+	return cypher, props
+}
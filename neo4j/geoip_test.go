@@ -0,0 +1,34 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestSaveGeoIPCypher(t *testing.T) {
+	c := &Neo4jClient{}
+	c.now = time_help.Now
+	t.Run("city and asn", func(t *testing.T) {
+		cypher, params := c.saveGeoIPCypher(types.IPAddress{Address: "203.0.113.7"}, types.GeoIPData{
+			CountryCode:    "PT",
+			CountryName:    "Portugal",
+			City:           "Lisbon",
+			CityID:         2267057,
+			Latitude:       38.7167,
+			Longitude:      -9.1333,
+			ASN:            64500,
+			ASOrganization: "Example Transit",
+		})
+		snaps.MatchSnapshot(t, cypher, params)
+	})
+	t.Run("asn only", func(t *testing.T) {
+		cypher, params := c.saveGeoIPCypher(types.IPAddress{Address: "203.0.113.7"}, types.GeoIPData{
+			ASN:            64500,
+			ASOrganization: "Example Transit",
+		})
+		snaps.MatchSnapshot(t, cypher, params)
+	})
+}
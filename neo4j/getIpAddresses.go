@@ -14,7 +14,7 @@ import (
 func (c *Neo4jClient) IterOverIPAddresses(ctx context.Context) (iter.Seq2[types.IPAddress, error], error) {
 	cypher := `
 MATCH (ip:IPAddress)
-RETURN ip.address AS address, ip.see AS seen, datetime(ip.last_seen) AS last_seen, datetime(ip.first_seen) AS first_seen`
+RETURN ip.address AS address, ip.seen AS seen, datetime(ip.last_seen) AS last_seen, datetime(ip.first_seen) AS first_seen`
 	props := map[string]any{}
 	result, err := c.ExecuteQuery2(ctx, cypher, props)
 	if err != nil {
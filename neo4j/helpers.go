@@ -4,42 +4,72 @@ import (
 	"fmt"
 	"maps"
 	"slices"
+	"strings"
 )
 
+// MergeCountryCypher merges the country on its lower case code, the constrained key
+// saveAIPDBCypher merges it on as well. The key is the variable bound to the country.
 func MergeCountryCypher(countryCode, country string, props map[string]any) (string, string, map[string]any) {
-	key := countryCode
-	nameKey := fmt.Sprintf("%s_name", key)
-	cypher := fmt.Sprintf("MERGE (%s:Country {name: $%s})\nSET ", key, nameKey)
+	key := fmt.Sprintf("country_%s", countryCode)
+	codeKey, nameKey := fmt.Sprintf("%s_country_code", key), fmt.Sprintf("%s_country_name", key)
+	cypher := fmt.Sprintf("MERGE (%s:Country {country_code: $%s})\n", key, codeKey)
 
-	rtnProps := make(map[string]any, len(props)+1)
+	rtnProps := make(map[string]any, len(props)+2)
+	rtnProps[codeKey] = countryCode
 	rtnProps[nameKey] = country
-	for _, k := range slices.Sorted(maps.Keys(props)) {
-		propKey := fmt.Sprintf("%s_%s", key, k)
-		rtnProps[propKey] = props[k]
-		cypher += fmt.Sprintf("%s.%s = $%s, ", key, k, propKey)
-	}
-
-	cypher = cypher[:len(cypher)-2]
-	cypher += "\nWITH * \n"
+	cypher += setCypher(key, rtnProps, props, "country_name", nameKey)
+	cypher += "WITH * \n"
 	return key, cypher, rtnProps
 }
 
+// MergeCityWithCountryCypher merges the city located in the country bound to countryKey,
+// cities are told apart by their country as names are only unique within one
 func MergeCityWithCountryCypher(idx int64, city, countryKey string, props map[string]any) (string, string, map[string]any) {
 	key := fmt.Sprintf("city_%d", idx)
 	nameKey := fmt.Sprintf("%s_name", key)
-	cypher := fmt.Sprintf("MERGE (%s)-[:LOCATED_IN]->(%s)\n", key, countryKey)
-	cypher = fmt.Sprintf("MERGE (%s:City {name: $%s})\nSET ", key, nameKey)
+	cypher := fmt.Sprintf("MERGE (%s:City {name: $%s})-[:LOCATED_IN]->(%s)\n", key, nameKey, countryKey)
 
 	rtnProps := make(map[string]any, len(props)+1)
 	rtnProps[nameKey] = city
+	cypher += setCypher(key, rtnProps, props)
+	cypher += "WITH * \n"
+	return key, cypher, rtnProps
+}
+
+// setCypher sets the props, and the given property and parameter pairs, on the variable
+// key. The props are added to params prefixed with the key.
+func setCypher(key string, params map[string]any, props map[string]any, pairs ...string) string {
+	var sets []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		sets = append(sets, fmt.Sprintf("%s.%s = $%s", key, pairs[i], pairs[i+1]))
+	}
 	for _, k := range slices.Sorted(maps.Keys(props)) {
 		propKey := fmt.Sprintf("%s_%s", key, k)
-		rtnProps[propKey] = props[k]
-		cypher += fmt.Sprintf("%s.%s = $%s, ", key, k, propKey)
+		params[propKey] = props[k]
+		sets = append(sets, fmt.Sprintf("%s.%s = $%s", key, k, propKey))
 	}
-	cypher = cypher[:len(cypher)-2]
-	cypher += "\nWITH * \n"
-	return key, cypher, rtnProps
+	if len(sets) == 0 {
+		return ""
+	}
+	return "SET " + strings.Join(sets, ", ") + "\n"
+}
+
+// ingestionCypher is the time of the event, for the stores taking it as $ingestion
+const ingestionCypher = "datetime($ingestion)"
+
+// earliestCypher and latestCypher set the property to the earliest or latest of its time
+// and at, so events stored out of order, e.g. by an import of old logs, don't move it back
+func earliestCypher(property, at string) string {
+	return fmt.Sprintf("%[1]s = CASE WHEN %[1]s IS NULL OR %[2]s < %[1]s THEN %[2]s ELSE %[1]s END", property, at)
+}
+
+func latestCypher(property, at string) string {
+	return fmt.Sprintf("%[1]s = CASE WHEN %[1]s IS NULL OR %[2]s > %[1]s THEN %[2]s ELSE %[1]s END", property, at)
+}
+
+// spanCypher widens the first and last properties to take in at
+func spanCypher(first, last, at string) string {
+	return earliestCypher(first, at) + ",\n\t\t\t" + latestCypher(last, at)
 }
 
 func MergeIPAddressCypher(idx int64, ipAddress string) (string, string) {
@@ -73,3 +103,15 @@ func MergeWithIPAressReportedWithCountry(idx int64, ipAddressKey, countryKey str
 
 	return key, cypher, rtnProps
 }
+
+// detachOthersCypher deletes the relType relationships from the node to label nodes other
+// than keep, like the country an ip was located in before
+func detachOthersCypher(from, relType, label, keep string) string {
+	return fmt.Sprintf(`CALL {
+	WITH %[1]s, %[4]s
+	MATCH (%[1]s)-[moved:%[2]s]->(previous:%[3]s)
+	WHERE previous <> %[4]s
+	DELETE moved
+}
+`, from, relType, label, keep)
+}
@@ -10,7 +10,6 @@ func TestMergeCountryCypher(t *testing.T) {
 	t.Run("test simple", func(t *testing.T) {
 		t.Parallel()
 		key, cypher, props := MergeCountryCypher("pt", "Portugal", map[string]any{
-			"country_code":  "PT",
 			"has_coastline": true,
 		})
 		snaps.MatchSnapshot(t, key, cypher, props)
@@ -20,7 +19,7 @@ func TestMergeCountryCypher(t *testing.T) {
 func TestMergeCityWithCountryCypher(t *testing.T) {
 	t.Run("test simple", func(t *testing.T) {
 		t.Parallel()
-		key, cypher, props := MergeCityWithCountryCypher(1, "Lisbon", "Portugal", map[string]any{
+		key, cypher, props := MergeCityWithCountryCypher(1, "Lisbon", "country_pt", map[string]any{
 			"population": 500000,
 			"area":       100,
 		})
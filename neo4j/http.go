@@ -0,0 +1,81 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/types"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type neo4jHTTP struct {
+	client *Neo4jClient
+}
+
+func (n *neo4jHTTP) Name() string {
+	return "http_neo4j_store"
+}
+
+func NewNeo4jHTTP(client *Neo4jClient) neo4jHTTP {
+	return neo4jHTTP{
+		client: client,
+	}
+}
+
+func (n *neo4jHTTP) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" {
+		slog.Debug("Skipping HTTP event without an IP address", "event", event)
+		return nil
+	}
+	cypher, props := n.storeCypher(event)
+
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.Error("Failed to store HTTP event in Neo4j", "error", err)
+		return fmt.Errorf("failed to store HTTP event in Neo4j: %w", err)
+	}
+	return nil
+}
+
+// storeCypher keeps the service, ip address and username like every other service and
+// links the ip address to the probe path it requested. Other paths aren't kept, every
+// visitor would add their own.
+func (n *neo4jHTTP) storeCypher(event types.ParsedEvent) (string, map[string]any) {
+	cypher, params := authCypher(event, opt.None[types.AuthAttempt](), n.client.activityBuckets())
+
+	httpEvent := event.HTTPEvent.OrElse(types.HTTPParsedEvent{})
+	if httpEvent.Probe && httpEvent.Path != "" {
+		cypher += `
+		MERGE (path:HttpPath {path: $http_path})
+		ON CREATE SET path.seen = 0
+		SET ` + spanCypher("path.first_seen", "path.last_seen", ingestionCypher) + `, path.seen = path.seen + 1,
+			path.probe = $http_probe, path.probe_category = $http_probe_category
+		WITH *
+
+		MERGE (ip)-[r:REQUESTED]->(path)
+		ON CREATE SET r.times = 0, r.methods = []
+		SET ` + spanCypher("r.first_time", "r.last_time", ingestionCypher) + `, r.times = r.times + 1,
+			r.last_status = $http_status, r.last_user_agent = $http_user_agent,
+			r.methods = CASE WHEN $http_method IN r.methods THEN r.methods ELSE r.methods + $http_method END
+		WITH *
+
+		MERGE (path)-[:SERVED_BY]->(s)
+		WITH *
+`
+		maps.Copy(params, map[string]any{
+			"http_path":           httpEvent.Path,
+			"http_probe":          httpEvent.Probe,
+			"http_probe_category": httpEvent.ProbeCategory,
+			"http_status":         httpEvent.Status,
+			"http_user_agent":     httpEvent.UserAgent,
+			"http_method":         httpEvent.Method,
+		})
+	}
+
+	return fmt.Sprintf("%s\nFINISH", cypher), params
+}
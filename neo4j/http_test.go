@@ -0,0 +1,33 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/opt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestHTTPStoreCypher(t *testing.T) {
+	h := neo4jHTTP{}
+	cypher, props := h.storeCypher(types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: types.HTTPService,
+		IPAddress:   types.IPAddress{Address: "203.0.113.9"},
+		Service: types.Service{
+			Name: "http",
+			Port: 80,
+			Host: "localhost",
+		},
+		HTTPEvent: opt.Some(types.HTTPParsedEvent{
+			Method:        "GET",
+			Path:          "/.env",
+			Status:        404,
+			UserAgent:     "zgrab/0.x",
+			Probe:         true,
+			ProbeCategory: "dotenv",
+		}),
+	})
+	snaps.MatchSnapshot(t, cypher, props)
+}
@@ -2,10 +2,12 @@ package neo4j
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/types"
 	n "github.com/neo4j/neo4j-go-driver/v5/neo4j"
 )
 
@@ -23,20 +25,42 @@ type Neo4jClient struct {
 	config  Neo4jConfig
 	context context.Context
 	now     func() time.Time
+
+	// activity are the resolutions of the ActivityBucket nodes the stores count events in
+	activity []types.ActivityResolution
 }
 
+// MustSetupNeo4jClient connects to the database of the NEO4J_* variables and brings its schema
+// up to date, NEO4J_MIGRATIONS=check only verifies it is and off skips it altogether
 func MustSetupNeo4jClient(ctx context.Context) *Neo4jClient {
-	config := Neo4jConfig{
+	client, err := NewNeo4jClient(ctx, MustLoadNeo4jConfig())
+	if err != nil {
+		panic("Failed to create Neo4j client: " + err.Error())
+	}
+	switch mode := cfg.GetOr("NEO4J_MIGRATIONS", "up"); mode {
+	case "up":
+		if _, err := client.Migrate(ctx); err != nil {
+			panic("Failed to migrate Neo4j schema: " + err.Error())
+		}
+	case "check":
+		if err := client.checkSchema(ctx); err != nil {
+			panic("Failed to check Neo4j schema: " + err.Error())
+		}
+	case "off":
+	default:
+		panic("NEO4J_MIGRATIONS must be up, check or off, got " + mode)
+	}
+	return client
+}
+
+// MustLoadNeo4jConfig reads the connection settings from the NEO4J_* variables
+func MustLoadNeo4jConfig() Neo4jConfig {
+	return Neo4jConfig{
 		URI:      cfg.Must("NEO4J_URI"),
 		Username: cfg.Must("NEO4J_USERNAME"),
 		Password: cfg.Must("NEO4J_PASSWORD"),
 		Database: cfg.Must("NEO4J_DATABASE"),
 	}
-	client, err := NewNeo4jClient(ctx, config)
-	if err != nil {
-		panic("Failed to create Neo4j client: " + err.Error())
-	}
-	return client
 }
 
 // NewNeo4jClient creates a new Neo4j client with the given configuration
@@ -151,3 +175,12 @@ func (c *Neo4jClient) ExecuteRead(
 	// Execute transaction
 	return session.ExecuteRead(ctx, work)
 }
+
+// IsTransient reports if the error may go away when retried, e.g. the database is unreachable
+// or a transaction deadlocked, rather than a query or constraint that will fail again
+func IsTransient(err error) bool {
+	var connectivity *n.ConnectivityError
+	var limit *n.TransactionExecutionLimit
+	return n.IsRetryable(err) || errors.As(err, &connectivity) || errors.As(err, &limit) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,78 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/types"
+	n "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// SaveAIPDBReport records the report of the ip to AbuseIPDB, the ip is only reported again
+// once the reporter's interval passed since last_time
+func (c *Neo4jClient) SaveAIPDBReport(ctx context.Context, target types.IPAddress, report types.AIPDBSubmission) error {
+	cypher, props := saveAIPDBReportCypher(target, report)
+
+	slog.InfoContext(ctx, "Saving AIPDB report", "ip", target.Address)
+	result, err := c.ExecuteWrite(ctx, func(tx n.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save AIPDB report", "ip", target.Address, "result", result, "error", err, "cypher", cypher, "props", props)
+		return fmt.Errorf("failed to save AIPDB report: %w", err)
+	}
+	return nil
+}
+
+func saveAIPDBReportCypher(target types.IPAddress, report types.AIPDBSubmission) (string, map[string]any) {
+	cypher := `
+MERGE (ip:IPAddress {address: $ip_address})
+MERGE (feed:ThreatFeed {name: "AbuseIPDB"})
+MERGE (ip)-[r:REPORTED_TO_ABUSEIPDB]->(feed)
+ON CREATE SET r.first_time = datetime($reported_at), r.times = 0
+SET r.last_time = datetime($reported_at),
+	r.times = r.times + 1,
+	r.categories = $categories,
+	r.last_comment = $comment,
+	r.abuse_confidence_score = $abuse_confidence_score
+FINISH
+`
+	categories := make([]int64, 0, len(report.Categories))
+	for _, category := range report.Categories {
+		categories = append(categories, int64(category))
+	}
+	return cypher, map[string]any{
+		"ip_address":             target.Address,
+		"reported_at":            report.ReportedAt.Format(time.RFC3339),
+		"categories":             categories,
+		"comment":                report.Comment,
+		"abuse_confidence_score": int64(report.AbuseConfidenceScore),
+	}
+}
+
+// GetLastReportedAt returns when the ip was last reported to AbuseIPDB, the zero time
+// when it never was
+func (c *Neo4jClient) GetLastReportedAt(ctx context.Context, target types.IPAddress) (time.Time, error) {
+	cypher := `
+MATCH (:IPAddress {address: $ip_address})-[r:REPORTED_TO_ABUSEIPDB]->(:ThreatFeed {name: "AbuseIPDB"})
+RETURN r.last_time AS last_time
+LIMIT 1
+`
+	props := map[string]any{"ip_address": target.Address}
+	res, err := c.ExecuteQuery2(ctx, cypher, props)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get last reported at", "ip", target.Address, "error", err, "cypher", cypher, "props", props)
+		return time.Time{}, fmt.Errorf("failed to get last reported at: %w", err)
+	}
+	if len(res.Records) == 0 {
+		// never reported
+		return time.Time{}, nil
+	}
+	ts, _, err := n.GetRecordValue[time.Time](res.Records[0], "last_time")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last report time: %w", err)
+	}
+	return ts, nil
+}
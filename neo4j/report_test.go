@@ -0,0 +1,20 @@
+package neo4j
+
+import (
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/EduardoOliveira/ckc/types"
+	"github.com/gkampitakis/go-snaps/snaps"
+)
+
+func TestSaveAIPDBReportCypher(t *testing.T) {
+	cypher, params := saveAIPDBReportCypher(types.IPAddress{Address: "203.0.113.7"}, types.AIPDBSubmission{
+		Categories:           []int{18, 22},
+		Comment:              "12 failed authentication attempts on sshd in 4m0s.",
+		ReportedAt:           time_help.Now(),
+		AbuseConfidenceScore: 52,
+	})
+	snaps.MatchSnapshot(t, cypher)
+	snaps.MatchSnapshot(t, params)
+}
@@ -0,0 +1,353 @@
+package neo4j
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type RetentionAction string
+
+var (
+	// DeleteRetention removes the stale nodes and their relationships
+	DeleteRetention RetentionAction = "delete"
+	// ArchiveRetention writes the stale nodes to a gzip json lines file before removing them
+	ArchiveRetention RetentionAction = "archive"
+	// CompactRetention adds the counters of the stale nodes to a monthly PrunedSummary of
+	// their label before removing them
+	CompactRetention RetentionAction = "compact"
+)
+
+// RetentionPolicy prunes the nodes of a label that weren't seen for longer than MaxAge
+type RetentionPolicy struct {
+	Label  string
+	MaxAge time.Duration
+	Action RetentionAction
+}
+
+func (p RetentionPolicy) String() string {
+	return fmt.Sprintf("%s:%s:%s", p.Label, formatAge(p.MaxAge), p.Action)
+}
+
+// staleNodes matches the stale nodes of each label as n, against $cutoff. The nodes of
+// labels with a month can be compacted, month is the month they were last seen in.
+var staleNodes = map[string]struct {
+	where string
+	month string
+}{
+	"IPAddress":      {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"Username":       {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"PublicKey":      {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"Password":       {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"Command":        {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"Download":       {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"HttpPath":       {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"Port":           {where: "n.last_seen < datetime($cutoff)", month: "n.last_seen"},
+	"SSHSession":     {where: "coalesce(n.ended_at, n.started_at) < datetime($cutoff)"},
+	"ActivityBucket": {where: "n.start < datetime($cutoff)"},
+	// enrichments age with their last enrichment, those of deleted ip addresses right away
+	"AIPDBData": {where: "NOT EXISTS { MATCH (:IPAddress)-[e:ENRICHED_BY]->(n) WHERE e.last_enrichment >= datetime($cutoff) }"},
+}
+
+// ParseRetentionPolicies reads a comma separated list of label:age:action, like
+// "IPAddress:365d:compact,AIPDBData:90d:delete". Ages are durations or a number of days.
+func ParseRetentionPolicies(value string) ([]RetentionPolicy, error) {
+	var policies []RetentionPolicy
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid retention policy %q, must be label:age:action", part)
+		}
+		label, age, action := fields[0], fields[1], RetentionAction(fields[2])
+		stale, ok := staleNodes[label]
+		if !ok {
+			return nil, fmt.Errorf("invalid retention policy %q, unknown label %s", part, label)
+		}
+		maxAge, err := parseAge(age)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention policy %q: %w", part, err)
+		}
+		switch action {
+		case DeleteRetention, ArchiveRetention:
+		case CompactRetention:
+			if stale.month == "" {
+				return nil, fmt.Errorf("invalid retention policy %q, %s nodes have no counters to compact", part, label)
+			}
+		default:
+			return nil, fmt.Errorf("invalid retention policy %q, action must be delete, archive or compact", part)
+		}
+		policies = append(policies, RetentionPolicy{Label: label, MaxAge: maxAge, Action: action})
+	}
+	return policies, nil
+}
+
+func parseAge(value string) (time.Duration, error) {
+	var age time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		age = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if age, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+	}
+	if age <= 0 {
+		return 0, fmt.Errorf("invalid age %q, must be positive", value)
+	}
+	return age, nil
+}
+
+func formatAge(age time.Duration) string {
+	if age%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", age/(24*time.Hour))
+	}
+	return age.String()
+}
+
+type PruneConfig struct {
+	Policies   []RetentionPolicy
+	ArchiveDir string // where archive policies write the nodes they remove
+	BatchSize  int    // nodes removed per transaction, defaults to 1000
+	DryRun     bool   // count the stale nodes without removing them
+}
+
+// MustLoadPruneConfig reads the retention policies from the RETENTION_* variables
+func MustLoadPruneConfig() PruneConfig {
+	policies, err := ParseRetentionPolicies(cfg.GetOr("RETENTION_POLICIES", ""))
+	if err != nil {
+		panic("Failed to load retention policies: " + err.Error())
+	}
+	return PruneConfig{
+		Policies:   policies,
+		ArchiveDir: cfg.GetOr("RETENTION_ARCHIVE_DIR", ""),
+		BatchSize:  cfg.GetIntOr("RETENTION_BATCH_SIZE", 0),
+	}
+}
+
+// PruneResult is what a policy removed, or would remove on a dry run
+type PruneResult struct {
+	Policy RetentionPolicy
+	Cutoff time.Time
+	Nodes  int64
+}
+
+// Prune applies the policies in order, the results of the policies applied are returned
+// along with the error of the first that failed
+func (c *Neo4jClient) Prune(ctx context.Context, config PruneConfig) ([]PruneResult, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1000
+	}
+	var results []PruneResult
+	for _, policy := range config.Policies {
+		if policy.Action == ArchiveRetention && config.ArchiveDir == "" && !config.DryRun {
+			return results, fmt.Errorf("retention policy %s needs an archive dir", policy)
+		}
+		result := PruneResult{Policy: policy, Cutoff: c.now().Add(-policy.MaxAge).UTC()}
+		var err error
+		switch {
+		case config.DryRun:
+			result.Nodes, err = c.countStale(ctx, result)
+		case policy.Action == ArchiveRetention:
+			result.Nodes, err = c.archiveStale(ctx, result, config)
+		default:
+			result.Nodes, err = c.removeStale(ctx, result, config.BatchSize)
+		}
+		if err != nil {
+			return results, fmt.Errorf("failed to apply retention policy %s: %w", policy, err)
+		}
+		slog.Info("Applied retention policy", "policy", policy.String(), "cutoff", result.Cutoff, "nodes", result.Nodes, "dry_run", config.DryRun)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func pruneParams(result PruneResult) map[string]any {
+	return map[string]any{
+		"cutoff": result.Cutoff.Format(time.RFC3339),
+		"label":  result.Policy.Label,
+	}
+}
+
+func countStaleCypher(label string) string {
+	return fmt.Sprintf(`
+		MATCH (n:%s) WHERE %s
+		RETURN count(n) AS nodes`, label, staleNodes[label].where)
+}
+
+func (c *Neo4jClient) countStale(ctx context.Context, result PruneResult) (int64, error) {
+	res, err := c.ExecuteRead(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		records, err := tx.Run(ctx, countStaleCypher(result.Policy.Label), pruneParams(result))
+		if err != nil {
+			return nil, err
+		}
+		record, err := records.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		nodes, _, err := neo.GetRecordValue[int64](record, "nodes")
+		return nodes, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(int64), nil
+}
+
+// removeStaleCypher deletes, or compacts, the stale nodes in transactions of batchSize nodes
+func removeStaleCypher(policy RetentionPolicy, batchSize int) string {
+	stale := staleNodes[policy.Label]
+	remove := "DETACH DELETE n"
+	if policy.Action == CompactRetention {
+		remove = fmt.Sprintf(`MERGE (summary:PrunedSummary {label: $label, month: toString(date.truncate('month', %s))})
+			ON CREATE SET summary.nodes = 0, summary.seen = 0
+			SET summary.nodes = summary.nodes + 1, summary.seen = summary.seen + coalesce(n.seen, 0),
+				summary.first_seen = CASE WHEN summary.first_seen IS NULL OR n.first_seen < summary.first_seen THEN n.first_seen ELSE summary.first_seen END,
+				summary.last_seen = CASE WHEN summary.last_seen IS NULL OR n.last_seen > summary.last_seen THEN n.last_seen ELSE summary.last_seen END
+			DETACH DELETE n`, stale.month)
+	}
+	return fmt.Sprintf(`
+		MATCH (n:%s) WHERE %s
+		CALL {
+			WITH n
+			%s
+		} IN TRANSACTIONS OF %d ROWS`, policy.Label, stale.where, remove, batchSize)
+}
+
+func (c *Neo4jClient) removeStale(ctx context.Context, result PruneResult, batchSize int) (int64, error) {
+	summary, err := c.runAutoCommit(ctx, removeStaleCypher(result.Policy, batchSize), pruneParams(result))
+	if err != nil {
+		return 0, err
+	}
+	return int64(summary.Counters().NodesDeleted()), nil
+}
+
+// archivedNode is a line of a retention archive
+type archivedNode struct {
+	Label      string         `json:"label"`
+	Properties map[string]any `json:"properties"`
+	PrunedAt   time.Time      `json:"pruned_at"`
+}
+
+// archiveStale removes the stale nodes a batch at a time, every batch is written to the
+// archive file before it's deleted. Nodes seen again since they were read aren't deleted,
+// they stay in the archive as they were.
+func (c *Neo4jClient) archiveStale(ctx context.Context, result PruneResult, config PruneConfig) (int64, error) {
+	if err := os.MkdirAll(config.ArchiveDir, 0o750); err != nil {
+		return 0, fmt.Errorf("failed to create retention archive dir: %w", err)
+	}
+	now := c.now().UTC()
+	name := fmt.Sprintf("pruned-%s-%s-%s.jsonl.gz", result.Policy.Label, now.Format("2006-01-02"), strconv.FormatInt(now.UnixNano(), 36))
+	file, err := os.OpenFile(filepath.Join(config.ArchiveDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create retention archive: %w", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+
+	params := pruneParams(result)
+	params["batch"] = config.BatchSize
+	var removed int64
+	for {
+		res, err := c.ExecuteRead(ctx, func(tx neo.ManagedTransaction) (any, error) {
+			records, err := tx.Run(ctx, fmt.Sprintf(`
+				MATCH (n:%s) WHERE %s
+				RETURN elementId(n) AS id, properties(n) AS properties
+				LIMIT $batch`, result.Policy.Label, staleNodes[result.Policy.Label].where), params)
+			if err != nil {
+				return nil, err
+			}
+			return records.Collect(ctx)
+		})
+		if err != nil {
+			return removed, err
+		}
+		records := res.([]*neo.Record)
+		if len(records) == 0 {
+			break
+		}
+
+		ids := make([]string, 0, len(records))
+		for _, record := range records {
+			id, _, err := neo.GetRecordValue[string](record, "id")
+			if err != nil {
+				return removed, err
+			}
+			properties, _, err := neo.GetRecordValue[map[string]any](record, "properties")
+			if err != nil {
+				return removed, err
+			}
+			if err := encoder.Encode(archivedNode{Label: result.Policy.Label, Properties: properties, PrunedAt: now}); err != nil {
+				return removed, fmt.Errorf("failed to write retention archive: %w", err)
+			}
+			ids = append(ids, id)
+		}
+		// what is deleted must be on disk first
+		if err := gz.Flush(); err != nil {
+			return removed, fmt.Errorf("failed to write retention archive: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			return removed, fmt.Errorf("failed to write retention archive: %w", err)
+		}
+
+		params["ids"] = ids
+		summary, err := c.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+			res, err := tx.Run(ctx, fmt.Sprintf(`
+				UNWIND $ids AS id
+				MATCH (n:%s) WHERE elementId(n) = id AND %s
+				DETACH DELETE n`, result.Policy.Label, staleNodes[result.Policy.Label].where), params)
+			if err != nil {
+				return nil, err
+			}
+			return res.Consume(ctx)
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += int64(summary.(neo.ResultSummary).Counters().NodesDeleted())
+	}
+
+	if err := gz.Close(); err != nil {
+		return removed, fmt.Errorf("failed to write retention archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return removed, fmt.Errorf("failed to write retention archive: %w", err)
+	}
+	if removed == 0 {
+		return 0, os.Remove(file.Name())
+	}
+	return removed, nil
+}
+
+// runAutoCommit runs the cypher in an implicit transaction, CALL {} IN TRANSACTIONS can't
+// run in a managed one
+func (c *Neo4jClient) runAutoCommit(ctx context.Context, cypher string, params map[string]any) (neo.ResultSummary, error) {
+	session := c.driver.NewSession(ctx, neo.SessionConfig{
+		DatabaseName: c.config.Database,
+		AccessMode:   neo.AccessModeWrite,
+	})
+	defer session.Close(ctx)
+	res, err := session.Run(ctx, cypher, params)
+	if err != nil {
+		return nil, err
+	}
+	return res.Consume(ctx)
+}
@@ -0,0 +1,49 @@
+package neo4j
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gkampitakis/go-snaps/snaps"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetentionPolicies(t *testing.T) {
+	policies, err := ParseRetentionPolicies("IPAddress:365d:compact, AIPDBData:2160h:delete,SSHSession:30d:archive,")
+	assert.NoError(t, err)
+	assert.Equal(t, []RetentionPolicy{
+		{Label: "IPAddress", MaxAge: 365 * 24 * time.Hour, Action: CompactRetention},
+		{Label: "AIPDBData", MaxAge: 90 * 24 * time.Hour, Action: DeleteRetention},
+		{Label: "SSHSession", MaxAge: 30 * 24 * time.Hour, Action: ArchiveRetention},
+	}, policies)
+	assert.Equal(t, "AIPDBData:90d:delete", policies[1].String())
+
+	policies, err = ParseRetentionPolicies("")
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+
+	for _, value := range []string{
+		"IPAddress:365d",
+		"Service:365d:delete",
+		"IPAddress:a year:delete",
+		"IPAddress:-1d:delete",
+		"IPAddress:365d:forget",
+		// there are no counters on sessions
+		"SSHSession:30d:compact",
+	} {
+		_, err := ParseRetentionPolicies(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestPruneCypher(t *testing.T) {
+	t.Run("delete", func(t *testing.T) {
+		snaps.MatchSnapshot(t, removeStaleCypher(RetentionPolicy{Label: "AIPDBData", Action: DeleteRetention}, 500))
+	})
+	t.Run("compact", func(t *testing.T) {
+		snaps.MatchSnapshot(t, removeStaleCypher(RetentionPolicy{Label: "Username", Action: CompactRetention}, 1000))
+	})
+	t.Run("dry run", func(t *testing.T) {
+		snaps.MatchSnapshot(t, countStaleCypher("ActivityBucket"))
+	})
+}
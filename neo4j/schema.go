@@ -0,0 +1,283 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	neo "github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Migration is a versioned change to the schema of the graph. Its statements must be
+// idempotent, a migration interrupted half way is applied again from the start.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+
+	// unique are the keys its constraints make unique, nodes sharing them are looked for
+	// first so the migration fails with how to merge them rather than on the constraint
+	unique []uniqueKey
+}
+
+// uniqueKey is the label and properties of a unique constraint
+type uniqueKey struct {
+	label      string
+	properties []string
+}
+
+// duplicatesCypher counts the keys shared by more than one node, and those nodes
+func (k uniqueKey) duplicatesCypher() string {
+	return k.sharedCypher() + "\nRETURN count(*) AS keys, coalesce(sum(size(nodes)), 0) AS nodes"
+}
+
+// mergeCypher merges the nodes sharing a key with APOC, the relationships of every node are
+// kept and the properties of the first one
+func (k uniqueKey) mergeCypher() string {
+	return k.sharedCypher() + `
+CALL apoc.refactor.mergeNodes(nodes, {properties: "discard", mergeRels: true}) YIELD node
+RETURN count(node)`
+}
+
+func (k uniqueKey) sharedCypher() string {
+	present := make([]string, len(k.properties))
+	grouped := make([]string, len(k.properties))
+	for i, property := range k.properties {
+		present[i] = "n." + property + " IS NOT NULL"
+		grouped[i] = "n." + property + " AS " + property
+	}
+	return fmt.Sprintf("MATCH (n:%s) WHERE %s\nWITH %s, collect(n) AS nodes WHERE size(nodes) > 1",
+		k.label, strings.Join(present, " AND "), strings.Join(grouped, ", "))
+}
+
+// migrations are applied in order, append new ones and never edit the applied ones
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "unique keys of the auth and enrichment graph",
+		Statements: []string{
+			"CREATE CONSTRAINT ip_address_address IF NOT EXISTS FOR (n:IPAddress) REQUIRE n.address IS UNIQUE",
+			"CREATE CONSTRAINT username_name IF NOT EXISTS FOR (n:Username) REQUIRE n.name IS UNIQUE",
+			"CREATE CONSTRAINT service_key IF NOT EXISTS FOR (n:Service) REQUIRE (n.name, n.port, n.host) IS UNIQUE",
+			"CREATE CONSTRAINT country_country_code IF NOT EXISTS FOR (n:Country) REQUIRE n.country_code IS UNIQUE",
+			"CREATE CONSTRAINT aipdb_data_address IF NOT EXISTS FOR (n:AIPDBData) REQUIRE n.address IS UNIQUE",
+		},
+		// graphs written before the constraints hold duplicates of these
+		unique: []uniqueKey{
+			{label: "IPAddress", properties: []string{"address"}},
+			{label: "Username", properties: []string{"name"}},
+			{label: "Service", properties: []string{"name", "port", "host"}},
+			{label: "Country", properties: []string{"country_code"}},
+			{label: "AIPDBData", properties: []string{"address"}},
+		},
+	},
+	{
+		Version:     2,
+		Description: "keys of the sshd, honeypot, firewall and http nodes",
+		Statements: []string{
+			"CREATE CONSTRAINT public_key_fingerprint IF NOT EXISTS FOR (n:PublicKey) REQUIRE n.fingerprint IS UNIQUE",
+			"CREATE CONSTRAINT ssh_session_id IF NOT EXISTS FOR (n:SSHSession) REQUIRE n.id IS UNIQUE",
+			"CREATE CONSTRAINT download_sha256 IF NOT EXISTS FOR (n:Download) REQUIRE n.sha256 IS UNIQUE",
+			"CREATE CONSTRAINT port_key IF NOT EXISTS FOR (n:Port) REQUIRE (n.host, n.number, n.protocol) IS UNIQUE",
+			// commands, passwords and paths are attacker controlled and can outgrow the key
+			// size of a constraint, text indexes take any length
+			"CREATE TEXT INDEX command_command IF NOT EXISTS FOR (n:Command) ON (n.command)",
+			"CREATE TEXT INDEX password_value IF NOT EXISTS FOR (n:Password) ON (n.value)",
+			"CREATE TEXT INDEX http_path_path IF NOT EXISTS FOR (n:HttpPath) ON (n.path)",
+		},
+	},
+	{
+		Version:     3,
+		Description: "keys of the activity buckets",
+		Statements: []string{
+			"CREATE CONSTRAINT activity_bucket_key IF NOT EXISTS FOR (n:ActivityBucket) REQUIRE (n.resolution, n.start) IS UNIQUE",
+		},
+	},
+	{
+		Version:     4,
+		Description: "keys of the retention summaries",
+		Statements: []string{
+			"CREATE CONSTRAINT pruned_summary_key IF NOT EXISTS FOR (n:PrunedSummary) REQUIRE (n.label, n.month) IS UNIQUE",
+		},
+	},
+	{
+		Version:     5,
+		Description: "keys of the threat feeds ips are reported to",
+		Statements: []string{
+			"CREATE CONSTRAINT threat_feed_name IF NOT EXISTS FOR (n:ThreatFeed) REQUIRE n.name IS UNIQUE",
+		},
+	},
+	{
+		Version:     6,
+		Description: "keys of the geoip networks and cities",
+		Statements: []string{
+			"CREATE CONSTRAINT asn_number IF NOT EXISTS FOR (n:ASN) REQUIRE n.number IS UNIQUE",
+			// cities are merged by name within their country, the name alone isn't unique
+			"CREATE INDEX city_name IF NOT EXISTS FOR (n:City) ON (n.name)",
+		},
+	},
+}
+
+// schemaID identifies the node that keeps the applied schema version
+const schemaID = "ckc"
+
+// SchemaStatus is the applied schema version against the migrations of this build
+type SchemaStatus struct {
+	Version    int
+	UpdatedAt  time.Time
+	Migrations []Migration
+}
+
+// Pending returns the migrations not applied yet
+func (s SchemaStatus) Pending() []Migration {
+	var pending []Migration
+	for _, m := range s.Migrations {
+		if m.Version > s.Version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Latest is the version the migrations of this build bring the schema to
+func (s SchemaStatus) Latest() int {
+	if len(s.Migrations) == 0 {
+		return 0
+	}
+	return s.Migrations[len(s.Migrations)-1].Version
+}
+
+// SchemaStatus reads the applied schema version
+func (c *Neo4jClient) SchemaStatus(ctx context.Context) (SchemaStatus, error) {
+	status := SchemaStatus{Migrations: migrations}
+	_, err := c.ExecuteRead(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		res, err := tx.Run(ctx, `
+			MATCH (v:SchemaVersion {id: $id})
+			RETURN v.version AS version, v.updated_at AS updated_at`,
+			map[string]any{"id": schemaID})
+		if err != nil {
+			return nil, err
+		}
+		if !res.Next(ctx) {
+			return nil, res.Err()
+		}
+		record := res.Record()
+		if version, _, err := neo.GetRecordValue[int64](record, "version"); err == nil {
+			status.Version = int(version)
+		}
+		if updatedAt, _, err := neo.GetRecordValue[time.Time](record, "updated_at"); err == nil {
+			status.UpdatedAt = updatedAt
+		}
+		return nil, res.Err()
+	})
+	if err != nil {
+		return SchemaStatus{}, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return status, nil
+}
+
+// Migrate applies the pending migrations and returns the ones it applied
+func (c *Neo4jClient) Migrate(ctx context.Context) ([]Migration, error) {
+	status, err := c.SchemaStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Version > status.Latest() {
+		slog.Warn("Neo4j schema is newer than this build", "version", status.Version, "latest", status.Latest())
+	}
+
+	var applied []Migration
+	for _, m := range status.Pending() {
+		if err := c.checkDuplicates(ctx, m); err != nil {
+			return applied, err
+		}
+		// schema changes can't share a transaction with writes, every statement gets its own
+		for _, statement := range m.Statements {
+			_, err := c.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+				res, err := tx.Run(ctx, statement, nil)
+				if err != nil {
+					return nil, err
+				}
+				return res.Consume(ctx)
+			})
+			if err != nil {
+				return applied, fmt.Errorf("failed to apply schema migration %d (%s), duplicated nodes must be merged before their constraint is created: %q: %w",
+					m.Version, m.Description, statement, err)
+			}
+		}
+		if err := c.setSchemaVersion(ctx, m.Version); err != nil {
+			return applied, err
+		}
+		slog.Info("Applied Neo4j schema migration", "version", m.Version, "description", m.Description)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// checkDuplicates fails when nodes share a key the migration makes unique, the error
+// has the cypher that merges them
+func (c *Neo4jClient) checkDuplicates(ctx context.Context, m Migration) error {
+	for _, key := range m.unique {
+		res, err := c.ExecuteRead(ctx, func(tx neo.ManagedTransaction) (any, error) {
+			res, err := tx.Run(ctx, key.duplicatesCypher(), nil)
+			if err != nil {
+				return nil, err
+			}
+			return res.Single(ctx)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to look for duplicated %s nodes: %w", key.label, err)
+		}
+		record := res.(*neo.Record)
+		keys, _, err := neo.GetRecordValue[int64](record, "keys")
+		if err != nil {
+			return err
+		}
+		if keys == 0 {
+			continue
+		}
+		nodes, _, _ := neo.GetRecordValue[int64](record, "nodes")
+		return fmt.Errorf("can't apply schema migration %d (%s), %d %s nodes share %d keys. Merge them first, "+
+			"e.g. with APOC, which keeps the properties of one of them:\n%s", m.Version, m.Description, nodes, key.label, keys, key.mergeCypher())
+	}
+	return nil
+}
+
+func (c *Neo4jClient) setSchemaVersion(ctx context.Context, version int) error {
+	_, err := c.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		res, err := tx.Run(ctx, `
+			MERGE (v:SchemaVersion {id: $id})
+			SET v.version = $version, v.updated_at = datetime($now)`,
+			map[string]any{
+				"id":      schemaID,
+				"version": version,
+				"now":     c.now().Format(time.RFC3339),
+			})
+		if err != nil {
+			return nil, err
+		}
+		return res.Consume(ctx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record schema version %d: %w", version, err)
+	}
+	return nil
+}
+
+// ErrSchemaOutdated is returned while migrations are pending and they weren't to be applied
+var ErrSchemaOutdated = errors.New("neo4j schema is outdated, run `actions migrate up`")
+
+// checkSchema fails when migrations are pending
+func (c *Neo4jClient) checkSchema(ctx context.Context) error {
+	status, err := c.SchemaStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if pending := status.Pending(); len(pending) > 0 {
+		return fmt.Errorf("%w: at version %d, %d migrations pending", ErrSchemaOutdated, status.Version, len(pending))
+	}
+	return nil
+}
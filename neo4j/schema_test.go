@@ -0,0 +1,82 @@
+package neo4j
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run("versions follow each other", func(t *testing.T) {
+		for i, m := range migrations {
+			assert.Equal(t, i+1, m.Version)
+			assert.NotEmpty(t, m.Description)
+			assert.NotEmpty(t, m.Statements)
+		}
+	})
+
+	t.Run("statements can be applied again", func(t *testing.T) {
+		for _, m := range migrations {
+			for _, statement := range m.Statements {
+				assert.Contains(t, statement, "IF NOT EXISTS")
+			}
+		}
+	})
+
+	t.Run("keys merged by the stores are unique", func(t *testing.T) {
+		var statements []string
+		for _, m := range migrations {
+			statements = append(statements, m.Statements...)
+		}
+		schema := strings.Join(statements, "\n")
+		for _, key := range []string{
+			"(n:IPAddress) REQUIRE n.address IS UNIQUE",
+			"(n:Username) REQUIRE n.name IS UNIQUE",
+			"(n:Service) REQUIRE (n.name, n.port, n.host) IS UNIQUE",
+			"(n:Country) REQUIRE n.country_code IS UNIQUE",
+			"(n:AIPDBData) REQUIRE n.address IS UNIQUE",
+		} {
+			assert.Contains(t, schema, key)
+		}
+	})
+
+	t.Run("unique keys are checked for duplicates", func(t *testing.T) {
+		for _, m := range migrations {
+			for _, key := range m.unique {
+				properties := "n." + key.properties[0]
+				if len(key.properties) > 1 {
+					properties = "(n." + strings.Join(key.properties, ", n.") + ")"
+				}
+				assert.Contains(t, strings.Join(m.Statements, "\n"), "(n:"+key.label+") REQUIRE "+properties+" IS UNIQUE")
+			}
+		}
+	})
+}
+
+func TestUniqueKeyCypher(t *testing.T) {
+	key := uniqueKey{label: "Service", properties: []string{"name", "port", "host"}}
+	assert.Equal(t, `MATCH (n:Service) WHERE n.name IS NOT NULL AND n.port IS NOT NULL AND n.host IS NOT NULL
+WITH n.name AS name, n.port AS port, n.host AS host, collect(n) AS nodes WHERE size(nodes) > 1
+RETURN count(*) AS keys, coalesce(sum(size(nodes)), 0) AS nodes`, key.duplicatesCypher())
+	assert.Equal(t, `MATCH (n:Service) WHERE n.name IS NOT NULL AND n.port IS NOT NULL AND n.host IS NOT NULL
+WITH n.name AS name, n.port AS port, n.host AS host, collect(n) AS nodes WHERE size(nodes) > 1
+CALL apoc.refactor.mergeNodes(nodes, {properties: "discard", mergeRels: true}) YIELD node
+RETURN count(node)`, key.mergeCypher())
+}
+
+func TestSchemaStatus(t *testing.T) {
+	status := SchemaStatus{Migrations: migrations}
+	assert.Equal(t, len(migrations), status.Latest())
+	assert.Equal(t, migrations, status.Pending())
+
+	status.Version = 1
+	assert.Equal(t, migrations[1:], status.Pending())
+
+	status.Version = status.Latest()
+	assert.Empty(t, status.Pending())
+
+	// a newer schema, applied by a later build
+	status.Version = status.Latest() + 1
+	assert.Empty(t, status.Pending())
+}
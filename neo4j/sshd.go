@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"time"
 
 	"github.com/EduardoOliveira/ckc/types"
@@ -25,73 +26,188 @@ func NewNeo4jSSHD(client *Neo4jClient) neo4jSSHD {
 }
 
 func (n *neo4jSSHD) Store(ctx context.Context, event types.ParsedEvent) error {
+	if event.IPAddress.Address == "" && event.SSHDEvent.OrElse(types.SSHDParsedEvent{}).SessionID == "" {
+		// e.g. kex_exchange_identification errors, sshd logs the peer on a separate line
+		slog.Debug("Skipping SSHD event without an IP address or session", "event", event)
+		return nil
+	}
 	slog.Info("Storing SSHD event in Neo4j", "event", event)
-	res, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
-		cypher, props := n.storeCypher(event)
-		_, err := n.client.ExecuteQuery(ctx, cypher, props)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute Cypher query: %w", err)
-		}
-		return nil, nil
+	cypher, props := n.storeCypher(event)
+	_, err := n.client.ExecuteWrite(ctx, func(tx neo.ManagedTransaction) (any, error) {
+		return tx.Run(ctx, cypher, props)
 	})
 	if err != nil {
 		slog.Error("Failed to store SSHD event in Neo4j", "error", err)
 		return fmt.Errorf("failed to store SSHD event in Neo4j: %w", err)
 	}
-	slog.Info("Successfully stored SSHD event in Neo4j", "result", res)
 
 	return nil
 }
 
 func (n *neo4jSSHD) storeCypher(event types.ParsedEvent) (string, map[string]any) {
+	sshdEvent := event.SSHDEvent.OrElse(types.SSHDParsedEvent{})
+	if event.IPAddress.Address == "" {
+		// session lines like pam_unix session closed don't carry the peer
+		cypher, params := n.sessionCypher(event, sshdEvent)
+		return fmt.Sprintf("%s\nFINISH", cypher), params
+	}
+
+	cypher, params := authCypher(event, sshdEvent.Attempt(), n.client.activityBuckets())
+
+	if key, ok := sshdEvent.PublicKey.Value, sshdEvent.PublicKey.IsPresent(); ok {
+		keyCypher, keyParams := n.publicKeyCypher(event, sshdEvent, *key)
+		cypher += keyCypher
+		maps.Copy(params, keyParams)
+	}
+
+	if sshdEvent.SessionID != "" {
+		sessionCypher, sessionParams := n.sessionCypher(event, sshdEvent)
+		cypher += sessionCypher
+		maps.Copy(params, sessionParams)
+	}
+
+	cypher = fmt.Sprintf("%s\nFINISH", cypher)
+
+	return cypher, params
+}
+
+// batchPart leaves the public key and session of the event to run after the batch merged its auth graph
+func (n *neo4jSSHD) batchPart(event types.ParsedEvent) (batchPart, bool) {
+	sshdEvent := event.SSHDEvent.OrElse(types.SSHDParsedEvent{})
+	if event.IPAddress.Address == "" {
+		if sshdEvent.SessionID == "" {
+			return batchPart{}, false
+		}
+		cypher, params := n.sessionCypher(event, sshdEvent)
+		return batchPart{cypher: cypher, params: params}, true
+	}
+
+	part := batchPart{auth: true, attempt: sshdEvent.Attempt()}
+	var cypher string
+	params := map[string]any{}
+	if key, ok := sshdEvent.PublicKey.Value, sshdEvent.PublicKey.IsPresent(); ok {
+		keyCypher, keyParams := n.publicKeyCypher(event, sshdEvent, *key)
+		cypher += keyCypher
+		maps.Copy(params, keyParams)
+	}
+	if sshdEvent.SessionID != "" {
+		sessionCypher, sessionParams := n.sessionCypher(event, sshdEvent)
+		cypher += sessionCypher
+		maps.Copy(params, sessionParams)
+	}
+	if cypher != "" {
+		bindCypher, bindParams := bindAuthCypher(event)
+		part.cypher = bindCypher + cypher
+		part.params = bindParams
+		maps.Copy(part.params, params)
+	}
+	return part, true
+}
+
+// publicKeyCypher links the key to the ip and username that presented it, expects ip
+// and username (if any) to be bound by storeCypher
+func (n *neo4jSSHD) publicKeyCypher(event types.ParsedEvent, sshdEvent types.SSHDParsedEvent, key types.PublicKey) (string, map[string]any) {
 	cypher := `
-		MERGE (s:Service {name: $serviceName, port: $port, host: $host})
-		ON CREATE SET s.first_seen = datetime($ingestion), s.seen = 0
-		SET s.seen = s.seen + 1
+		MERGE (key:PublicKey {fingerprint: $key_fingerprint})
+		ON CREATE SET key.type = $key_type, key.hash = $key_hash, key.seen = 0
+		SET ` + spanCypher("key.first_seen", "key.last_seen", ingestionCypher) + `, key.seen = key.seen + 1
 		WITH *
 
-		MERGE (ip:IPAddress {address: $ip_address})
-		ON CREATE SET ip.seen = 0, ip.first_seen = datetime($ingestion)
-		SET ip.last_seen = datetime($ingestion)
-		SET ip.seen = ip.seen + 1
+		MERGE (ip)-[ipk:PRESENTED_KEY]->(key)
+		ON CREATE SET ipk.times = 0
+		SET ` + spanCypher("ipk.first_time", "ipk.last_time", ingestionCypher) + `, ipk.times = ipk.times + 1
 		WITH *
-
-		MERGE (ip)-[ct:CONNECTED_TO]->(s)
-		ON CREATE SET ct.times = 0, ct.fist_time = datetime($ingestion)
-		SET ct.times = ct.times + 1, ct.last_time = datetime($ingestion)
+`
+	if event.Username.Name != "" {
+		cypher += `
+		MERGE (username)-[uk:PRESENTED_KEY]->(key)
+		ON CREATE SET uk.times = 0, uk.failures = 0, uk.successes = 0
+		SET ` + spanCypher("uk.first_time", "uk.last_time", ingestionCypher) + `, uk.times = uk.times + 1,
+`
+		if sshdEvent.Success {
+			cypher += `			uk.successes = uk.successes + 1
 		WITH *
- 
-		MERGE (username:Username {name: $username})
-		ON CREATE SET username.first_seen = datetime($ingestion), username.seen = 0
-		SET username.last_seen = datetime($ingestion), username.seen = username.seen + 1
+`
+		} else {
+			cypher += `			uk.failures = uk.failures + 1
 		WITH *
+`
+		}
+	}
 
-		MERGE (ip)-[w:WITH_USERNAME]->(username)
-		ON CREATE SET w.first_time = datetime($ingestion), w.times = 0
-		SET w.last_time = datetime($ingestion), w.times = w.times + 1
+	params := map[string]any{
+		"key_fingerprint": key.Fingerprint,
+		"key_type":        key.Type,
+		"key_hash":        key.Hash,
+	}
+	return cypher, params
+}
+
+// sessionCypher keeps the SSHSession node of the connection, when the event has an
+// IP address it expects ip, s and username (if any) to be bound by storeCypher
+func (n *neo4jSSHD) sessionCypher(event types.ParsedEvent, sshdEvent types.SSHDParsedEvent) (string, map[string]any) {
+	cypher := `
+		MERGE (sess:SSHSession {id: $session_id})
+		ON CREATE SET sess.host = $session_host, sess.pid = $pid, sess.started_at = datetime($ingestion),
+			sess.events = 0, sess.attempts = 0, sess.failures = 0, sess.successes = 0
+		SET sess.events = sess.events + 1, sess.last_event_at = datetime($ingestion)
 		WITH *
+`
+	params := map[string]any{
+		"session_id":   sshdEvent.SessionID,
+		"session_host": event.Hostname,
+		"pid":          event.PID,
+		"ingestion":    event.Ingestion.Format(time.RFC3339),
+	}
 
-		MERGE (username)-[a:AUTHENTICATED_ON]->(s)
-		ON CREATE SET a.first_time = datetime($ingestion), a.failures = 0, a.successes = 0, a.times = 0
-		SET a.last_time = datetime($ingestion), a.times = a.times + 1,
-	`
-	if event.SSHDEvent.OrElse(types.SSHDParsedEvent{}).Success {
-		cypher += `a.successes = a.successes + 1
-		`
-	} else {
-		cypher += `a.failures = a.failures + 1
-		`
+	if event.IPAddress.Address != "" {
+		cypher += `
+		SET sess.ip = $ip_address
+		MERGE (ip)-[:OPENED]->(sess)
+		MERGE (sess)-[:ON]->(s)
+		WITH *
+`
+		if sshdEvent.Port != 0 {
+			cypher += `
+		SET sess.client_port = $client_port
+		WITH *
+`
+			params["client_port"] = sshdEvent.Port
+		}
 	}
 
-	cypher = fmt.Sprintf("%s\nFINISH", cypher)
+	if sshdEvent.Kind.IsAuthAttempt() {
+		cypher += `
+		SET sess.attempts = sess.attempts + 1,
+`
+		if sshdEvent.Success {
+			cypher += `			sess.successes = sess.successes + 1, sess.outcome = 'accepted',
+			sess.authenticated_at = datetime($ingestion), sess.username = $username
+		WITH *
+`
+			if event.Username.Name != "" && event.IPAddress.Address != "" {
+				cypher += `
+		MERGE (sess)-[:AS_USER]->(username)
+		WITH *
+`
+			}
+		} else {
+			cypher += `			sess.failures = sess.failures + 1,
+			sess.outcome = CASE WHEN sess.outcome = 'accepted' THEN 'accepted' ELSE 'failed' END
+		WITH *
+`
+		}
+		params["username"] = event.Username.Name
+	}
 
-	params := map[string]any{
-		"serviceName": event.Service.Name,
-		"port":        event.Service.Port,
-		"host":        event.Service.Host,
-		"ip_address":  event.IPAddress.Address,
-		"ingestion":   event.Ingestion.Format(time.RFC3339),
-		"username":    event.Username.Name,
+	if sshdEvent.Kind.EndsSession() {
+		cypher += `
+		SET sess.ended_at = datetime($ingestion),
+			sess.duration_seconds = duration.inSeconds(sess.started_at, datetime($ingestion)).seconds,
+			sess.outcome = coalesce(sess.outcome, $end_outcome)
+		WITH *
+`
+		params["end_outcome"] = string(sshdEvent.Kind)
 	}
 
 	return cypher, params
@@ -3,6 +3,7 @@ package neo4j
 import (
 	"testing"
 
+	"github.com/EduardoOliveira/ckc/internal/opt"
 	"github.com/EduardoOliveira/ckc/internal/time_help"
 	"github.com/EduardoOliveira/ckc/types"
 	"github.com/gkampitakis/go-snaps/snaps"
@@ -23,3 +24,90 @@ func TestSSDHStoreCypher(t *testing.T) {
 	})
 	snaps.MatchSnapshot(t, cypher, props)
 }
+
+func TestSSDHStoreCypherWithoutAuthAttempt(t *testing.T) {
+	h := neo4jSSHD{}
+	cypher, props := h.storeCypher(types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: "sshd",
+		IPAddress:   types.IPAddress{Address: "127.0.0.1"},
+		Username:    types.Username{Name: "root"},
+		Service: types.Service{
+			Name: "sshd",
+			Port: 22,
+			Host: "localhost",
+		},
+		SSHDEvent: opt.Some(types.SSHDParsedEvent{
+			Kind:    types.SSHDDisconnect,
+			Preauth: true,
+		}),
+	})
+	snaps.MatchSnapshot(t, cypher, props)
+}
+
+func TestSSDHStoreCypherSession(t *testing.T) {
+	h := neo4jSSHD{}
+	t.Run("accepted", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: "sshd",
+			Hostname:    "localhost",
+			PID:         5774,
+			IPAddress:   types.IPAddress{Address: "127.0.0.1"},
+			Username:    types.Username{Name: "root"},
+			Service: types.Service{
+				Name: "sshd",
+				Port: 22,
+				Host: "localhost",
+			},
+			SSHDEvent: opt.Some(types.SSHDParsedEvent{
+				Kind:      types.SSHDAuthAccepted,
+				Success:   true,
+				Port:      29160,
+				SessionID: "localhost-5774-1",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+	t.Run("closed without ip", func(t *testing.T) {
+		cypher, props := h.storeCypher(types.ParsedEvent{
+			Ingestion:   time_help.Now(),
+			ServiceName: "sshd",
+			Hostname:    "localhost",
+			PID:         5774,
+			Username:    types.Username{Name: "root"},
+			SSHDEvent: opt.Some(types.SSHDParsedEvent{
+				Kind:      types.SSHDSessionClosed,
+				SessionID: "localhost-5774-1",
+			}),
+		})
+		snaps.MatchSnapshot(t, cypher, props)
+	})
+}
+
+func TestSSDHStoreCypherPublicKey(t *testing.T) {
+	h := neo4jSSHD{}
+	cypher, props := h.storeCypher(types.ParsedEvent{
+		Ingestion:   time_help.Now(),
+		ServiceName: "sshd",
+		IPAddress:   types.IPAddress{Address: "127.0.0.1"},
+		Username:    types.Username{Name: "vagrant"},
+		Service: types.Service{
+			Name: "sshd",
+			Port: 22,
+			Host: "localhost",
+		},
+		SSHDEvent: opt.Some(types.SSHDParsedEvent{
+			Kind:      types.SSHDAuthAccepted,
+			Success:   true,
+			Method:    "publickey",
+			Signature: "ED25519 SHA256:Zt0QyJ2yqzB3m2f4q4f0a1m8F3xq6Bf2rX5W0aC1kLs",
+			PublicKey: opt.Some(types.PublicKey{
+				Type:        "ED25519",
+				Hash:        "SHA256",
+				Fingerprint: "SHA256:Zt0QyJ2yqzB3m2f4q4f0a1m8F3xq6Bf2rX5W0aC1kLs",
+			}),
+		}),
+	})
+	snaps.MatchSnapshot(t, cypher, props)
+}
@@ -0,0 +1,412 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/logparts"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+)
+
+var ErrQueueFull = errors.New("queue is full")
+
+// ProcessFunc consumes a single queued entry, returning an error makes the queue retry it
+type ProcessFunc func(ctx context.Context, logParts syslogformat.LogParts) error
+
+// Retrier is an error of a ProcessFunc that can retry only the part of the entry that failed,
+// the queue retries it rather than processing the whole entry again
+type Retrier interface {
+	Retry(ctx context.Context) error
+}
+
+type Config struct {
+	Dir          string
+	SegmentBytes int64 // a new segment is started once the current one grows past this size
+	MaxSegments  int   // once reached new entries are rejected
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int // defaults to 20, negative retries forever
+
+	// IsRetryable tells the failures worth retrying, e.g. an unreachable database, the others
+	// are given up on right away. Every failure is retried when nil.
+	IsRetryable func(err error) bool
+	// OnGiveUp receives the entries that still failed after MaxAttempts, or failed for good
+	OnGiveUp func(ctx context.Context, logParts syslogformat.LogParts, err error)
+}
+
+// DiskQueue is a write-ahead queue of syslog entries backed by segment files.
+// Entries are appended to the newest segment and consumed from the oldest one,
+// the consumer position is checkpointed so entries survive restarts.
+type DiskQueue struct {
+	config Config
+
+	mu        sync.Mutex
+	segments  []uint64 // ids of the segments on disk, oldest first
+	writer    *os.File
+	writerID  uint64
+	writerLen int64
+
+	notify chan struct{}
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+type cursor struct {
+	Segment uint64
+	Offset  int64
+}
+
+func NewDiskQueue(config Config) (*DiskQueue, error) {
+	if config.SegmentBytes <= 0 {
+		config.SegmentBytes = 16 << 20
+	}
+	if config.MaxSegments <= 0 {
+		config.MaxSegments = 64
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 30 * time.Second
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 20
+	}
+	if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create queue dir: %w", err)
+	}
+
+	q := &DiskQueue{
+		config: config,
+		notify: make(chan struct{}, 1),
+		sleep:  sleepContext,
+	}
+
+	entries, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queue dir: %w", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			slog.Warn("Ignoring unexpected file in queue dir", "file", entry.Name())
+			continue
+		}
+		q.segments = append(q.segments, id)
+	}
+	slices.Sort(q.segments)
+
+	// always start writing on a fresh segment, a crash may have left a partial line behind
+	var next uint64 = 1
+	if len(q.segments) > 0 {
+		next = q.segments[len(q.segments)-1] + 1
+	}
+	if err := q.openWriter(next); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Handle implements the syslog server handler, entries are persisted and acknowledged immediately
+func (q *DiskQueue) Handle(logParts syslogformat.LogParts, _ int64, err error) {
+	if err != nil {
+		slog.Error("Error handling log parts: ", "error", err)
+		return
+	}
+	if err := q.Push(logParts); err != nil {
+		slog.Error("Failed to enqueue log parts, dropping", "error", err, "logParts", logParts)
+	}
+}
+
+// Push appends the log parts to the queue
+func (q *DiskQueue) Push(logParts syslogformat.LogParts) error {
+	line, err := json.Marshal(logparts.Record(logParts))
+	if err != nil {
+		return fmt.Errorf("failed to encode log parts: %w", err)
+	}
+	line = append(line, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.writerLen > 0 && q.writerLen+int64(len(line)) > q.config.SegmentBytes {
+		if len(q.segments) >= q.config.MaxSegments {
+			return ErrQueueFull
+		}
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := q.writer.Write(line)
+	q.writerLen += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to segment %d: %w", q.writerID, err)
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run consumes the queue until ctx is done, entries are retried with backoff until process succeeds,
+// fails with an error that isn't retryable or MaxAttempts is reached
+func (q *DiskQueue) Run(ctx context.Context, process ProcessFunc) error {
+	cur, err := q.readCursor()
+	if err != nil {
+		return err
+	}
+
+	for {
+		segment, ok := q.segmentAtOrAfter(cur.Segment)
+		if !ok {
+			// nothing written yet, wait for the writer
+			if err := q.wait(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if segment != cur.Segment {
+			cur = cursor{Segment: segment}
+		}
+
+		cur, err = q.consumeSegment(ctx, cur, process)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// consumeSegment processes every complete line of the segment starting at the cursor,
+// it returns once the segment was drained and is no longer being written to
+func (q *DiskQueue) consumeSegment(ctx context.Context, cur cursor, process ProcessFunc) (cursor, error) {
+	f, err := os.Open(q.segmentPath(cur.Segment))
+	if err != nil {
+		return cur, fmt.Errorf("failed to open segment %d: %w", cur.Segment, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(cur.Offset, io.SeekStart); err != nil {
+		return cur, fmt.Errorf("failed to seek segment %d: %w", cur.Segment, err)
+	}
+	reader := bufio.NewReader(f)
+
+	var pending []byte
+	var sealed bool
+	for {
+		chunk, err := reader.ReadBytes('\n')
+		pending = append(pending, chunk...)
+		if err == nil {
+			if err := q.processLine(ctx, cur, pending, process); err != nil {
+				return cur, err
+			}
+			cur.Offset += int64(len(pending))
+			pending = pending[:0]
+			if err := q.writeCursor(cur); err != nil {
+				return cur, err
+			}
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return cur, fmt.Errorf("failed to read segment %d: %w", cur.Segment, err)
+		}
+
+		if !sealed && !q.isWriting(cur.Segment) {
+			// the writer moved on, read once more to pick up anything written before it rotated
+			sealed = true
+			continue
+		}
+		if sealed {
+			if len(pending) > 0 {
+				slog.Warn("Discarding truncated queue entry", "segment", cur.Segment, "offset", cur.Offset)
+			}
+			if err := q.removeSegment(cur.Segment); err != nil {
+				return cur, err
+			}
+			return cursor{Segment: cur.Segment + 1}, nil
+		}
+		if err := q.wait(ctx); err != nil {
+			return cur, err
+		}
+	}
+}
+
+func (q *DiskQueue) processLine(ctx context.Context, cur cursor, line []byte, process ProcessFunc) error {
+	var rec logparts.Record
+	if err := json.Unmarshal(line, &rec); err != nil {
+		slog.Error("Skipping undecodable queue entry", "segment", cur.Segment, "offset", cur.Offset, "error", err)
+		return nil
+	}
+	logParts := syslogformat.LogParts(rec)
+
+	backoff := q.config.MinBackoff
+	run := func(ctx context.Context) error { return process(ctx, logParts) }
+	for attempt := 1; ; attempt++ {
+		err := run(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			// stopping, the entry is processed again on restart
+			return ctx.Err()
+		}
+		var retrier Retrier
+		if errors.As(err, &retrier) {
+			run = retrier.Retry
+		}
+		retryable := q.config.IsRetryable == nil || q.config.IsRetryable(err)
+		if !retryable || q.config.MaxAttempts > 0 && attempt >= q.config.MaxAttempts {
+			slog.Error("Giving up on queue entry", "attempts", attempt, "retryable", retryable, "error", err)
+			if q.config.OnGiveUp != nil {
+				q.config.OnGiveUp(ctx, logParts, err)
+			}
+			return nil
+		}
+		slog.Warn("Failed to process queue entry, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		if err := q.sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, q.config.MaxBackoff)
+	}
+}
+
+// Len returns the number of segments on disk, including the one being written
+func (q *DiskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.segments)
+}
+
+// Close flushes and closes the segment being written
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment %d: %w", q.writerID, err)
+	}
+	return q.writer.Close()
+}
+
+func (q *DiskQueue) rotate() error {
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("failed to sync segment %d: %w", q.writerID, err)
+	}
+	if err := q.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close segment %d: %w", q.writerID, err)
+	}
+	return q.openWriter(q.writerID + 1)
+}
+
+func (q *DiskQueue) openWriter(id uint64) error {
+	f, err := os.OpenFile(q.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open segment %d: %w", id, err)
+	}
+	q.writer = f
+	q.writerID = id
+	q.writerLen = 0
+	q.segments = append(q.segments, id)
+	return nil
+}
+
+func (q *DiskQueue) segmentAtOrAfter(id uint64) (uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, s := range q.segments {
+		if s >= id {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func (q *DiskQueue) isWriting(id uint64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.writerID == id
+}
+
+func (q *DiskQueue) removeSegment(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.Remove(q.segmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove segment %d: %w", id, err)
+	}
+	q.segments = slices.DeleteFunc(q.segments, func(s uint64) bool { return s == id })
+	return nil
+}
+
+func (q *DiskQueue) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-q.notify:
+		return nil
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func (q *DiskQueue) segmentPath(id uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func (q *DiskQueue) readCursor() (cursor, error) {
+	data, err := os.ReadFile(filepath.Join(q.config.Dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cursor{}, nil
+	}
+	if err != nil {
+		return cursor{}, fmt.Errorf("failed to read queue cursor: %w", err)
+	}
+	var cur cursor
+	if _, err := fmt.Sscanf(string(data), "%d %d", &cur.Segment, &cur.Offset); err != nil {
+		return cursor{}, fmt.Errorf("failed to parse queue cursor: %w", err)
+	}
+	return cur, nil
+}
+
+// writeCursor replaces the cursor file atomically so a crash never leaves it half written
+func (q *DiskQueue) writeCursor(cur cursor) error {
+	path := filepath.Join(q.config.Dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, fmt.Appendf(nil, "%d %d", cur.Segment, cur.Offset), 0o640); err != nil {
+		return fmt.Errorf("failed to write queue cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace queue cursor: %w", err)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
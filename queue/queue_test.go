@@ -0,0 +1,221 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/stretchr/testify/assert"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	received []syslogformat.LogParts
+	failures int
+	want     int
+	done     chan struct{}
+}
+
+func newCollector(want, failures int) *collector {
+	return &collector{want: want, failures: failures, done: make(chan struct{})}
+}
+
+func (c *collector) process(_ context.Context, logParts syslogformat.LogParts) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return errors.New("neo4j is down")
+	}
+	c.received = append(c.received, logParts)
+	if len(c.received) == c.want {
+		close(c.done)
+	}
+	return nil
+}
+
+// retryError fails its first retries and then runs done
+type retryError struct {
+	retries int
+	done    func() error
+}
+
+func (e *retryError) Error() string { return "neo4j is down" }
+
+func (e *retryError) Retry(context.Context) error {
+	if e.retries > 0 {
+		e.retries--
+		return e
+	}
+	return e.done()
+}
+
+func runUntil(t *testing.T, q *DiskQueue, c *collector) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(t.Context())
+	errs := make(chan error, 1)
+	go func() { errs <- q.Run(ctx, c.process) }()
+
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for queue entries")
+	}
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+}
+
+func TestDiskQueue(t *testing.T) {
+	t.Run("delivers in order across segments", func(t *testing.T) {
+		q, err := NewDiskQueue(Config{Dir: t.TempDir(), SegmentBytes: 128})
+		assert.NoError(t, err)
+		defer q.Close()
+
+		for _, content := range []string{"one", "two", "three", "four"} {
+			assert.NoError(t, q.Push(syslogformat.LogParts{
+				"content":   content,
+				"tag":       "sshd",
+				"timestamp": time_help.Now(),
+				"severity":  6,
+			}))
+		}
+		assert.Greater(t, q.Len(), 1)
+
+		c := newCollector(4, 0)
+		runUntil(t, q, c)
+
+		var contents []string
+		for _, logParts := range c.received {
+			contents = append(contents, logParts["content"].(string))
+		}
+		assert.Equal(t, []string{"one", "two", "three", "four"}, contents)
+		assert.Equal(t, time_help.Now(), c.received[0]["timestamp"])
+		assert.Equal(t, 6, c.received[0]["severity"])
+		assert.Equal(t, 1, q.Len())
+	})
+
+	t.Run("retries failed entries", func(t *testing.T) {
+		q, err := NewDiskQueue(Config{Dir: t.TempDir()})
+		assert.NoError(t, err)
+		defer q.Close()
+		q.sleep = func(context.Context, time.Duration) error { return nil }
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "retry me"}))
+
+		c := newCollector(1, 3)
+		runUntil(t, q, c)
+		assert.Equal(t, "retry me", c.received[0]["content"])
+	})
+
+	t.Run("retries only what failed", func(t *testing.T) {
+		q, err := NewDiskQueue(Config{Dir: t.TempDir()})
+		assert.NoError(t, err)
+		defer q.Close()
+		q.sleep = func(context.Context, time.Duration) error { return nil }
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "retry me"}))
+
+		c := newCollector(1, 0)
+		var processed int
+		retry := &retryError{retries: 2, done: func() error { return c.process(t.Context(), syslogformat.LogParts{"content": "retried"}) }}
+		ctx, cancel := context.WithCancel(t.Context())
+		errs := make(chan error, 1)
+		go func() {
+			errs <- q.Run(ctx, func(context.Context, syslogformat.LogParts) error {
+				processed++
+				return retry
+			})
+		}()
+		select {
+		case <-c.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for queue entries")
+		}
+		cancel()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+		assert.Equal(t, 1, processed)
+		assert.Equal(t, "retried", c.received[0]["content"])
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var given []syslogformat.LogParts
+		q, err := NewDiskQueue(Config{
+			Dir:         t.TempDir(),
+			MaxAttempts: 2,
+			OnGiveUp: func(_ context.Context, logParts syslogformat.LogParts, _ error) {
+				given = append(given, logParts)
+			},
+		})
+		assert.NoError(t, err)
+		defer q.Close()
+		q.sleep = func(context.Context, time.Duration) error { return nil }
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "poison"}))
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "fine"}))
+
+		c := newCollector(1, 2)
+		runUntil(t, q, c)
+		assert.Equal(t, "fine", c.received[0]["content"])
+		assert.Len(t, given, 1)
+		assert.Equal(t, "poison", given[0]["content"])
+	})
+
+	t.Run("gives up on failures that aren't retryable right away", func(t *testing.T) {
+		var given []syslogformat.LogParts
+		q, err := NewDiskQueue(Config{
+			Dir:         t.TempDir(),
+			IsRetryable: func(error) bool { return false },
+			OnGiveUp: func(_ context.Context, logParts syslogformat.LogParts, _ error) {
+				given = append(given, logParts)
+			},
+		})
+		assert.NoError(t, err)
+		defer q.Close()
+		q.sleep = func(context.Context, time.Duration) error {
+			t.Error("retried an entry that isn't retryable")
+			return nil
+		}
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "constraint violation"}))
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "fine"}))
+
+		c := newCollector(1, 1)
+		runUntil(t, q, c)
+		assert.Equal(t, "fine", c.received[0]["content"])
+		assert.Len(t, given, 1)
+		assert.Equal(t, "constraint violation", given[0]["content"])
+	})
+
+	t.Run("replays unconsumed entries after restart", func(t *testing.T) {
+		dir := t.TempDir()
+		q, err := NewDiskQueue(Config{Dir: dir})
+		assert.NoError(t, err)
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "first"}))
+		c := newCollector(1, 0)
+		runUntil(t, q, c)
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "second"}))
+		assert.NoError(t, q.Close())
+
+		q, err = NewDiskQueue(Config{Dir: dir})
+		assert.NoError(t, err)
+		defer q.Close()
+		c = newCollector(1, 0)
+		runUntil(t, q, c)
+		assert.Equal(t, "second", c.received[0]["content"])
+	})
+
+	t.Run("rejects entries when full", func(t *testing.T) {
+		q, err := NewDiskQueue(Config{Dir: t.TempDir(), SegmentBytes: 1, MaxSegments: 2})
+		assert.NoError(t, err)
+		defer q.Close()
+
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "one"}))
+		assert.NoError(t, q.Push(syslogformat.LogParts{"content": "two"}))
+		assert.ErrorIs(t, q.Push(syslogformat.LogParts{"content": "three"}), ErrQueueFull)
+	})
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/EduardoOliveira/ckc/archive"
+	"github.com/EduardoOliveira/ckc/boltdb"
+	"github.com/EduardoOliveira/ckc/enrichment"
+	"github.com/EduardoOliveira/ckc/handler"
+	"github.com/EduardoOliveira/ckc/internal/cfg"
+	"github.com/EduardoOliveira/ckc/internal/ptr"
+	"github.com/EduardoOliveira/ckc/neo4j"
+	"github.com/EduardoOliveira/ckc/types"
+)
+
+// Backend is where the stores keep the events and the enrichers what they learn about them
+type Backend struct {
+	Name string
+	// Stores are the content stores of each service
+	Stores map[types.ServiceName][]handler.ContentStore
+	// DefinitionStores keep the events of the services parsed by definitions
+	DefinitionStores []handler.ContentStore
+	Enrichments      enrichment.Store
+	Reports          enrichment.ReportStore
+	GeoIP            enrichment.GeoStore
+	Timelines        Timelines
+	// Pruner applies the retention policies, nil for backends that can't prune
+	Pruner Pruner
+
+	close func(ctx context.Context) error
+}
+
+// Timelines read the activity buckets the stores keep when ACTIVITY_BUCKETS is set
+type Timelines interface {
+	Timeline(ctx context.Context, q types.TimelineQuery) ([]types.ActivityBucket, error)
+}
+
+// Pruner removes the nodes the retention policies consider stale
+type Pruner interface {
+	Prune(ctx context.Context, config neo4j.PruneConfig) ([]neo4j.PruneResult, error)
+}
+
+// Close writes what the backend still buffers and closes it
+func (b Backend) Close(ctx context.Context) error {
+	return b.close(ctx)
+}
+
+// MustSetup opens the backend of STORAGE_BACKEND, neo4j (the default) or bolt for an
+// embedded database at BOLT_PATH. With batch the neo4j auth graph is written in batches.
+// Every event is archived as well when ARCHIVE_DIR is set, and counted in the activity
+// buckets of ACTIVITY_BUCKETS, like "hour,day".
+func MustSetup(ctx context.Context, batch bool) Backend {
+	activity, err := types.ParseActivityResolutions(cfg.GetOr("ACTIVITY_BUCKETS", ""))
+	if err != nil {
+		panic("Failed to load activity buckets: " + err.Error())
+	}
+
+	var backend Backend
+	switch name := cfg.GetOr("STORAGE_BACKEND", "neo4j"); name {
+	case "neo4j":
+		nClient := neo4j.MustSetupNeo4jClient(ctx)
+		slog.Info("Connected to Neo4j", "uri", cfg.Must("NEO4J_URI"), "database", cfg.Must("NEO4J_DATABASE"))
+		nClient.SetActivityBuckets(activity...)
+		if batch {
+			backend = newBatchedNeo4jBackend(ctx, nClient)
+		} else {
+			backend = NewNeo4jBackend(nClient, nil)
+		}
+	case "bolt":
+		bClient := boltdb.MustSetupBoltClient()
+		slog.Info("Opened bolt database", "path", cfg.GetOr("BOLT_PATH", "ckc.db"))
+		bClient.SetActivityBuckets(activity...)
+		backend = NewBoltBackend(bClient)
+	default:
+		panic("STORAGE_BACKEND must be neo4j or bolt, got " + name)
+	}
+	if len(activity) > 0 {
+		slog.Info("Activity buckets enabled", "resolutions", activity)
+	}
+
+	if dir, ok := cfg.Get("ARCHIVE_DIR"); ok {
+		events, err := archive.NewFileStore(dir)
+		if err != nil {
+			panic("Failed to setup event archive: " + err.Error())
+		}
+		backend = WithArchive(backend, events)
+		slog.Info("Event archive enabled", "dir", dir)
+	}
+	return backend
+}
+
+// WithArchive archives the events of every service after the backend stored them, so
+// events retried because the backend failed aren't archived twice
+func WithArchive(backend Backend, events *archive.FileStore) Backend {
+	stores := make(map[types.ServiceName][]handler.ContentStore, len(backend.Stores))
+	for service, serviceStores := range backend.Stores {
+		stores[service] = append(slices.Clone(serviceStores), events)
+	}
+	backend.Stores = stores
+	backend.DefinitionStores = append(slices.Clone(backend.DefinitionStores), events)
+
+	closeBackend := backend.close
+	backend.close = func(ctx context.Context) error {
+		return errors.Join(closeBackend(ctx), events.Close())
+	}
+	return backend
+}
+
+// NewNeo4jBackend keeps the events in Neo4j, when batch isn't nil it writes the events of
+// sshd and of the auth services
+func NewNeo4jBackend(client *neo4j.Neo4jClient, batch *neo4j.Neo4jBatch) Backend {
+	auth, sshd := ptr.To(neo4j.NewNeo4jAuth(client)), ptr.To(neo4j.NewNeo4jSSHD(client))
+	var authStore, sshdStore handler.ContentStore = auth, sshd
+	if batch != nil {
+		authStore = batch.Wrap(auth)
+		sshdStore = batch.Wrap(sshd)
+	}
+	escalationStore := ptr.To(neo4j.NewNeo4jEscalation(client))
+	return Backend{
+		Name: "neo4j",
+		Stores: map[types.ServiceName][]handler.ContentStore{
+			types.SSHDService: {
+				sshdStore,
+			},
+			types.HTTPService: {
+				ptr.To(neo4j.NewNeo4jHTTP(client)),
+			},
+			types.PostfixService: {
+				authStore,
+			},
+			types.DovecotService: {
+				authStore,
+			},
+			types.KernelService: {
+				ptr.To(neo4j.NewNeo4jFirewall(client)),
+			},
+			types.SudoService: {
+				escalationStore,
+			},
+			types.SuService: {
+				escalationStore,
+			},
+			types.CowrieService: {
+				ptr.To(neo4j.NewNeo4jCowrie(client)),
+			},
+		},
+		DefinitionStores: []handler.ContentStore{authStore},
+		Enrichments:      client,
+		Reports:          client,
+		GeoIP:            client,
+		Timelines:        client,
+		Pruner:           client,
+		close:            client.Close,
+	}
+}
+
+// newBatchedNeo4jBackend starts the batch writer, closing the backend stops it once the
+// batch it's writing is done
+func newBatchedNeo4jBackend(ctx context.Context, client *neo4j.Neo4jClient) Backend {
+	batch := neo4j.NewNeo4jBatch(client, neo4j.BatchConfig{
+		Size:          cfg.GetIntOr("NEO4J_BATCH_SIZE", 0),
+		FlushInterval: cfg.GetDurationOr("NEO4J_BATCH_FLUSH_INTERVAL", 0),
+		MaxBackoff:    cfg.GetDurationOr("NEO4J_BATCH_MAX_BACKOFF", 0),
+	})
+	ctx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := batch.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Neo4j batch writer stopped", "error", err)
+		}
+	}()
+
+	backend := NewNeo4jBackend(client, batch)
+	backend.close = func(ctx context.Context) error {
+		stop()
+		<-done
+		return client.Close(ctx)
+	}
+	return backend
+}
+
+// NewBoltBackend keeps the events in an embedded bolt database
+func NewBoltBackend(client *boltdb.BoltClient) Backend {
+	authStore := ptr.To(boltdb.NewBoltAuth(client))
+	escalationStore := ptr.To(boltdb.NewBoltEscalation(client))
+	return Backend{
+		Name: "bolt",
+		Stores: map[types.ServiceName][]handler.ContentStore{
+			types.SSHDService: {
+				ptr.To(boltdb.NewBoltSSHD(client)),
+			},
+			types.HTTPService: {
+				ptr.To(boltdb.NewBoltHTTP(client)),
+			},
+			types.PostfixService: {
+				authStore,
+			},
+			types.DovecotService: {
+				authStore,
+			},
+			types.KernelService: {
+				ptr.To(boltdb.NewBoltFirewall(client)),
+			},
+			types.SudoService: {
+				escalationStore,
+			},
+			types.SuService: {
+				escalationStore,
+			},
+			types.CowrieService: {
+				ptr.To(boltdb.NewBoltCowrie(client)),
+			},
+		},
+		DefinitionStores: []handler.ContentStore{authStore},
+		Enrichments:      client,
+		Reports:          client,
+		GeoIP:            client,
+		Timelines:        client,
+		close: func(context.Context) error {
+			return client.Close()
+		},
+	}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/EduardoOliveira/ckc/boltdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendsStoreTheSameServices(t *testing.T) {
+	client, err := boltdb.NewBoltClient(filepath.Join(t.TempDir(), "ckc.db"))
+	assert.NoError(t, err)
+	bolt := NewBoltBackend(client)
+	defer bolt.Close(t.Context())
+	neo := NewNeo4jBackend(nil, nil)
+
+	assert.ElementsMatch(t, slices.Collect(maps.Keys(neo.Stores)), slices.Collect(maps.Keys(bolt.Stores)))
+	for service, stores := range neo.Stores {
+		assert.Len(t, bolt.Stores[service], len(stores), service)
+	}
+}
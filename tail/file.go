@@ -0,0 +1,358 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
+	syslog "gopkg.in/mcuadros/go-syslog.v2"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// DefaultPriority is user.notice, what RFC 3164 assumes for messages without a priority
+const DefaultPriority = 13
+
+// checkpointEvery lines the offset is persisted while catching up with a large file
+const checkpointEvery = 1000
+
+type Config struct {
+	Path     string
+	StateDir string // where the offset checkpoint of the file is kept
+	Format   syslogformat.Format
+	// Priority is written before lines that don't start with one, as files written by
+	// rsyslog don't keep it, e.g. 86 (authpriv.info) for auth.log. Defaults to DefaultPriority.
+	Priority     int
+	PollInterval time.Duration
+	// Tag makes the file one of JSON lines written by a service itself, e.g. cowrie.json,
+	// rather than by syslog. Each line is handed over whole as the content of a message
+	// with the tag, Format isn't used.
+	Tag string
+}
+
+// FileTailer follows a log file written by the local syslog daemon and hands its lines to
+// a syslog handler, like the server does with the messages it receives. The offset is
+// checkpointed so a restart resumes where it stopped, and the file is reopened when
+// logrotate moves or truncates it.
+type FileTailer struct {
+	config   Config
+	hostname string // of the messages of JSON lines files
+
+	file   *os.File
+	reader *bufio.Reader
+	state  checkpoint
+	lines  int
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// checkpoint tells how far the file was read, the hash of its first line tells whether
+// the file at the path is still the one the offset belongs to
+type checkpoint struct {
+	Offset    int64  `json:"offset"`
+	FirstLine string `json:"first_line"`
+}
+
+func NewFileTailer(config Config) (*FileTailer, error) {
+	if config.Path == "" {
+		return nil, errors.New("missing path of the file to tail")
+	}
+	if config.Format == nil && config.Tag == "" {
+		return nil, errors.New("missing syslog format")
+	}
+	if config.Priority <= 0 {
+		config.Priority = DefaultPriority
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.StateDir != "" {
+		if err := os.MkdirAll(config.StateDir, 0o750); err != nil {
+			return nil, fmt.Errorf("failed to create tail state dir: %w", err)
+		}
+	}
+	hostname, _ := os.Hostname()
+	return &FileTailer{config: config, hostname: hostname, sleep: sleepContext}, nil
+}
+
+// Run follows the file until ctx is done, waiting for it to be created when missing
+func (t *FileTailer) Run(ctx context.Context, handler syslog.Handler) error {
+	state, err := t.readCheckpoint()
+	if err != nil {
+		return err
+	}
+	for {
+		err := t.open(state)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := t.sleep(ctx, t.config.PollInterval); err != nil {
+			return err
+		}
+	}
+	defer func() { t.file.Close() }()
+
+	var pending []byte
+	var draining bool
+	for {
+		chunk, err := t.reader.ReadBytes('\n')
+		pending = append(pending, chunk...)
+		if err == nil {
+			t.handleLine(handler, pending)
+			if err := t.advance(int64(len(pending)), pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read %s: %w", t.config.Path, err)
+		}
+
+		if draining {
+			// the old file won't grow anymore, its last line may miss the newline
+			if len(pending) > 0 {
+				t.handleLine(handler, pending)
+				pending = pending[:0]
+			}
+			slog.Info("Tailed file was rotated, following the new one", "path", t.config.Path)
+			draining = false
+			if err := t.open(checkpoint{}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := t.writeCheckpoint(); err != nil {
+			return err
+		}
+		switch changed, err := t.changed(int64(len(pending))); {
+		case err != nil:
+			return err
+		case changed == truncated:
+			slog.Info("Tailed file was truncated, reading from the start", "path", t.config.Path)
+			pending = pending[:0]
+			if err := t.open(checkpoint{}); err != nil {
+				return err
+			}
+			continue
+		case changed == rotated:
+			// read once more to pick up what the daemon wrote before it reopened the file
+			draining = true
+			continue
+		}
+		if err := t.sleep(ctx, t.config.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *FileTailer) handleLine(handler syslog.Handler, line []byte) {
+	var logParts syslogformat.LogParts
+	var err error
+	if t.config.Tag != "" {
+		logParts = JSONLineLogParts(line, t.config.Tag, t.hostname, t.config.Priority, time.Now())
+	} else {
+		logParts, err = ParseLine(t.config.Format, line, t.config.Priority)
+	}
+	if logParts == nil && err == nil {
+		return
+	}
+	handler.Handle(logParts, int64(len(line)), err)
+}
+
+// advance moves the offset past the line, persisting it every checkpointEvery lines
+func (t *FileTailer) advance(n int64, line []byte) error {
+	if t.state.Offset == 0 {
+		t.state.FirstLine = hashLine(line)
+	}
+	t.state.Offset += n
+	t.lines++
+	if t.lines%checkpointEvery == 0 {
+		return t.writeCheckpoint()
+	}
+	return nil
+}
+
+type change int
+
+const (
+	unchanged change = iota
+	truncated
+	rotated
+)
+
+// changed compares the open file with the one at the path, pending are the bytes read
+// past the offset that didn't make a line yet
+func (t *FileTailer) changed(pending int64) (change, error) {
+	info, err := os.Stat(t.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		// moved away and not created again yet
+		return unchanged, nil
+	}
+	if err != nil {
+		return unchanged, fmt.Errorf("failed to stat %s: %w", t.config.Path, err)
+	}
+	current, err := t.file.Stat()
+	if err != nil {
+		return unchanged, fmt.Errorf("failed to stat %s: %w", t.config.Path, err)
+	}
+	if !os.SameFile(info, current) {
+		return rotated, nil
+	}
+	if info.Size() < t.state.Offset+pending {
+		return truncated, nil
+	}
+	// truncated and written again past the offset between two polls
+	if t.state.Offset > 0 {
+		first, err := bufio.NewReader(io.NewSectionReader(t.file, 0, info.Size())).ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return unchanged, fmt.Errorf("failed to read %s: %w", t.config.Path, err)
+		}
+		if hashLine(first) != t.state.FirstLine {
+			return truncated, nil
+		}
+	}
+	return unchanged, nil
+}
+
+// open (re)opens the file at the path, resuming from the checkpoint when its first line matches
+func (t *FileTailer) open(state checkpoint) error {
+	f, err := os.Open(t.config.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", t.config.Path, err)
+	}
+	reader := bufio.NewReader(f)
+
+	if state.Offset > 0 {
+		first, err := reader.ReadBytes('\n')
+		info, statErr := f.Stat()
+		switch {
+		case err != nil && !errors.Is(err, io.EOF):
+			f.Close()
+			return fmt.Errorf("failed to read %s: %w", t.config.Path, err)
+		case statErr != nil:
+			f.Close()
+			return fmt.Errorf("failed to stat %s: %w", t.config.Path, statErr)
+		case hashLine(first) != state.FirstLine || info.Size() < state.Offset:
+			slog.Info("Tailed file changed since the checkpoint, reading from the start", "path", t.config.Path)
+			state = checkpoint{}
+		}
+		if _, err := f.Seek(state.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to seek %s: %w", t.config.Path, err)
+		}
+		reader.Reset(f)
+	}
+
+	if t.file != nil {
+		t.file.Close()
+	}
+	t.file = f
+	t.reader = reader
+	t.state = state
+	return nil
+}
+
+func (t *FileTailer) checkpointPath() string {
+	name := strings.ReplaceAll(strings.Trim(filepath.Clean(t.config.Path), string(filepath.Separator)), string(filepath.Separator), "_")
+	return filepath.Join(t.config.StateDir, name+".checkpoint")
+}
+
+func (t *FileTailer) readCheckpoint() (checkpoint, error) {
+	if t.config.StateDir == "" {
+		return checkpoint{}, nil
+	}
+	data, err := os.ReadFile(t.checkpointPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint{}, nil
+	}
+	if err != nil {
+		return checkpoint{}, fmt.Errorf("failed to read tail checkpoint: %w", err)
+	}
+	var state checkpoint
+	if err := json.Unmarshal(data, &state); err != nil {
+		return checkpoint{}, fmt.Errorf("failed to parse tail checkpoint: %w", err)
+	}
+	return state, nil
+}
+
+// writeCheckpoint replaces the checkpoint file atomically so a crash never leaves it half written
+func (t *FileTailer) writeCheckpoint() error {
+	if t.config.StateDir == "" {
+		return nil
+	}
+	data, err := json.Marshal(t.state)
+	if err != nil {
+		return fmt.Errorf("failed to encode tail checkpoint: %w", err)
+	}
+	path := t.checkpointPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write tail checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace tail checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ParseLine turns a line of a syslog file into the log parts the syslog server would hand
+// to its handler, it returns no log parts for blank lines
+func ParseLine(format syslogformat.Format, line []byte, priority int) (syslogformat.LogParts, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, nil
+	}
+	if line[0] != '<' {
+		line = append(fmt.Appendf(nil, "<%d>", priority), line...)
+	}
+	parser := format.GetParser(line)
+	err := parser.Parse()
+	return parser.Dump(), err
+}
+
+// JSONLineLogParts turns a line of a JSON lines file into the log parts of a message with
+// the tag, the line is its content as it is. It returns no log parts for blank lines.
+func JSONLineLogParts(line []byte, tag, hostname string, priority int, received time.Time) syslogformat.LogParts {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil
+	}
+	return syslogformat.LogParts{
+		"content":               string(line),
+		"hostname":              hostname,
+		"tag":                   tag,
+		"priority":              priority,
+		"facility":              priority / 8,
+		"severity":              priority % 8,
+		syslogfmt.ReceivedAtKey: received,
+	}
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
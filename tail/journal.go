@@ -0,0 +1,109 @@
+package tail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
+	syslog "gopkg.in/mcuadros/go-syslog.v2"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// maxJournalEntry bounds a line of `journalctl -o json`, long enough for kernel dumps
+const maxJournalEntry = 1 << 20
+
+// ReadJournal hands every entry of `journalctl -o json` output, e.g. piped on stdin,
+// to the syslog handler until the reader is exhausted or ctx is done
+func ReadJournal(ctx context.Context, r io.Reader, handler syslog.Handler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxJournalEntry)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		logParts, err := JournalLogParts(line, time.Now())
+		handler.Handle(logParts, int64(len(line)), err)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return nil
+}
+
+// JournalLogParts maps a journal entry exported as json to the RFC 3164 log parts the
+// syslog server produces, received is recorded as the receive time of the entry
+func JournalLogParts(line []byte, received time.Time) (syslogformat.LogParts, error) {
+	var entry map[string]any
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode journal entry: %w", err)
+	}
+
+	content, ok := journalString(entry["MESSAGE"])
+	if !ok {
+		return nil, errors.New("journal entry without a message")
+	}
+	logParts := syslogformat.LogParts{
+		"content":               content,
+		"hostname":              journalField(entry, "_HOSTNAME"),
+		"tag":                   journalField(entry, "SYSLOG_IDENTIFIER", "_COMM"),
+		syslogfmt.ReceivedAtKey: received,
+	}
+	if pid := journalField(entry, "SYSLOG_PID", "_PID"); pid != "" {
+		logParts["proc_id"] = pid
+	}
+
+	// journald keeps the facility and severity apart, the priority of a syslog message combines them
+	facility, _ := strconv.Atoi(journalField(entry, "SYSLOG_FACILITY"))
+	severity, err := strconv.Atoi(journalField(entry, "PRIORITY"))
+	if err != nil {
+		severity = 6
+	}
+	logParts["facility"] = facility
+	logParts["severity"] = severity
+	logParts["priority"] = facility*8 + severity
+
+	if usec, err := strconv.ParseInt(journalField(entry, "__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		logParts["timestamp"] = time.UnixMicro(usec).UTC()
+	}
+	return logParts, nil
+}
+
+// journalField returns the first of the fields the entry has
+func journalField(entry map[string]any, fields ...string) string {
+	for _, field := range fields {
+		if value, ok := journalString(entry[field]); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// journalString reads a field value, journald exports values that aren't valid UTF-8
+// as arrays of bytes
+func journalString(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []any:
+		b := make([]byte, 0, len(v))
+		for _, n := range v {
+			f, ok := n.(float64)
+			if !ok {
+				return "", false
+			}
+			b = append(b, byte(f))
+		}
+		return string(b), true
+	}
+	return "", false
+}
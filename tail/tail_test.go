@@ -0,0 +1,211 @@
+package tail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/syslogfmt"
+	"github.com/EduardoOliveira/ckc/internal/time_help"
+	"github.com/stretchr/testify/assert"
+	syslog "gopkg.in/mcuadros/go-syslog.v2"
+	syslogformat "gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+type collector struct {
+	mu       sync.Mutex
+	received []syslogformat.LogParts
+}
+
+func (c *collector) Handle(logParts syslogformat.LogParts, _ int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.received = append(c.received, logParts)
+	}
+}
+
+func (c *collector) contents() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var rtn []string
+	for _, logParts := range c.received {
+		rtn = append(rtn, logParts["content"].(string))
+	}
+	return rtn
+}
+
+func (c *collector) waitFor(t *testing.T, want ...string) {
+	t.Helper()
+	assert.Eventually(t, func() bool { return len(c.contents()) >= len(want) }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, c.contents())
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	assert.NoError(t, err)
+	defer f.Close()
+	for _, line := range lines {
+		_, err := f.WriteString(line + "\n")
+		assert.NoError(t, err)
+	}
+}
+
+func runTailer(t *testing.T, config Config, handler syslog.Handler) context.CancelFunc {
+	t.Helper()
+	config.Format = syslogfmt.NewFormat(syslog.Automatic)
+	config.PollInterval = 10 * time.Millisecond
+	tailer, err := NewFileTailer(config)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errs := make(chan error, 1)
+	go func() { errs <- tailer.Run(ctx, handler) }()
+	return func() {
+		cancel()
+		assert.ErrorIs(t, <-errs, context.Canceled)
+	}
+}
+
+func authLine(n int) string {
+	return "Feb 21 08:35:22 bastion sshd[5774]: Failed password for root from 116.31.116.24 port " + strings.Repeat("1", n) + " ssh2"
+}
+
+func authContent(n int) string {
+	return "Failed password for root from 116.31.116.24 port " + strings.Repeat("1", n) + " ssh2"
+}
+
+func TestFileTailer(t *testing.T) {
+	t.Run("parses lines and follows appends", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.log")
+		appendLines(t, path, authLine(1), "")
+		c := &collector{}
+		stop := runTailer(t, Config{Path: path, Priority: 86}, c)
+		defer stop()
+
+		c.waitFor(t, authContent(1))
+		appendLines(t, path, authLine(2))
+		c.waitFor(t, authContent(1), authContent(2))
+
+		logParts := c.received[0]
+		assert.Equal(t, "sshd", logParts["tag"])
+		assert.Equal(t, "5774", logParts["proc_id"])
+		assert.Equal(t, "bastion", logParts["hostname"])
+		assert.Equal(t, 10, logParts["facility"])
+	})
+
+	t.Run("json lines are the content of messages with the tag", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cowrie.json")
+		line := `{"eventid":"cowrie.login.failed","src_ip":"203.0.113.7","username":"root","password":"<123456>"}`
+		appendLines(t, path, line, "")
+		c := &collector{}
+		stop := runTailer(t, Config{Path: path, Tag: "cowrie"}, c)
+		defer stop()
+
+		c.waitFor(t, line)
+		logParts := c.received[0]
+		assert.Equal(t, "cowrie", logParts["tag"])
+		assert.Equal(t, 13, logParts["priority"])
+	})
+
+	t.Run("waits for the file to be created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "auth.log")
+		c := &collector{}
+		stop := runTailer(t, Config{Path: path}, c)
+		defer stop()
+
+		appendLines(t, path, authLine(1))
+		c.waitFor(t, authContent(1))
+	})
+
+	t.Run("follows rotation and truncation", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "auth.log")
+		appendLines(t, path, authLine(1))
+		c := &collector{}
+		stop := runTailer(t, Config{Path: path}, c)
+		defer stop()
+		c.waitFor(t, authContent(1))
+
+		// logrotate create: the file is moved away and a new one takes its place
+		assert.NoError(t, os.Rename(path, path+".1"))
+		appendLines(t, path+".1", authLine(2))
+		appendLines(t, path, authLine(3))
+		c.waitFor(t, authContent(1), authContent(2), authContent(3))
+
+		// logrotate copytruncate: the same file starts over
+		assert.NoError(t, os.Truncate(path, 0))
+		appendLines(t, path, authLine(4))
+		c.waitFor(t, authContent(1), authContent(2), authContent(3), authContent(4))
+	})
+
+	t.Run("resumes from the checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "auth.log")
+		state := filepath.Join(dir, "state")
+		appendLines(t, path, authLine(1), authLine(2))
+
+		c := &collector{}
+		stop := runTailer(t, Config{Path: path, StateDir: state}, c)
+		c.waitFor(t, authContent(1), authContent(2))
+		stop()
+
+		appendLines(t, path, authLine(3))
+		c = &collector{}
+		stop = runTailer(t, Config{Path: path, StateDir: state}, c)
+		c.waitFor(t, authContent(3))
+		stop()
+
+		// rotated while stopped, the checkpoint belongs to another file
+		assert.NoError(t, os.Remove(path))
+		appendLines(t, path, authLine(4), authLine(5), authLine(6), authLine(7))
+		c = &collector{}
+		stop = runTailer(t, Config{Path: path, StateDir: state}, c)
+		c.waitFor(t, authContent(4), authContent(5), authContent(6), authContent(7))
+		stop()
+	})
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("log parts", func(t *testing.T) {
+		logParts, err := JournalLogParts([]byte(`{"__REALTIME_TIMESTAMP":"2147483647000000","_HOSTNAME":"bastion","SYSLOG_IDENTIFIER":"sshd","_COMM":"sshd-session","_PID":"5774","SYSLOG_FACILITY":"10","PRIORITY":"6","MESSAGE":"Failed password for root from 116.31.116.24 port 29160 ssh2"}`), time_help.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, syslogformat.LogParts{
+			"content":               "Failed password for root from 116.31.116.24 port 29160 ssh2",
+			"hostname":              "bastion",
+			"tag":                   "sshd",
+			"proc_id":               "5774",
+			"facility":              10,
+			"severity":              6,
+			"priority":              86,
+			"timestamp":             time_help.Now(),
+			syslogfmt.ReceivedAtKey: time_help.Now(),
+		}, logParts)
+	})
+
+	t.Run("binary message and kernel entries", func(t *testing.T) {
+		logParts, err := JournalLogParts([]byte(`{"_HOSTNAME":"bastion","SYSLOG_IDENTIFIER":"kernel","PRIORITY":"4","MESSAGE":[91,85,70,87,93]}`), time_help.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, "[UFW]", logParts["content"])
+		assert.Equal(t, "kernel", logParts["tag"])
+		assert.Equal(t, 4, logParts["priority"])
+		_, ok := logParts["proc_id"]
+		assert.False(t, ok)
+	})
+
+	t.Run("reads every entry", func(t *testing.T) {
+		c := &collector{}
+		err := ReadJournal(t.Context(), strings.NewReader(
+			`{"SYSLOG_IDENTIFIER":"sshd","MESSAGE":"`+authContent(1)+`"}`+"\n\n"+
+				`{"SYSLOG_IDENTIFIER":"sshd"}`+"\n"+
+				`{"SYSLOG_IDENTIFIER":"sshd","MESSAGE":"`+authContent(2)+`"}`+"\n",
+		), c)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{authContent(1), authContent(2)}, c.contents())
+	})
+}
@@ -77,3 +77,12 @@ func (data *AIPDBData) ToCypher() (cypher string, params map[string]any) {
 
 	return
 }
+
+// AIPDBSubmission is a report of an ip made to AbuseIPDB
+type AIPDBSubmission struct {
+	Categories []int     `json:"categories"`
+	Comment    string    `json:"comment"`
+	ReportedAt time.Time `json:"reported_at"`
+	// AbuseConfidenceScore is the score of the ip once reported
+	AbuseConfidenceScore int `json:"abuse_confidence_score"`
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActivityResolution is the length of the ActivityBucket nodes activity is counted in
+type ActivityResolution string
+
+var (
+	HourActivity ActivityResolution = "hour"
+	DayActivity  ActivityResolution = "day"
+)
+
+// Start is the start of the bucket t falls in, in UTC
+func (r ActivityResolution) Start(t time.Time) time.Time {
+	t = t.UTC()
+	if r == DayActivity {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// ParseActivityResolutions reads a comma separated list like "hour,day", empty keeps no buckets
+func ParseActivityResolutions(value string) ([]ActivityResolution, error) {
+	var rtn []ActivityResolution
+	for _, part := range strings.Split(value, ",") {
+		switch r := ActivityResolution(strings.TrimSpace(part)); r {
+		case "":
+		case HourActivity, DayActivity:
+			rtn = append(rtn, r)
+		default:
+			return nil, fmt.Errorf("unknown activity resolution %q, must be hour or day", part)
+		}
+	}
+	return rtn, nil
+}
+
+// ActivitySubject is what a timeline is of
+type ActivitySubject string
+
+var (
+	IPAddressActivity ActivitySubject = "ip"
+	UsernameActivity  ActivitySubject = "username"
+	ServiceActivity   ActivitySubject = "service" // by service name, across ports and hosts
+)
+
+// TimelineQuery selects the activity buckets of a subject, From and To are unbounded when zero
+type TimelineQuery struct {
+	Subject    ActivitySubject
+	Value      string
+	Resolution ActivityResolution
+	From       time.Time // buckets starting at or after
+	To         time.Time // buckets starting before
+}
+
+// ActivityBucket counts the events of a subject within a bucket, attempts are the
+// authentication attempts among them
+type ActivityBucket struct {
+	Start      time.Time          `json:"start"`
+	Resolution ActivityResolution `json:"resolution"`
+	Events     int64              `json:"events"`
+	Attempts   int64              `json:"attempts"`
+	Successes  int64              `json:"successes"`
+	Failures   int64              `json:"failures"`
+}
@@ -0,0 +1,22 @@
+package types
+
+import "github.com/EduardoOliveira/ckc/internal/opt"
+
+// CowrieParsedEvent is one of the json events logged by the Cowrie honeypot
+type CowrieParsedEvent struct {
+	EventID  string                 `json:"event_id"` // e.g. cowrie.login.failed
+	Session  string                 `json:"session"`
+	Sensor   string                 `json:"sensor,omitempty"`
+	Protocol string                 `json:"protocol,omitempty"` // ssh or telnet
+	Password string                 `json:"password,omitempty"`
+	Command  string                 `json:"command,omitempty"`
+	Failed   bool                   `json:"failed,omitempty"` // the command isn't emulated by cowrie
+	Download opt.Optional[Download] `json:"download"`
+}
+
+// Download is a file an attacker fetched or uploaded into the honeypot
+type Download struct {
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url,omitempty"`
+	Upload bool   `json:"upload,omitempty"`
+}
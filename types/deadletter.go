@@ -0,0 +1,26 @@
+package types
+
+import (
+	"time"
+
+	"github.com/EduardoOliveira/ckc/internal/logparts"
+)
+
+type DeadLetterStage string
+
+var (
+	ParseStage DeadLetterStage = "parse"
+	StoreStage DeadLetterStage = "store"
+)
+
+// DeadLetter is a log entry the pipeline failed to parse or store, kept to be re-driven later
+type DeadLetter struct {
+	ID          string          `json:"id"`
+	FailedAt    time.Time       `json:"failed_at"`
+	Stage       DeadLetterStage `json:"stage"`
+	ServiceName ServiceName     `json:"service_name"`
+	Component   string          `json:"component"` // name of the failing parser or store
+	Error       string          `json:"error"`
+	Content     string          `json:"content"`
+	LogParts    logparts.Record `json:"log_parts"`
+}
@@ -0,0 +1,23 @@
+package types
+
+type EscalationKind string
+
+var (
+	EscalationCommand     EscalationKind = "command"      // sudo ran a command
+	EscalationSession     EscalationKind = "session"      // su opened a session as the target user
+	EscalationDenied      EscalationKind = "denied"       // refused by sudo or su, e.g. not in sudoers
+	EscalationAuthFailure EscalationKind = "auth_failure" // a wrong password, logged by pam_unix
+)
+
+// EscalationParsedEvent is a sudo or su invocation, the event Username is the account
+// that invoked it
+type EscalationParsedEvent struct {
+	Kind    EscalationKind `json:"kind"`
+	Tool    string         `json:"tool"` // sudo or su
+	ToUser  string         `json:"to_user"`
+	Success bool           `json:"success"`
+	Reason  string         `json:"reason,omitempty"` // e.g. "user NOT in sudoers"
+	TTY     string         `json:"tty,omitempty"`
+	PWD     string         `json:"pwd,omitempty"`
+	Command string         `json:"command,omitempty"`
+}
@@ -0,0 +1,22 @@
+package types
+
+type FirewallAction string
+
+var (
+	FirewallBlock FirewallAction = "block"
+	FirewallAllow FirewallAction = "allow"
+	FirewallAudit FirewallAction = "audit"
+	FirewallLog   FirewallAction = "log" // prefixes we can't tell the action from
+)
+
+// FirewallParsedEvent is a packet logged by iptables, nftables or UFW
+type FirewallParsedEvent struct {
+	Action          FirewallAction `json:"action"`
+	Prefix          string         `json:"prefix,omitempty"` // the log prefix, e.g. "[UFW BLOCK]"
+	InInterface     string         `json:"in_interface"`
+	Protocol        string         `json:"protocol"`
+	DestinationIP   string         `json:"destination_ip"`
+	SourcePort      int            `json:"source_port,omitempty"`
+	DestinationPort int            `json:"destination_port,omitempty"`
+	Flags           []string       `json:"flags,omitempty"` // tcp flags, e.g. SYN
+}
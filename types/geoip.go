@@ -0,0 +1,20 @@
+package types
+
+// GeoIPData is what the local GeoIP databases know of an ip, the fields of a database
+// that isn't configured are left empty
+type GeoIPData struct {
+	CountryCode string  `json:"country_code,omitempty"`
+	CountryName string  `json:"country_name,omitempty"`
+	City        string  `json:"city,omitempty"`
+	CityID      uint    `json:"city_id,omitempty"` // geoname id of the city
+	Latitude    float64 `json:"latitude,omitempty"`
+	Longitude   float64 `json:"longitude,omitempty"`
+
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_organization,omitempty"`
+}
+
+// IsZero reports if none of the databases had the ip
+func (d GeoIPData) IsZero() bool {
+	return d == GeoIPData{}
+}
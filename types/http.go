@@ -0,0 +1,15 @@
+package types
+
+type HTTPParsedEvent struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"` // without the query string
+	Query     string `json:"query,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	Status    int    `json:"status"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Probe is set when the path is one scanners look for, e.g. /.env or /wp-login.php
+	Probe         bool   `json:"probe,omitempty"`
+	ProbeCategory string `json:"probe_category,omitempty"`
+}
@@ -1,20 +1,62 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
 
 type ServiceName string
 
-var SSHDService ServiceName = "sshd"
+var (
+	SSHDService    ServiceName = "sshd"
+	HTTPService    ServiceName = "http"
+	PostfixService ServiceName = "postfix"
+	DovecotService ServiceName = "dovecot"
+	KernelService  ServiceName = "kernel"
+	SudoService    ServiceName = "sudo"
+	SuService      ServiceName = "su"
+	CowrieService  ServiceName = "cowrie"
+)
+
+// serviceTags maps the syslog tags of services registered at runtime, e.g. from parser definitions
+var serviceTags sync.Map
 
 func (s ServiceName) String() string {
 	return string(s)
 }
 
+// RegisterServiceTags makes ParseServiceName recognize the tags as the service
+func RegisterServiceTags(service ServiceName, tags ...string) {
+	for _, tag := range tags {
+		serviceTags.Store(tag, service)
+	}
+}
+
 func ParseServiceName(service string) (ServiceName, bool) {
 	switch service {
-	case SSHDService.String():
+	case SSHDService.String(), "sshd-session": // OpenSSH 9.8 moved the per connection logging to sshd-session
 		return SSHDService, true
+	case "nginx", "apache", "apache2", "httpd":
+		return HTTPService, true
+	case DovecotService.String():
+		return DovecotService, true
+	case KernelService.String():
+		return KernelService, true
+	case SudoService.String():
+		return SudoService, true
+	case SuService.String():
+		return SuService, true
+	case CowrieService.String():
+		return CowrieService, true
 	default:
+		// postfix tags carry the daemon, e.g. postfix/smtpd or postfix/submission/smtpd
+		if strings.HasPrefix(service, "postfix/") {
+			return PostfixService, true
+		}
+		if registered, ok := serviceTags.Load(service); ok {
+			return registered.(ServiceName), true
+		}
 		return ServiceName(""), false
 	}
 }
@@ -1,8 +1,69 @@
 package types
 
+import "github.com/EduardoOliveira/ckc/internal/opt"
+
+type SSHDEventKind string
+
+var (
+	SSHDAuthAccepted     SSHDEventKind = "auth_accepted"
+	SSHDAuthFailed       SSHDEventKind = "auth_failed"
+	SSHDInvalidUser      SSHDEventKind = "invalid_user"
+	SSHDMaxAuthAttempts  SSHDEventKind = "max_auth_attempts"
+	SSHDConnectionClosed SSHDEventKind = "connection_closed" // closed by the client while authenticating
+	SSHDDisconnect       SSHDEventKind = "disconnect"
+	SSHDPreauthProbe     SSHDEventKind = "preauth_probe" // connections that never got to authenticate
+	SSHDKexFailure       SSHDEventKind = "kex_failure"
+	SSHDSessionOpened    SSHDEventKind = "session_opened"
+	SSHDSessionClosed    SSHDEventKind = "session_closed"
+)
+
+// IsAuthAttempt reports if the event is the outcome of an authentication attempt. Invalid
+// user lines aren't, sshd logs the failure of the same attempt right after them.
+func (k SSHDEventKind) IsAuthAttempt() bool {
+	switch k {
+	case SSHDAuthAccepted, SSHDAuthFailed:
+		return true
+	default:
+		return false
+	}
+}
+
+// EndsSession reports if sshd is done with the connection after this event
+func (k SSHDEventKind) EndsSession() bool {
+	switch k {
+	case SSHDDisconnect, SSHDConnectionClosed, SSHDPreauthProbe, SSHDKexFailure, SSHDSessionClosed:
+		return true
+	default:
+		return false
+	}
+}
+
 type SSHDParsedEvent struct {
-	Result    string `json:"result"`
-	Success   bool   `json:"success"`
-	Method    string `json:"method"`
-	Signature string `json:"signature"`
+	Kind      SSHDEventKind `json:"kind"`
+	Result    string        `json:"result"`
+	Success   bool          `json:"success"`
+	Method    string        `json:"method"`
+	Signature string        `json:"signature"`
+	Reason    string        `json:"reason,omitempty"`
+	Preauth   bool          `json:"preauth,omitempty"`
+	Port      int           `json:"port,omitempty"`       // client side port of the connection
+	SessionID string        `json:"session_id,omitempty"` // groups the events logged by the same sshd process
+
+	PublicKey opt.Optional[PublicKey] `json:"public_key"`
+}
+
+// Attempt is the attempt counted on AUTHENTICATED_ON, disconnects and probes carry
+// a username but aren't authentication outcomes
+func (e SSHDParsedEvent) Attempt() opt.Optional[AuthAttempt] {
+	if e.Kind == "" || e.Kind.IsAuthAttempt() {
+		return opt.Some(AuthAttempt{Success: e.Success, Method: e.Method})
+	}
+	return opt.None[AuthAttempt]()
+}
+
+// PublicKey is the key a client presented, as logged by sshd e.g. "ED25519 SHA256:..."
+type PublicKey struct {
+	Type        string `json:"type"`
+	Hash        string `json:"hash"`        // SHA256 or MD5
+	Fingerprint string `json:"fingerprint"` // prefixed with the hash, as printed by ssh-keygen -l
 }
@@ -0,0 +1,17 @@
+package types
+
+// SyslogMetadata is what the syslog message carried besides the content, the RFC 5424
+// only fields are empty for RFC 3164 messages
+type SyslogMetadata struct {
+	AppName        string                       `json:"app_name,omitempty"`
+	ProcID         string                       `json:"proc_id,omitempty"`
+	MsgID          string                       `json:"msg_id,omitempty"`
+	Facility       int                          `json:"facility"`
+	FacilityName   string                       `json:"facility_name,omitempty"`
+	Severity       int                          `json:"severity"`
+	SeverityName   string                       `json:"severity_name,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	// Relay is the address of the host that delivered the message, e.g. a rsyslog relay
+	Relay   string `json:"relay,omitempty"`
+	TLSPeer string `json:"tls_peer,omitempty"`
+}
@@ -13,13 +13,29 @@ var Now = time.Now
 type ParsedEvent struct {
 	ServiceName ServiceName `json:"service_name"`
 	Hostname    string      `json:"hostname"`
+	Tag         string      `json:"tag,omitempty"` // syslog tag, e.g. postfix/submission/smtpd
+	PID         int         `json:"pid,omitempty"`
+	Content     string      `json:"content,omitempty"` // the syslog content the event was parsed from
 	Ingestion   time.Time   `json:"ingestion"`
-	IPAddress   IPAddress   `json:"ip_address"`
-	Username    Username    `json:"username"`
-	Service     Service     `json:"service"`
+	// ReceivedAt is when the syslog server got the message, ClockSkew is how far the
+	// sender timestamp is ahead of it, ClockSkewed is set when that is beyond the threshold
+	ReceivedAt  time.Time      `json:"received_at,omitzero"`
+	ClockSkew   time.Duration  `json:"clock_skew,omitempty"`
+	ClockSkewed bool           `json:"clock_skewed,omitempty"`
+	IPAddress   IPAddress      `json:"ip_address"`
+	Username    Username       `json:"username"`
+	Service     Service        `json:"service"`
+	Syslog      SyslogMetadata `json:"syslog"`
+
+	// set when the event is an authentication attempt
+	Auth opt.Optional[AuthAttempt] `json:"auth"`
 
 	// optional fields based on the event type
-	SSHDEvent opt.Optional[SSHDParsedEvent] `json:"sshd_event"`
+	SSHDEvent       opt.Optional[SSHDParsedEvent]       `json:"sshd_event"`
+	HTTPEvent       opt.Optional[HTTPParsedEvent]       `json:"http_event"`
+	FirewallEvent   opt.Optional[FirewallParsedEvent]   `json:"firewall_event"`
+	EscalationEvent opt.Optional[EscalationParsedEvent] `json:"escalation_event"`
+	CowrieEvent     opt.Optional[CowrieParsedEvent]     `json:"cowrie_event"`
 }
 
 type IPAddress struct {
@@ -116,3 +132,9 @@ func NewAuthenticatedOn(username Username, service Service, successful bool) Aut
 		successful: successful,
 	}
 }
+
+// AuthAttempt is the outcome of a login, set by parsers of services other than sshd
+type AuthAttempt struct {
+	Success bool   `json:"success"`
+	Method  string `json:"method,omitempty"`
+}